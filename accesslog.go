@@ -0,0 +1,192 @@
+package main
+
+import (
+    "context"
+    "github.com/gin-gonic/gin"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+    "log"
+    "net/http"
+    "sync"
+    "time"
+)
+
+/* with PRECISELY_ACCESS_LOG=true, every successful read of a single document is
+recorded along with who read it, apart from the history of writes. Like usage
+analytics, reads are collected in memory and written in batches, so recording a
+read never delays it */
+var accessLogEnabled bool = getEnvBool("PRECISELY_ACCESS_LOG", false)
+
+var accessLogCollectionName string = "precisely-access-log"
+
+func accessLogCollection() *mongo.Collection {
+    return getMongoClient().Database(databaseName).Collection(accessLogCollectionName)
+}
+
+//routes reading the content of a single document, by method and route
+var accessLoggedRoutes = map[string]bool{
+    "GET /documents/:id":                        true,
+    "GET /documents/:id/content":                true,
+    "GET /documents/:id/html":                   true,
+    "GET /documents/:id/print":                  true,
+    "GET /documents/:id/verify":                 true,
+    "POST /documents/:id/compare":               true,
+    "GET /documents/by-ref/:system/:externalId": true,
+}
+
+//context key under which handlers reading a document by other means than its id name the id
+const accessedDocumentKey = "accessedDocument"
+
+type DocumentAccess struct {
+    DocumentID int       `json:"-"`
+    Caller     string    `json:"caller"`   //"admin" for admin token holders, otherwise the client ip
+    Endpoint   string    `json:"endpoint"` //method and route, e.g. "GET /documents/:id/html"
+    At         time.Time `json:"at"`
+}
+
+var pendingAccesses []DocumentAccess
+var pendingAccessesMutex sync.Mutex
+
+//accesses kept for the next flush while the collection can't be written, beyond which the oldest are dropped
+const maxPendingAccesses = 10000
+
+func initAccessLog() {
+    ctx, cancel := context.WithTimeout(context.Background(), 10 * time.Second)
+    defer cancel()
+
+    _, indexErr := accessLogCollection().Indexes().CreateOne(ctx, mongo.IndexModel{
+        Keys: bson.D{{Key: "documentid", Value: 1}, {Key: "at", Value: -1}},
+    })
+
+    if indexErr != nil {
+        log.Print("Error creating index of access log collection: ", indexErr)
+    }
+}
+
+//middleware noting successful reads of single documents
+func recordAccess(ginCon *gin.Context) {
+    ginCon.Next()
+
+    endpoint := ginCon.Request.Method + " " + ginCon.FullPath()
+
+    if !accessLogEnabled || !accessLoggedRoutes[endpoint] || (ginCon.Writer.Status() != http.StatusOK && ginCon.Writer.Status() != http.StatusPartialContent) {
+        return
+    }
+
+    var id int
+
+    if accessed, named := ginCon.Get(accessedDocumentKey); named {
+        id = accessed.(int)
+    } else {
+        var toIntErr error
+
+        if id, toIntErr = toInt(getIDParam(ginCon)); toIntErr != nil {
+            return
+        }
+    }
+
+    pendingAccessesMutex.Lock()
+    defer pendingAccessesMutex.Unlock()
+
+    pendingAccesses = append(pendingAccesses, DocumentAccess{id, callerOf(ginCon), endpoint, time.Now().UTC()})
+}
+
+//write the noted reads to the access log collection
+func flushAccesses() {
+    pendingAccessesMutex.Lock()
+    accesses := pendingAccesses
+    pendingAccesses = nil
+    pendingAccessesMutex.Unlock()
+
+    if len(accesses) == 0 {
+        return
+    }
+
+    records := make([]interface{}, len(accesses))
+
+    for i, access := range accesses {
+        records[i] = access
+    }
+
+    _, insertErr := accessLogCollection().InsertMany(context.TODO(), records, options.InsertMany().SetOrdered(false))
+
+    if insertErr != nil {
+        log.Print("Error writing access log: ", insertErr)
+        var failed []DocumentAccess
+
+        for _, index := range failedWriteIndexes(insertErr, len(accesses)) {
+            failed = append(failed, accesses[index])
+        }
+
+        requeueAccesses(failed)
+    }
+}
+
+//put accesses that could not be written before those noted meanwhile, so the next flush writes them
+func requeueAccesses(accesses []DocumentAccess) {
+    pendingAccessesMutex.Lock()
+    defer pendingAccessesMutex.Unlock()
+
+    pendingAccesses = append(accesses, pendingAccesses...)
+
+    if excess := len(pendingAccesses) - maxPendingAccesses; excess > 0 {
+        log.Print("Dropped ", excess, " unwritten document accesses")
+        pendingAccesses = pendingAccesses[excess:]
+    }
+}
+
+//flush noted reads as often as usage analytics. Runs for the lifetime of the process
+func runAccessLogFlusher() {
+    for {
+        time.Sleep(time.Duration(analyticsFlushSeconds.Get()) * time.Second)
+        flushAccesses()
+    }
+}
+
+//the latest reads of a document, newest first
+func getAccessLog(id int, limit int) (DocumentStatus, []DocumentAccess) {
+    opts := options.Find().
+        SetSort(bson.D{{Key: "at", Value: -1}}).
+        SetLimit(int64(limit))
+    cursor, findErr := accessLogCollection().Find(context.TODO(), bson.D{{Key: "documentid", Value: id}}, opts)
+
+    if findErr != nil {
+        return CouldNotProceed, nil
+    }
+
+    accesses := []DocumentAccess{}
+
+    if allErr := cursor.All(context.TODO(), &accesses); allErr != nil {
+        return CouldNotProceed, nil
+    }
+
+    return OK, accesses
+}
+
+func handleGetAccessLog(ginCon *gin.Context) {
+    id, toIntErr := toInt(getIDParam(ginCon))
+
+    if toIntErr != nil {
+      sendJsonHttpResponse(ginCon, http.StatusBadRequest, HttpError{CodeValidationFailed, "requested id '" + getIDParam(ginCon) + "' is not a number"})
+      return
+    }
+
+    params := struct {
+        Limit int `query:"limit" min:"1" max:"maxListSize"`
+    }{maxListSize.Get()}
+
+    if paramsProblem := bindQuery(ginCon, &params); paramsProblem != "" {
+      sendJsonHttpResponse(ginCon, http.StatusBadRequest, HttpError{CodeValidationFailed, paramsProblem})
+      return
+    }
+
+    status, accesses := getAccessLog(id, params.Limit)
+
+    switch status {
+    case OK:
+      sendJsonHttpResponse(ginCon, http.StatusOK, accesses)
+    default:
+      sendDocumentStatus(ginCon, status, id)
+    }
+}
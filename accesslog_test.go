@@ -0,0 +1,38 @@
+package main
+
+import (
+    "testing"
+    "time"
+)
+
+func TestRequeueAccesses(t *testing.T) {
+    savedAccesses := pendingAccesses
+    defer func() { pendingAccesses = savedAccesses }()
+
+    at := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+    access := func(id int) DocumentAccess {
+        return DocumentAccess{id, "10.0.0.1", "GET /documents/:id/content", at}
+    }
+
+    //unwritten accesses go before those noted meanwhile
+    pendingAccesses = []DocumentAccess{access(3)}
+    requeueAccesses([]DocumentAccess{access(1), access(2)})
+
+    if len(pendingAccesses) != 3 || pendingAccesses[0].DocumentID != 1 || pendingAccesses[2].DocumentID != 3 {
+        t.Errorf("pending accesses are %+v", pendingAccesses)
+    }
+
+    //beyond the bound the oldest are dropped
+    pendingAccesses = []DocumentAccess{access(-1)}
+    unwritten := make([]DocumentAccess, maxPendingAccesses)
+
+    for i := range unwritten {
+        unwritten[i] = access(i)
+    }
+
+    requeueAccesses(unwritten)
+
+    if len(pendingAccesses) != maxPendingAccesses || pendingAccesses[0].DocumentID != 1 || pendingAccesses[len(pendingAccesses)-1].DocumentID != -1 {
+        t.Errorf("%d accesses pending, from %d to %d", len(pendingAccesses), pendingAccesses[0].DocumentID, pendingAccesses[len(pendingAccesses)-1].DocumentID)
+    }
+}
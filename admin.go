@@ -0,0 +1,70 @@
+package main
+
+import (
+    "crypto/subtle"
+    "github.com/gin-gonic/gin"
+    "net/http"
+    "strings"
+)
+
+/* token granting access to admin endpoints, sent as "Authorization: Bearer <token>".
+Admin endpoints are disabled while no token is configured */
+var adminToken string = getEnv("PRECISELY_ADMIN_TOKEN", "")
+
+func isAdminRequest(ginCon *gin.Context) bool {
+    if adminToken == "" {
+        return false
+    }
+
+    givenToken := strings.TrimPrefix(ginCon.GetHeader("Authorization"), "Bearer ")
+    return subtle.ConstantTimeCompare([]byte(givenToken), []byte(adminToken)) == 1
+}
+
+//middleware for routes only admins may use
+func requireAdmin(ginCon *gin.Context) {
+    if adminToken == "" {
+        sendJsonHttpResponse(ginCon, http.StatusForbidden, HttpError{CodeForbidden, "admin endpoints are disabled"})
+        ginCon.Abort()
+        return
+    }
+
+    if !isAdminRequest(ginCon) {
+        sendJsonHttpResponse(ginCon, http.StatusUnauthorized, HttpError{CodeUnauthorized, "admin token missing or invalid"})
+        ginCon.Abort()
+        return
+    }
+
+    ginCon.Next()
+}
+
+type LegalHoldRequest struct {
+    LegalHold *bool `json:"legalHold"`
+}
+
+//place or lift a legal hold, which blocks deletion of the document
+func handleSetLegalHold(ginCon *gin.Context) {
+    id, toIntErr := toInt(getIDParam(ginCon))
+
+    if toIntErr != nil {
+      sendJsonHttpResponse(ginCon, http.StatusBadRequest, HttpError{CodeValidationFailed, "requested id '" + getIDParam(ginCon) + "' is not a number"})
+      return
+    }
+
+    var request LegalHoldRequest
+
+    if bindErr := ginCon.BindJSON(&request); bindErr != nil || request.LegalHold == nil {
+      sendJsonHttpResponse(ginCon, http.StatusBadRequest, HttpError{CodeValidationFailed, "expected a json object like {\"legalHold\": true}"})
+      return
+    }
+
+    write := newWriteRequest(ginCon)
+    status, document := setLegalHold(id, *request.LegalHold, write)
+
+    switch status {
+    case OK:
+      sendConsistencyToken(ginCon, write)
+      sendJsonHttpResponse(ginCon, http.StatusOK, document)
+    default:
+      sendDocumentStatus(ginCon, status, id)
+    }
+}
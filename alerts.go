@@ -0,0 +1,268 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+    "io/ioutil"
+    "log"
+    "strconv"
+    "time"
+)
+
+/* alert rules watch the service and its documents, and post to the notification
+channels when a rule starts or stops firing. They are read at startup from the JSON
+file named by PRECISELY_ALERT_RULES_FILE, holding a list like
+[{"name": "errors", "metric": "errorRate", "threshold": 0.01}]. Every instance with
+the file may evaluate them, one at a time under a lease; whether a rule is firing is
+kept in the alerts collection, so it is not posted again when another instance takes
+over */
+var alertRulesFile string = getEnv("PRECISELY_ALERT_RULES_FILE", "")
+
+//how often rules are evaluated
+var alertCheckInterval time.Duration = time.Duration(getEnvInt("PRECISELY_ALERT_CHECK_MINUTES", 5)) * time.Minute
+
+const (
+    MetricErrorRate          = "errorRate"          //share of calls answered with 5xx over the window
+    MetricDeadLetters        = "deadLetters"        //notifications that could not be delivered
+    MetricUnchangedDocuments = "unchangedDocuments" //documents holding the metadata not written for days
+)
+
+type AlertRule struct {
+    Name          string            `json:"name"`
+    Metric        string            `json:"metric"`
+    Threshold     float64           `json:"threshold"`               //fires when the metric is above it
+    WindowMinutes int               `json:"windowMinutes,omitempty"` //for errorRate, 15 if left out
+    Metadata      map[string]string `json:"metadata,omitempty"`      //for unchangedDocuments, e.g. {"status": "sent"}
+    Days          int               `json:"days,omitempty"`          //for unchangedDocuments
+}
+
+var alertRules []AlertRule = loadAlertRules()
+
+var alertCollectionName string = "precisely-alerts"
+
+func alertCollection() *mongo.Collection {
+    return getMongoClient().Database(databaseName).Collection(alertCollectionName)
+}
+
+//state of a rule as of its last evaluation
+type alertState struct {
+    Name    string
+    Firing  bool
+    Value   float64
+    Changed time.Time //when the rule started or stopped firing
+}
+
+//the rules of the configured file. Problems of malformed rules are reported, and none of the rules loaded
+func loadAlertRules() []AlertRule {
+    if alertRulesFile == "" {
+        return nil
+    }
+
+    serialRules, readErr := ioutil.ReadFile(alertRulesFile)
+
+    if readErr != nil {
+        settingProblem("PRECISELY_ALERT_RULES_FILE names a file that can not be read: " + readErr.Error())
+        return nil
+    }
+
+    var rules []AlertRule
+
+    if parseErr := json.Unmarshal(serialRules, &rules); parseErr != nil {
+        settingProblem("alert rules of " + alertRulesFile + " are not a list of rules: " + parseErr.Error())
+        return nil
+    }
+
+    malformed := false
+    ruleProblem := func(problem string) {
+        settingProblem(problem)
+        malformed = true
+    }
+
+    for i := range rules {
+        rule := &rules[i]
+
+        if rule.Name == "" {
+            ruleProblem("alert rule " + toString(i) + " needs a name")
+        }
+
+        switch rule.Metric {
+        case MetricErrorRate:
+          if rule.WindowMinutes == 0 {
+              rule.WindowMinutes = 15
+          }
+        case MetricDeadLetters:
+        case MetricUnchangedDocuments:
+          if rule.Days < 1 {
+              ruleProblem("alert rule " + rule.Name + " needs days of at least 1")
+          }
+
+          if problem := metadataProblem(rule.Metadata); problem != "" {
+              ruleProblem("alert rule " + rule.Name + " has malformed metadata: " + problem)
+          }
+        default:
+          ruleProblem("alert rule " + rule.Name + " needs a metric of " + MetricErrorRate + ", " + MetricDeadLetters + " or " + MetricUnchangedDocuments)
+        }
+    }
+
+    if malformed {
+        return nil
+    }
+
+    log.Print("Loaded ", len(rules), " alert rules from ", alertRulesFile)
+    return rules
+}
+
+//share of calls answered with a 5xx code within the last minutes, 0 without calls
+func errorRate(minutes int) (DocumentStatus, float64) {
+    to := time.Now().UTC()
+    status, usage := getUsage(to.Add(-time.Duration(minutes) * time.Minute), to, 24 * time.Hour)
+
+    if status != OK {
+        return status, 0
+    }
+
+    var calls, errors int64
+
+    for _, rollup := range usage {
+        calls += rollup.Count
+        errors += rollup.Errors
+    }
+
+    if calls == 0 {
+        return OK, 0
+    }
+
+    return OK, float64(errors) / float64(calls)
+}
+
+func countDeadLetters() (DocumentStatus, float64) {
+    count, countErr := deadLetterCollection().CountDocuments(context.TODO(), bson.D{})
+
+    if countErr != nil {
+        return CouldNotProceed, 0
+    }
+
+    return OK, float64(count)
+}
+
+//number of documents holding the metadata values that were not created or updated for the given days
+func countUnchangedDocuments(metadata map[string]string, days int) (DocumentStatus, float64) {
+    status, ids := getMatchingIds(metadataFilter(metadata))
+
+    if status != OK || len(ids) == 0 {
+        return status, 0
+    }
+
+    since := time.Now().Add(-time.Duration(days) * 24 * time.Hour)
+    changed, changedErr := historyCollection().Distinct(context.TODO(), "documentid", bson.D{
+        {Key: "documentid", Value: bson.D{{Key: "$in", Value: ids}}},
+        {Key: "operation", Value: bson.D{{Key: "$in", Value: []string{CreateOperation, UpdateOperation}}}},
+        {Key: "at", Value: bson.D{{Key: "$gte", Value: since}}},
+    })
+
+    if changedErr != nil {
+        return CouldNotProceed, 0
+    }
+
+    return OK, float64(len(ids) - len(changed))
+}
+
+func evaluateAlertRule(rule AlertRule) (DocumentStatus, float64) {
+    switch rule.Metric {
+    case MetricErrorRate:
+      return errorRate(rule.WindowMinutes)
+    case MetricDeadLetters:
+      return countDeadLetters()
+    case MetricUnchangedDocuments:
+      return countUnchangedDocuments(rule.Metadata, rule.Days)
+    default:
+      return ImplementationError, 0
+    }
+}
+
+//whether a rule was firing as of its last evaluation, false for rules never evaluated
+func wasFiring(name string) (DocumentStatus, bool) {
+    var state alertState
+    findErr := alertCollection().FindOne(context.TODO(), bson.D{{Key: "name", Value: name}}).Decode(&state)
+
+    if findErr == mongo.ErrNoDocuments {
+        return OK, false
+    }
+
+    if findErr != nil {
+        return CouldNotProceed, false
+    }
+
+    return OK, state.Firing
+}
+
+//evaluate every rule, posting those starting or stopping to fire
+func checkAlerts(renewLease func() bool) {
+    for _, rule := range alertRules {
+        if !renewLease() {
+            return
+        }
+
+        status, value := evaluateAlertRule(rule)
+        var firedBefore bool
+
+        if status == OK {
+            status, firedBefore = wasFiring(rule.Name)
+        }
+
+        if status != OK {
+            log.Print("Error evaluating alert rule ", rule.Name)
+            continue
+        }
+
+        firing := value > rule.Threshold
+
+        if firing == firedBefore {
+            continue
+        }
+
+        _, updateErr := alertCollection().ReplaceOne(
+            context.TODO(),
+            bson.D{{Key: "name", Value: rule.Name}},
+            alertState{rule.Name, firing, value, time.Now().UTC()},
+            options.Replace().SetUpsert(true),
+        )
+
+        //posting without storing the state would post the same change again next time
+        if updateErr != nil {
+            log.Print("Error storing state of alert rule ", rule.Name, ": ", updateErr)
+            continue
+        }
+
+        description := rule.Metric + " is " + strconv.FormatFloat(value, 'g', 4, 64) + ", threshold " + strconv.FormatFloat(rule.Threshold, 'g', 4, 64)
+
+        if firing {
+            sendAlert("Alert " + rule.Name + " is firing: " + description)
+        } else {
+            sendAlert("Alert " + rule.Name + " is resolved: " + description)
+        }
+    }
+}
+
+//post an alert to every channel. Alerts are not queued in the outbox; a failed post is logged
+func sendAlert(message string) {
+    log.Print(message)
+
+    for _, channel := range notificationChannels {
+        if alertErr := channel.Alert(message); alertErr != nil {
+            log.Print("Error posting alert to ", channel.Name(), ": ", alertErr)
+        }
+    }
+}
+
+//evaluate the rules periodically, if there are any. Runs for the lifetime of the process
+func runAlertChecker() {
+    if len(alertRules) == 0 {
+        return
+    }
+
+    runWithLease("alert-checker", alertCheckInterval, checkAlerts)
+}
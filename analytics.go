@@ -0,0 +1,311 @@
+package main
+
+import (
+    "context"
+    "github.com/gin-gonic/gin"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+    "log"
+    "net/http"
+    "sort"
+    "sync"
+    "time"
+)
+
+/* calls are counted per minute, endpoint and caller in memory, then added to the
+rollup collection in one batch, so recording a call never costs a database write */
+var analyticsCollectionName string = "precisely-analytics"
+
+func analyticsCollection() *mongo.Collection {
+    return getMongoClient().Database(databaseName).Collection(analyticsCollectionName)
+}
+
+//how often counted calls are written to the rollup collection
+var analyticsFlushSeconds *tunableInt = newTunableInt(getEnvInt("PRECISELY_ANALYTICS_FLUSH_SECONDS", 10))
+
+//identifies one rollup: calls by one caller to one endpoint within one minute
+type rollupKey struct {
+    Bucket   time.Time
+    Endpoint string //method and route, e.g. "GET /documents/:id"
+    Caller   string //"admin" for admin token holders, otherwise the client ip
+}
+
+type rollupCounts struct {
+    Count          int64
+    Errors         int64 //responses with a 5xx code
+    TotalLatencyMs float64
+    MaxLatencyMs   float64
+}
+
+var pendingRollups = make(map[rollupKey]*rollupCounts)
+var pendingRollupsMutex sync.Mutex
+
+//rollups kept for the next flush while the collection can't be written, beyond which new ones are dropped
+const maxPendingRollups = 10000
+
+//add the counts of another rollup of the same key
+func (counts *rollupCounts) add(other rollupCounts) {
+    counts.Count += other.Count
+    counts.Errors += other.Errors
+    counts.TotalLatencyMs += other.TotalLatencyMs
+
+    if other.MaxLatencyMs > counts.MaxLatencyMs {
+        counts.MaxLatencyMs = other.MaxLatencyMs
+    }
+}
+
+//calls over a period, as returned by the analytics endpoint
+type UsageRollup struct {
+    Bucket       time.Time `json:"bucket"`
+    Endpoint     string    `json:"endpoint"`
+    Caller       string    `json:"caller"`
+    Count        int64     `json:"count"`
+    Errors       int64     `json:"errors"`
+    AvgLatencyMs float64   `json:"avgLatencyMs"`
+    MaxLatencyMs float64   `json:"maxLatencyMs"`
+}
+
+//stored form of a rollup, with counts kept as sums so rollups can be merged
+type storedRollup struct {
+    Bucket         time.Time
+    Endpoint       string
+    Caller         string
+    Count          int64
+    Errors         int64
+    TotalLatencyMs float64
+    MaxLatencyMs   float64
+}
+
+func initAnalytics() {
+    ctx, cancel := context.WithTimeout(context.Background(), 10 * time.Second)
+    defer cancel()
+
+    _, indexErr := analyticsCollection().Indexes().CreateOne(ctx, mongo.IndexModel{
+        Keys:    bson.D{{Key: "bucket", Value: 1}, {Key: "endpoint", Value: 1}, {Key: "caller", Value: 1}},
+        Options: options.Index().SetUnique(true),
+    })
+
+    if indexErr != nil {
+        log.Print("Error creating index of analytics collection: ", indexErr)
+    }
+}
+
+//middleware counting every call to a known route
+func recordUsage(ginCon *gin.Context) {
+    start := time.Now()
+    ginCon.Next()
+
+    route := ginCon.FullPath()
+
+    if route == "" { //no route matched
+        return
+    }
+
+    key := rollupKey{start.UTC().Truncate(time.Minute), ginCon.Request.Method + " " + route, callerOf(ginCon)}
+    latencyMs := float64(time.Since(start).Microseconds()) / 1000
+
+    pendingRollupsMutex.Lock()
+    defer pendingRollupsMutex.Unlock()
+
+    counts, exists := pendingRollups[key]
+
+    if !exists {
+        counts = &rollupCounts{}
+        pendingRollups[key] = counts
+    }
+
+    counts.Count++
+    counts.TotalLatencyMs += latencyMs
+
+    if latencyMs > counts.MaxLatencyMs {
+        counts.MaxLatencyMs = latencyMs
+    }
+
+    if ginCon.Writer.Status() >= 500 {
+        counts.Errors++
+    }
+}
+
+//"admin" for admin token holders, otherwise the client ip
+func callerOf(ginCon *gin.Context) string {
+    if isAdminRequest(ginCon) {
+        return "admin"
+    }
+
+    return ginCon.ClientIP()
+}
+
+//add the counted calls to the rollup collection
+func flushUsage() {
+    pendingRollupsMutex.Lock()
+    rollups := pendingRollups
+    pendingRollups = make(map[rollupKey]*rollupCounts)
+    pendingRollupsMutex.Unlock()
+
+    if len(rollups) == 0 {
+        return
+    }
+
+    var writes []mongo.WriteModel
+    var keys []rollupKey //of the writes, by index
+
+    for key, counts := range rollups {
+        keys = append(keys, key)
+        writes = append(writes, mongo.NewUpdateOneModel().
+            SetFilter(bson.D{{Key: "bucket", Value: key.Bucket}, {Key: "endpoint", Value: key.Endpoint}, {Key: "caller", Value: key.Caller}}).
+            SetUpdate(bson.D{
+                {Key: "$inc", Value: bson.D{
+                    {Key: "count", Value: counts.Count},
+                    {Key: "errors", Value: counts.Errors},
+                    {Key: "totallatencyms", Value: counts.TotalLatencyMs},
+                }},
+                {Key: "$max", Value: bson.D{{Key: "maxlatencyms", Value: counts.MaxLatencyMs}}},
+            }).
+            SetUpsert(true))
+    }
+
+    _, writeErr := analyticsCollection().BulkWrite(context.TODO(), writes, options.BulkWrite().SetOrdered(false))
+
+    if writeErr != nil {
+        log.Print("Error writing usage analytics: ", writeErr)
+        failed := make(map[rollupKey]*rollupCounts)
+
+        for _, index := range failedWriteIndexes(writeErr, len(writes)) {
+            failed[keys[index]] = rollups[keys[index]]
+        }
+
+        requeueRollups(failed)
+    }
+}
+
+/* merge rollups that could not be written into those counted meanwhile, so the next
+flush writes them. Rollups of keys not pending anymore are dropped beyond maxPendingRollups */
+func requeueRollups(rollups map[rollupKey]*rollupCounts) {
+    pendingRollupsMutex.Lock()
+    defer pendingRollupsMutex.Unlock()
+
+    dropped := 0
+
+    for key, counts := range rollups {
+        if pending, exists := pendingRollups[key]; exists {
+            pending.add(*counts)
+        } else if len(pendingRollups) < maxPendingRollups {
+            pendingRollups[key] = counts
+        } else {
+            dropped++
+        }
+    }
+
+    if dropped > 0 {
+        log.Print("Dropped ", dropped, " unwritten usage rollups")
+    }
+}
+
+//flush counted calls periodically. Runs for the lifetime of the process
+func runUsageFlusher() {
+    for {
+        time.Sleep(time.Duration(analyticsFlushSeconds.Get()) * time.Second)
+        flushUsage()
+    }
+}
+
+//buckets the analytics endpoint can group by
+var analyticsIntervals = map[string]time.Duration{
+    "minute": time.Minute,
+    "hour":   time.Hour,
+    "day":    24 * time.Hour,
+}
+
+/* calls between from and to, merged into buckets of the given interval per endpoint
+and caller, ordered by bucket, then endpoint, then caller */
+func getUsage(from time.Time, to time.Time, interval time.Duration) (DocumentStatus, []UsageRollup) {
+    filter := bson.D{{Key: "bucket", Value: bson.D{{Key: "$gte", Value: from}, {Key: "$lt", Value: to}}}}
+    cursor, findErr := analyticsCollection().Find(context.TODO(), filter)
+
+    if findErr != nil {
+        return CouldNotProceed, nil
+    }
+
+    var stored []storedRollup
+
+    if allErr := cursor.All(context.TODO(), &stored); allErr != nil {
+        return CouldNotProceed, nil
+    }
+
+    merged := make(map[rollupKey]*storedRollup)
+
+    for _, rollup := range stored {
+        key := rollupKey{rollup.Bucket.UTC().Truncate(interval), rollup.Endpoint, rollup.Caller}
+        sum, exists := merged[key]
+
+        if !exists {
+            sum = &storedRollup{Bucket: key.Bucket, Endpoint: key.Endpoint, Caller: key.Caller}
+            merged[key] = sum
+        }
+
+        sum.Count += rollup.Count
+        sum.Errors += rollup.Errors
+        sum.TotalLatencyMs += rollup.TotalLatencyMs
+
+        if rollup.MaxLatencyMs > sum.MaxLatencyMs {
+            sum.MaxLatencyMs = rollup.MaxLatencyMs
+        }
+    }
+
+    usage := make([]UsageRollup, 0, len(merged))
+
+    for _, sum := range merged {
+        average := 0.0
+
+        if sum.Count > 0 {
+            average = sum.TotalLatencyMs / float64(sum.Count)
+        }
+
+        usage = append(usage, UsageRollup{sum.Bucket, sum.Endpoint, sum.Caller, sum.Count, sum.Errors, average, sum.MaxLatencyMs})
+    }
+
+    sort.Slice(usage, func(i, j int) bool {
+        if !usage[i].Bucket.Equal(usage[j].Bucket) {
+            return usage[i].Bucket.Before(usage[j].Bucket)
+        }
+
+        if usage[i].Endpoint != usage[j].Endpoint {
+            return usage[i].Endpoint < usage[j].Endpoint
+        }
+
+        return usage[i].Caller < usage[j].Caller
+    })
+
+    return OK, usage
+}
+
+//query parameters of GET /admin/analytics, see params.go
+type AnalyticsParams struct {
+    From     time.Time `query:"from"`
+    To       time.Time `query:"to"`
+    Interval string    `query:"interval" enum:"minute,hour,day"` //see analyticsIntervals
+}
+
+func handleGetAnalytics(ginCon *gin.Context) {
+    params := AnalyticsParams{To: time.Now().UTC(), Interval: "hour"}
+
+    if paramsProblem := bindQuery(ginCon, &params); paramsProblem != "" {
+      sendJsonHttpResponse(ginCon, http.StatusBadRequest, HttpError{CodeValidationFailed, paramsProblem})
+      return
+    }
+
+    //the day before to, unless given
+    if params.From.IsZero() {
+        params.From = params.To.Add(-24 * time.Hour)
+    }
+
+    status, usage := getUsage(params.From, params.To, analyticsIntervals[params.Interval])
+
+    switch status {
+    case OK:
+      sendJsonHttpResponse(ginCon, http.StatusOK, usage)
+    default:
+      sendStatus(ginCon, status, "the analytics")
+    }
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+    "testing"
+    "time"
+)
+
+//rollups that could not be written are merged into those counted meanwhile
+func TestRequeueRollups(t *testing.T) {
+    savedRollups := pendingRollups
+    defer func() { pendingRollups = savedRollups }()
+
+    bucket := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+    counted := rollupKey{bucket, "GET /documents/:id", "10.0.0.1"}
+    unwritten := rollupKey{bucket, "GET /documents", "10.0.0.1"}
+    pendingRollups = map[rollupKey]*rollupCounts{counted: {Count: 2, TotalLatencyMs: 10, MaxLatencyMs: 6}}
+
+    requeueRollups(map[rollupKey]*rollupCounts{
+        counted:   {Count: 3, Errors: 1, TotalLatencyMs: 30, MaxLatencyMs: 20},
+        unwritten: {Count: 1, TotalLatencyMs: 4, MaxLatencyMs: 4},
+    })
+
+    if merged := *pendingRollups[counted]; merged != (rollupCounts{Count: 5, Errors: 1, TotalLatencyMs: 40, MaxLatencyMs: 20}) {
+        t.Errorf("merged rollup is %+v", merged)
+    }
+
+    if requeued := pendingRollups[unwritten]; requeued == nil || requeued.Count != 1 {
+        t.Errorf("unwritten rollup is requeued as %+v", requeued)
+    }
+}
+
+func TestRequeueRollupsBounded(t *testing.T) {
+    savedRollups := pendingRollups
+    defer func() { pendingRollups = savedRollups }()
+
+    pendingRollups = make(map[rollupKey]*rollupCounts)
+    bucket := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+    for i := 0; i < maxPendingRollups; i++ {
+        pendingRollups[rollupKey{bucket, "GET /documents", "10.0.0." + toString(i)}] = &rollupCounts{Count: 1}
+    }
+
+    counted := rollupKey{bucket, "GET /documents", "10.0.0.0"}
+    requeueRollups(map[rollupKey]*rollupCounts{
+        counted:                                    {Count: 1},
+        {bucket, "GET /documents/:id", "10.0.0.1"}: {Count: 1},
+    })
+
+    if len(pendingRollups) != maxPendingRollups || pendingRollups[counted].Count != 2 {
+        t.Errorf("%d rollups pending, the counted one at %d, expected %d and 2", len(pendingRollups), pendingRollups[counted].Count, maxPendingRollups)
+    }
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+    "github.com/gin-gonic/gin"
+    "net/http"
+)
+
+/* imports create many documents in one request. Unlike a transaction, every document
+is validated and created on its own, so one bad document does not hold back the others:
+the response tells for each one whether it was created, and with which id, or why not.
+A document is created as by POST /documents, notified and recorded in the history */
+
+type BulkCreateResult struct {
+    Status int         `json:"status"`          //response code POST /documents would have given
+    ID     *int        `json:"id,omitempty"`    //of the created document
+    Error  interface{} `json:"error,omitempty"` //an HttpError, or a PolicyError listing what matched
+}
+
+type BulkCreateResponse struct {
+    Created int                `json:"created"`
+    Results []BulkCreateResult `json:"results"` //in the order of the request
+}
+
+//validate and create a document of a bulk request, checking for duplicates unless forced
+func bulkCreate(document Document, force bool, request *writeRequest) BulkCreateResult {
+    if code, problem := newDocumentProblem(&document); problem != nil {
+        return BulkCreateResult{Status: code, Error: *problem}
+    }
+
+    if violation := policyViolation(document); violation != nil {
+        return BulkCreateResult{Status: http.StatusUnprocessableEntity, Error: *violation}
+    }
+
+    if detectDuplicates && !force {
+        switch status, duplicate := findDuplicate(request.Context, document); status {
+        case OK:
+          return BulkCreateResult{Status: http.StatusConflict, Error: HttpError{CodeDuplicateDocument, "document " + toString(*duplicate.ID) + " has the same title and content; add force=true to create it anyway"}}
+        case NotFound:
+        default:
+          code, problem := statusProblem(status, "the document")
+          return BulkCreateResult{Status: code, Error: problem}
+        }
+    }
+
+    status, newDocument := createDocument(document, request)
+
+    if status != OK {
+        code, problem := statusProblem(status, "the document")
+        return BulkCreateResult{Status: code, Error: problem}
+    }
+
+    return BulkCreateResult{Status: http.StatusCreated, ID: newDocument.ID}
+}
+
+func handleBulkCreateDocuments(ginCon *gin.Context) {
+    var documents []Document
+
+    if bindErr := ginCon.BindJSON(&documents); bindErr != nil {
+      sendJsonHttpResponse(ginCon, http.StatusBadRequest, HttpError{CodeValidationFailed, "expected a json array of documents"})
+      return
+    }
+
+    if len(documents) == 0 || len(documents) > maxBatchOperations {
+      sendJsonHttpResponse(ginCon, http.StatusBadRequest, HttpError{CodeValidationFailed, "a batch must hold between 1 and " + toString(maxBatchOperations) + " documents"})
+      return
+    }
+
+    params := CreateParams{}
+
+    if paramsProblem := bindQuery(ginCon, &params); paramsProblem != "" {
+      sendJsonHttpResponse(ginCon, http.StatusBadRequest, HttpError{CodeValidationFailed, paramsProblem})
+      return
+    }
+
+    force := params.Force
+    response := BulkCreateResponse{Results: make([]BulkCreateResult, len(documents))}
+    var lastWrite *writeRequest
+
+    for i, document := range documents {
+        request := newWriteRequest(ginCon)
+        request.Batch = true
+        response.Results[i] = bulkCreate(document, force, request)
+
+        if response.Results[i].Status == http.StatusCreated {
+            response.Created++
+            lastWrite = request
+        }
+    }
+
+    //the token of the last write covers the ones before it
+    if lastWrite != nil {
+        sendConsistencyToken(ginCon, lastWrite)
+    }
+
+    sendJsonHttpResponse(ginCon, http.StatusOK, response)
+}
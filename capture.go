@@ -0,0 +1,245 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "github.com/gin-gonic/gin"
+    "io"
+    "io/ioutil"
+    "log"
+    "net/url"
+    "os"
+    "strings"
+    "sync"
+    "time"
+)
+
+/* with PRECISELY_CAPTURE_FILE set, every request and its response are appended to that
+file as one line of JSON, to be replayed against another build with cmd/replay. The
+capture is anonymized: personal data and content policy matches are masked as in the
+redacted view, in bodies as well as in paths and query values. Names of people and
+metadata values are masked as a whole. Client addresses are left out, and of the
+headers only those changing the meaning of a request are kept. Admin requests are
+marked, without the token. Only JSON bodies are captured, and only as far as the body
+limit of the route lets them be read, see captureRequestBody */
+var captureFile string = getEnv("PRECISELY_CAPTURE_FILE", "")
+
+//headers replayed along with a request
+var capturedHeaders = []string{"Content-Type", "Accept", "If-None-Match", consistencyTokenHeader}
+
+type CapturedExchange struct {
+    Method       string            `json:"method"`
+    Path         string            `json:"path"` //including the query, redacted
+    Headers      map[string]string `json:"headers,omitempty"`
+    Admin        bool              `json:"admin,omitempty"`
+    RequestBody  string            `json:"requestBody,omitempty"`
+    Status       int               `json:"status"`
+    ResponseBody string            `json:"responseBody,omitempty"`
+    At           time.Time         `json:"at"`
+}
+
+var captureMutex sync.Mutex
+
+//key of the request body read for the capture, see captureRequestBody
+const capturedBodyKey = "capturedRequestBody"
+
+//json fields naming people, masked as a whole: signees, also those reassigned from and to, see signees.go
+var capturedPersonFields = map[string]bool{"signee": true, "from": true, "to": true}
+
+//query parameters masked as a whole, besides metadata filters
+var capturedPersonParams = map[string]bool{"signee": true}
+
+//path parameters masked as a whole, like ids of records in other systems
+var capturedPathParams = map[string]bool{":externalId": true}
+
+//copies the response body while writing it
+type capturingWriter struct {
+    gin.ResponseWriter
+    body bytes.Buffer
+}
+
+func (writer *capturingWriter) Write(data []byte) (int, error) {
+    writer.body.Write(data)
+    return writer.ResponseWriter.Write(data)
+}
+
+func (writer *capturingWriter) WriteString(data string) (int, error) {
+    writer.body.WriteString(data)
+    return writer.ResponseWriter.WriteString(data)
+}
+
+func isJsonContentType(contentType string) bool {
+    return strings.HasPrefix(strings.TrimSpace(contentType), "application/json")
+}
+
+//middleware capturing requests and responses, if enabled
+func captureTraffic(ginCon *gin.Context) {
+    if captureFile == "" {
+        ginCon.Next()
+        return
+    }
+
+    exchange := CapturedExchange{
+        Method:  ginCon.Request.Method,
+        Path:    redactedRequestURI(ginCon),
+        Headers: make(map[string]string),
+        Admin:   isAdminRequest(ginCon),
+        At:      time.Now().UTC(),
+    }
+
+    for _, header := range capturedHeaders {
+        if value := ginCon.GetHeader(header); value != "" {
+            exchange.Headers[header] = value
+        }
+    }
+
+    writer := &capturingWriter{ResponseWriter: ginCon.Writer}
+    ginCon.Writer = writer
+    ginCon.Next()
+
+    exchange.Status = writer.Status()
+    exchange.RequestBody = redactJsonText(ginCon.GetString(capturedBodyKey))
+
+    if isJsonContentType(writer.Header().Get("Content-Type")) {
+        exchange.ResponseBody = redactJsonText(writer.body.String())
+    }
+
+    writeCapturedExchange(exchange)
+}
+
+/* route middleware reading a json request body for the capture, once limitBody and
+decompressRequest have bounded it and before its naming is translated. A body beyond
+the limit is not captured, and is refused further on as without a capture */
+func captureRequestBody(ginCon *gin.Context) {
+    if captureFile == "" || ginCon.Request.Body == nil || !isJsonContentType(ginCon.GetHeader("Content-Type")) {
+        ginCon.Next()
+        return
+    }
+
+    body, readErr := ioutil.ReadAll(ginCon.Request.Body)
+
+    if readErr != nil {
+        ginCon.Request.Body = ioutil.NopCloser(io.MultiReader(bytes.NewReader(body), failedReader{readErr}))
+        ginCon.Next()
+        return
+    }
+
+    ginCon.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+    ginCon.Set(capturedBodyKey, string(body))
+    ginCon.Next()
+}
+
+//a reader failing as the one it stands in for did
+type failedReader struct {
+    err error
+}
+
+func (reader failedReader) Read([]byte) (int, error) {
+    return 0, reader.err
+}
+
+//path and query of the request with parameters naming people or their records masked
+func redactedRequestURI(ginCon *gin.Context) string {
+    segments := strings.Split(ginCon.Request.URL.Path, "/")
+    routeSegments := strings.Split(ginCon.FullPath(), "/")
+
+    if len(segments) == len(routeSegments) {
+        for i, routeSegment := range routeSegments {
+            if capturedPathParams[routeSegment] {
+                segments[i] = redactedText
+            }
+        }
+    }
+
+    redactedURL := url.URL{Path: strings.Join(segments, "/")}
+    query := ginCon.Request.URL.Query()
+
+    for name, values := range query {
+        for i := range values {
+            if capturedPersonParams[name] || strings.HasPrefix(name, "metadata.") {
+                values[i] = redactedText
+            } else {
+                values[i] = *redactText(&values[i])
+            }
+        }
+    }
+
+    redactedURL.RawQuery = query.Encode()
+    return redactedURL.RequestURI()
+}
+
+//a json body with its personal data masked, or the text masked as it is if no json
+func redactJsonText(text string) string {
+    if text == "" {
+        return ""
+    }
+
+    value, decodeErr := decodeJsonValue([]byte(text))
+
+    if decodeErr != nil {
+        return *redactText(&text)
+    }
+
+    var redacted bytes.Buffer
+    encoder := json.NewEncoder(&redacted)
+    encoder.SetEscapeHTML(false)
+
+    if encodeErr := encoder.Encode(redactJsonValue(value, "")); encodeErr != nil {
+        return *redactText(&text)
+    }
+
+    return strings.TrimSuffix(redacted.String(), "\n")
+}
+
+//mask a decoded json value in place, given the key it is found under in any naming
+func redactJsonValue(value interface{}, key string) interface{} {
+    field := strings.ToLower(strings.ReplaceAll(key, "_", ""))
+
+    switch typed := value.(type) {
+    case string:
+      if capturedPersonFields[field] {
+          return redactedText
+      }
+
+      return *redactText(&typed)
+    case []interface{}:
+      for i := range typed {
+          typed[i] = redactJsonValue(typed[i], key)
+      }
+    case map[string]interface{}:
+      for childKey, child := range typed {
+          if field == "metadata" {
+              typed[childKey] = redactedText
+          } else {
+              typed[childKey] = redactJsonValue(child, childKey)
+          }
+      }
+    }
+
+    return value
+}
+
+func writeCapturedExchange(exchange CapturedExchange) {
+    serialExchange, serialErr := json.Marshal(exchange)
+
+    if serialErr != nil {
+        log.Print("Error capturing ", exchange.Method, " ", exchange.Path, ": ", serialErr)
+        return
+    }
+
+    captureMutex.Lock()
+    defer captureMutex.Unlock()
+
+    file, openErr := os.OpenFile(captureFile, os.O_APPEND | os.O_CREATE | os.O_WRONLY, 0600)
+
+    if openErr != nil {
+        log.Print("Error opening capture file: ", openErr)
+        return
+    }
+
+    defer file.Close()
+
+    if _, writeErr := file.Write(append(serialExchange, '\n')); writeErr != nil {
+        log.Print("Error capturing ", exchange.Method, " ", exchange.Path, ": ", writeErr)
+    }
+}
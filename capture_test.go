@@ -0,0 +1,83 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "github.com/gin-gonic/gin"
+    "io/ioutil"
+    "net/http"
+    "net/http/httptest"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+//serve a request through a router capturing into a file of its own, returning what was captured
+func capturedExchange(t *testing.T, bodyLimit int64, request *http.Request) CapturedExchange {
+    savedFile := captureFile
+    captureFile = filepath.Join(t.TempDir(), "capture.jsonl")
+    defer func() { captureFile = savedFile }()
+
+    router := gin.New()
+    router.Use(captureTraffic)
+    router.POST("/documents/by-ref/:system/:externalId", limitBody(bodyLimit), captureRequestBody, func(ginCon *gin.Context) {
+        body, readErr := ioutil.ReadAll(ginCon.Request.Body)
+
+        if readErr != nil {
+            sendJsonHttpResponse(ginCon, http.StatusRequestEntityTooLarge, HttpError{CodePayloadTooLarge, "too large"})
+            return
+        }
+
+        ginCon.Data(http.StatusOK, "application/json; charset=utf-8", body)
+    })
+    router.ServeHTTP(httptest.NewRecorder(), request)
+
+    serialExchange, readErr := ioutil.ReadFile(captureFile)
+
+    if readErr != nil {
+        t.Fatal(readErr)
+    }
+
+    var exchange CapturedExchange
+
+    if parseErr := json.Unmarshal(serialExchange, &exchange); parseErr != nil {
+        t.Fatal(parseErr)
+    }
+
+    return exchange
+}
+
+func TestCaptureIsAnonymized(t *testing.T) {
+    body := `{"title":"Lease for jane@example.com","signee":"Jane Doe","metadata":{"caseNumber":"2024-117"},"references":[{"system":"crm","externalId":"A-1001"}],"moves":{"from":"Jane Doe","to":"John Roe"}}`
+    request := httptest.NewRequest(http.MethodPost, "/documents/by-ref/crm/A-1001?signee=Jane+Doe&metadata.caseNumber=2024-117&title=jane%40example.com&limit=5", strings.NewReader(body))
+    request.Header.Set("Content-Type", gin.MIMEJSON)
+    exchange := capturedExchange(t, 1024, request)
+
+    for _, captured := range []string{exchange.Path, exchange.RequestBody, exchange.ResponseBody} {
+        for _, personal := range []string{"Jane", "John", "jane@example.com", "jane%40example.com", "2024-117"} {
+            if strings.Contains(captured, personal) {
+                t.Errorf("capture holds %s: %s", personal, captured)
+            }
+        }
+    }
+
+    if !strings.HasPrefix(exchange.Path, "/documents/by-ref/crm/%5BREDACTED%5D?") || strings.Contains(exchange.Path, "A-1001") {
+        t.Errorf("captured path %s does not mask the external id", exchange.Path)
+    }
+
+    if !strings.Contains(exchange.Path, "limit=5") || !strings.Contains(exchange.RequestBody, `"system":"crm"`) {
+        t.Errorf("capture masks more than personal data: %s %s", exchange.Path, exchange.RequestBody)
+    }
+}
+
+func TestCaptureKeepsBodyLimit(t *testing.T) {
+    body := `{"title":"` + string(bytes.Repeat([]byte("a"), 4096)) + `"}`
+    request := httptest.NewRequest(http.MethodPost, "/documents/by-ref/crm/A-1001", strings.NewReader(body))
+    request.Header.Set("Content-Type", gin.MIMEJSON)
+    request.ContentLength = -1 //sent chunked, so only reading tells the size
+    exchange := capturedExchange(t, 1024, request)
+
+    if exchange.Status != http.StatusRequestEntityTooLarge || exchange.RequestBody != "" {
+        t.Errorf("body beyond the limit is answered %d and captured as %d bytes", exchange.Status, len(exchange.RequestBody))
+    }
+}
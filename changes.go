@@ -0,0 +1,112 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "strings"
+)
+
+/* update events list the fields the update changed, with their old and new values, so
+receivers can react to e.g. a new signee without fetching and diffing the document.
+Values of the fields in PRECISELY_NOTIFY_OMIT_VALUES, by default the bulky content, are
+left out; the field is still listed as changed */
+var notifyOmitValues string = getEnv("PRECISELY_NOTIFY_OMIT_VALUES", "content")
+
+//a field changed by an update, values as json, null when unset. Both are left out for omitted values
+type FieldChange struct {
+    Field string          `json:"field"`
+    Old   json.RawMessage `json:"old,omitempty"`
+    New   json.RawMessage `json:"new,omitempty"`
+}
+
+//fields set by clients, which are compared by their json names. Derived fields follow from these, the revision always changes
+func comparedFields(document *Document) []interface{} {
+    if document == nil {
+        document = &Document{}
+    }
+
+    return []interface{}{document.Title, document.Content, document.Signee, document.Metadata, document.References, document.Links, document.Classification}
+}
+
+var comparedFieldNames = []string{"title", "content", "signee", "metadata", "references", "links", "classification"}
+
+func omitsValues(field string) bool {
+    for _, omitted := range strings.Split(notifyOmitValues, ",") {
+        if strings.TrimSpace(omitted) == field {
+            return true
+        }
+    }
+
+    return false
+}
+
+//the fields differing between two states of a document, nil standing for a document without fields
+func fieldChanges(previous *Document, current *Document) ([]FieldChange, error) {
+    var changes []FieldChange
+    previousValues, currentValues := comparedFields(previous), comparedFields(current)
+
+    for i, field := range comparedFieldNames {
+        oldValue, oldErr := json.Marshal(previousValues[i])
+
+        if oldErr != nil {
+            return nil, oldErr
+        }
+
+        newValue, newErr := json.Marshal(currentValues[i])
+
+        if newErr != nil {
+            return nil, newErr
+        }
+
+        if bytes.Equal(oldValue, newValue) {
+            continue
+        }
+
+        if omitsValues(field) {
+            changes = append(changes, FieldChange{Field: field})
+        } else {
+            changes = append(changes, FieldChange{field, oldValue, newValue})
+        }
+    }
+
+    return changes, nil
+}
+
+/* the changes an update of a transaction made to the document with id, if update events
+are delivered anywhere. written holds the states written earlier in the transaction.
+Otherwise the previous state is the one committed, read outside of the transaction: the
+update holds the document until the transaction ends, so it can not have changed since */
+func updateChanges(id int, current *Document, written map[int]*Document) ([]FieldChange, error) {
+    if len(notificationChannels) == 0 || !isNotifiedOperation(UpdateOperation) {
+        return nil, nil
+    }
+
+    previous, writtenBefore := written[id]
+
+    if !writtenBefore {
+        status, committed := getDocument(id, "primary")
+
+        if status != OK && status != NotFound {
+            return nil, errWriteNotApplied
+        }
+
+        previous = committed
+    }
+
+    return fieldChanges(previous, current)
+}
+
+//the changes for people, e.g. `signee "Jane Doe" → "John Roe", content`
+func describeChanges(changes []FieldChange) string {
+    descriptions := make([]string, len(changes))
+
+    for i, change := range changes {
+        descriptions[i] = change.Field
+
+        if change.Old != nil {
+            descriptions[i] += " " + string(change.Old) + " → " + string(change.New)
+        }
+    }
+
+    return strings.Join(descriptions, ", ")
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+    "go.mongodb.org/mongo-driver/bson"
+    "regexp"
+)
+
+/* documents are labelled public, internal or confidential. Clients may set the label,
+which defaults to internal. On every write the title and text content are scanned for
+personal data such as email addresses, and a document found holding any is labelled
+confidential whatever the client asked for. The kinds found are listed in pii */
+const (
+    ClassificationPublic       = "public"
+    ClassificationInternal     = "internal"
+    ClassificationConfidential = "confidential"
+)
+
+var classifications = map[string]bool{
+    ClassificationPublic:       true,
+    ClassificationInternal:     true,
+    ClassificationConfidential: true,
+}
+
+/* detectors of personal data by the kind they find. A detector reports where a text
+holds data of its kind, as start and end offsets; more elaborate ones, e.g. asking a
+classification service, can be added next to the patterns */
+type piiDetector func(text string) [][]int
+
+var piiDetectors = map[string]piiDetector{
+    "email":      patternDetector(`(?i)\b[a-z0-9._%+-]+@[a-z0-9.-]+\.[a-z]{2,}\b`),
+    "phone":      patternDetector(`(?:\+\d{1,3}|\b0)[ -]?\(?\d{1,4}\)?(?:[ -]?\d{2,4}){2,4}\b|\(?\b\d{3}\)?[ .-]\d{3}[.-]\d{4}\b`), //international, with a trunk prefix or us style
+    "nationalId": patternDetector(`\b(?:\d{3}-\d{2}-\d{4}|(?:19|20)?\d{6}[-+]\d{4})\b`), //us social security and swedish personal identity numbers
+}
+
+func patternDetector(pattern string) piiDetector {
+    expression := regexp.MustCompile(pattern)
+
+    return func(text string) [][]int {
+        return expression.FindAllStringIndex(text, -1)
+    }
+}
+
+//kinds in a fixed order, so the kinds found in the same document always compare equal
+var piiKinds = []string{"email", "phone", "nationalId"}
+
+func classificationProblem(classification *string) string {
+    if classification == nil {
+        return ""
+    }
+
+    if !classifications[*classification] {
+        return "classification must be " + ClassificationPublic + ", " + ClassificationInternal + " or " + ClassificationConfidential
+    }
+
+    return ""
+}
+
+//kinds of personal data found in the title and text content of a document
+func detectPii(document Document) []string {
+    texts := []*string{document.Title}
+
+    if content := document.Content; content != nil {
+        texts = append(texts, content.Header)
+
+        if content.Encoding == nil || *content.Encoding == TextEncoding {
+            texts = append(texts, content.Data)
+        }
+    }
+
+    var kinds []string
+
+    for _, kind := range piiKinds {
+        for _, text := range texts {
+            if text != nil && len(piiDetectors[kind](*text)) > 0 {
+                kinds = append(kinds, kind)
+                break
+            }
+        }
+    }
+
+    return kinds
+}
+
+//the label a document is stored with, raised to confidential if it holds personal data
+func effectiveClassification(classification *string, pii []string) *string {
+    label := ClassificationInternal
+
+    if classification != nil {
+        label = *classification
+    }
+
+    if len(pii) > 0 {
+        label = ClassificationConfidential
+    }
+
+    return &label
+}
+
+func samePii(pii []string, otherPii []string) bool {
+    if len(pii) != len(otherPii) {
+        return false
+    }
+
+    for i := range pii {
+        if pii[i] != otherPii[i] {
+            return false
+        }
+    }
+
+    return true
+}
+
+//filter matching documents of the given label and holding the given kind of personal data, either may be empty
+func classificationFilter(classification string, pii string) bson.D {
+    filter := bson.D{}
+
+    if classification != "" {
+        filter = append(filter, bson.E{Key: "classification", Value: classification})
+    }
+
+    if pii != "" {
+        filter = append(filter, bson.E{Key: "pii", Value: pii})
+    }
+
+    return filter
+}
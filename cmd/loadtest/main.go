@@ -0,0 +1,374 @@
+package main
+
+/* loadtest generates a realistic mix of traffic against a running instance of
+the document API and reports throughput and latency per operation, so
+performance regressions can be measured before release.
+
+    go run ./cmd/loadtest -url http://localhost:8080 -duration 30s -workers 16
+*/
+
+import (
+    "bytes"
+    "encoding/json"
+    "errors"
+    "flag"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "log"
+    "math/rand"
+    "net/http"
+    "os"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+type operation struct {
+    name   string
+    weight int
+    run    func(client *http.Client, baseURL string, ids *idPool) (int, error)
+}
+
+//result of a single request
+type sample struct {
+    operation string
+    latency   time.Duration
+    status    int
+    err       error
+}
+
+//ids of documents created during the run, used as targets for reads, updates and deletes
+type idPool struct {
+    mutex sync.Mutex
+    ids   []int
+}
+
+func (pool *idPool) add(id int) {
+    pool.mutex.Lock()
+    defer pool.mutex.Unlock()
+    pool.ids = append(pool.ids, id)
+}
+
+//pick a random known id, -1 if none exist yet
+func (pool *idPool) pick() int {
+    pool.mutex.Lock()
+    defer pool.mutex.Unlock()
+
+    if len(pool.ids) == 0 {
+        return -1
+    }
+
+    return pool.ids[rand.Intn(len(pool.ids))]
+}
+
+//remove and return a random known id, -1 if none exist yet
+func (pool *idPool) take() int {
+    pool.mutex.Lock()
+    defer pool.mutex.Unlock()
+
+    if len(pool.ids) == 0 {
+        return -1
+    }
+
+    index := rand.Intn(len(pool.ids))
+    id := pool.ids[index]
+    pool.ids[index] = pool.ids[len(pool.ids)-1]
+    pool.ids = pool.ids[:len(pool.ids)-1]
+
+    return id
+}
+
+var signees = []string{"Mr. Burns", "Mr. Smithers", "Ms. Hoover", "Mr. Flanders"}
+
+//generate a complete document body with content of a realistic size
+func randomDocument() map[string]interface{} {
+    paragraphs := make([]string, 1+rand.Intn(20))
+
+    for i := range paragraphs {
+        paragraphs[i] = "Section " + strconv.Itoa(i+1) + ". The parties agree to the terms set out herein."
+    }
+
+    return map[string]interface{}{
+        "title": "Load test contract " + strconv.Itoa(rand.Intn(1000000)),
+        "content": map[string]interface{}{
+            "header": "Load test header",
+            "data":   strings.Join(paragraphs, "\n"),
+        },
+        "signee": signees[rand.Intn(len(signees))],
+    }
+}
+
+func doRequest(client *http.Client, method string, url string, body interface{}) (int, []byte, error) {
+    var reader io.Reader
+
+    if body != nil {
+        serialBody, serialErr := json.Marshal(body)
+
+        if serialErr != nil {
+            return 0, nil, serialErr
+        }
+
+        reader = bytes.NewReader(serialBody)
+    }
+
+    request, requestErr := http.NewRequest(method, url, reader)
+
+    if requestErr != nil {
+        return 0, nil, requestErr
+    }
+
+    if body != nil {
+        request.Header.Set("Content-Type", "application/json")
+    }
+
+    response, responseErr := client.Do(request)
+
+    if responseErr != nil {
+        return 0, nil, responseErr
+    }
+
+    defer response.Body.Close()
+    responseBody, readErr := ioutil.ReadAll(response.Body)
+
+    return response.StatusCode, responseBody, readErr
+}
+
+//no document was created yet for reads, updates and deletes to target
+var errNoDocuments = errors.New("no documents created yet")
+
+func createOperation(client *http.Client, baseURL string, ids *idPool) (int, error) {
+    status, body, err := doRequest(client, http.MethodPost, baseURL+"/documents", randomDocument())
+
+    if err != nil || status != http.StatusCreated {
+        return status, err
+    }
+
+    var created struct {
+        ID *int `json:"id"`
+    }
+
+    if decodeErr := json.Unmarshal(body, &created); decodeErr == nil && created.ID != nil {
+        ids.add(*created.ID)
+    }
+
+    return status, nil
+}
+
+func getOperation(client *http.Client, baseURL string, ids *idPool) (int, error) {
+    id := ids.pick()
+
+    if id < 0 {
+        return 0, errNoDocuments
+    }
+
+    status, _, err := doRequest(client, http.MethodGet, baseURL+"/documents/"+strconv.Itoa(id), nil)
+    return status, err
+}
+
+func listOperation(client *http.Client, baseURL string, ids *idPool) (int, error) {
+    status, _, err := doRequest(client, http.MethodGet, baseURL+"/documents", nil)
+    return status, err
+}
+
+func updateOperation(client *http.Client, baseURL string, ids *idPool) (int, error) {
+    id := ids.pick()
+
+    if id < 0 {
+        return 0, errNoDocuments
+    }
+
+    patch := map[string]interface{}{"signee": signees[rand.Intn(len(signees))]}
+    status, _, err := doRequest(client, http.MethodPatch, baseURL+"/documents/"+strconv.Itoa(id), patch)
+
+    return status, err
+}
+
+func deleteOperation(client *http.Client, baseURL string, ids *idPool) (int, error) {
+    id := ids.take()
+
+    if id < 0 {
+        return 0, errNoDocuments
+    }
+
+    status, _, err := doRequest(client, http.MethodDelete, baseURL+"/documents/"+strconv.Itoa(id), nil)
+    return status, err
+}
+
+//parse a mix such as "get=60,list=20,create=10,update=8,delete=2" into weighted operations
+func parseMix(mix string) ([]operation, error) {
+    runners := map[string]func(*http.Client, string, *idPool) (int, error){
+        "get":    getOperation,
+        "list":   listOperation,
+        "create": createOperation,
+        "update": updateOperation,
+        "delete": deleteOperation,
+    }
+
+    var operations []operation
+
+    for _, part := range strings.Split(mix, ",") {
+        keyValue := strings.SplitN(strings.TrimSpace(part), "=", 2)
+
+        if len(keyValue) != 2 {
+            return nil, fmt.Errorf("malformed mix entry '%s'", part)
+        }
+
+        run, known := runners[keyValue[0]]
+
+        if !known {
+            return nil, fmt.Errorf("unknown operation '%s'", keyValue[0])
+        }
+
+        weight, weightErr := strconv.Atoi(keyValue[1])
+
+        if weightErr != nil || weight < 0 {
+            return nil, fmt.Errorf("weight of '%s' is not a positive number", keyValue[0])
+        }
+
+        operations = append(operations, operation{keyValue[0], weight, run})
+    }
+
+    return operations, nil
+}
+
+func pickOperation(operations []operation, totalWeight int) operation {
+    target := rand.Intn(totalWeight)
+
+    for _, op := range operations {
+        if target < op.weight {
+            return op
+        }
+
+        target -= op.weight
+    }
+
+    return operations[len(operations)-1]
+}
+
+func percentile(sorted []time.Duration, p float64) time.Duration {
+    if len(sorted) == 0 {
+        return 0
+    }
+
+    return sorted[int(float64(len(sorted)-1)*p)]
+}
+
+func report(samples []sample, elapsed time.Duration) {
+    byOperation := make(map[string][]sample)
+
+    for _, s := range samples {
+        byOperation[s.operation] = append(byOperation[s.operation], s)
+    }
+
+    names := make([]string, 0, len(byOperation))
+
+    for name := range byOperation {
+        names = append(names, name)
+    }
+
+    sort.Strings(names)
+
+    fmt.Printf("%d requests in %s (%.1f req/s)\n\n", len(samples), elapsed.Round(time.Millisecond), float64(len(samples))/elapsed.Seconds())
+    fmt.Printf("%-8s %8s %8s %10s %10s %10s %10s\n", "op", "count", "errors", "p50", "p90", "p99", "max")
+
+    for _, name := range names {
+        opSamples := byOperation[name]
+        latencies := make([]time.Duration, len(opSamples))
+        errors := 0
+
+        for i, s := range opSamples {
+            latencies[i] = s.latency
+
+            if s.err != nil || s.status >= 500 {
+                errors++
+            }
+        }
+
+        sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+        fmt.Printf("%-8s %8d %8d %10s %10s %10s %10s\n", name, len(opSamples), errors,
+            percentile(latencies, 0.5).Round(time.Microsecond),
+            percentile(latencies, 0.9).Round(time.Microsecond),
+            percentile(latencies, 0.99).Round(time.Microsecond),
+            latencies[len(latencies)-1].Round(time.Microsecond))
+    }
+}
+
+func main() {
+    baseURL := flag.String("url", "http://localhost:8080", "base url of the document API")
+    duration := flag.Duration("duration", 30*time.Second, "how long to generate traffic")
+    workers := flag.Int("workers", 8, "number of concurrent clients")
+    mix := flag.String("mix", "get=60,list=20,create=10,update=8,delete=2", "weighted traffic mix")
+    seed := flag.Int64("seed", time.Now().UnixNano(), "random seed, for reproducible runs")
+    flag.Parse()
+
+    rand.Seed(*seed)
+
+    operations, mixErr := parseMix(*mix)
+
+    if mixErr != nil {
+        log.Fatal(mixErr)
+    }
+
+    totalWeight := 0
+
+    for _, op := range operations {
+        totalWeight += op.weight
+    }
+
+    if totalWeight == 0 || *workers < 1 {
+        fmt.Fprintln(os.Stderr, "traffic mix needs a positive total weight and at least one worker")
+        os.Exit(2)
+    }
+
+    client := &http.Client{
+        Timeout:   10 * time.Second,
+        Transport: &http.Transport{MaxIdleConnsPerHost: *workers},
+    }
+
+    ids := &idPool{}
+    results := make(chan sample, *workers*64)
+    deadline := time.Now().Add(*duration)
+    var waitGroup sync.WaitGroup
+
+    start := time.Now()
+
+    for w := 0; w < *workers; w++ {
+        waitGroup.Add(1)
+
+        go func() {
+            defer waitGroup.Done()
+
+            for time.Now().Before(deadline) {
+                op := pickOperation(operations, totalWeight)
+                name := op.name
+                requestStart := time.Now()
+                status, err := op.run(client, *baseURL, ids)
+
+                //a document is created instead, and counted as the create it is
+                if err == errNoDocuments {
+                    name, requestStart = "create", time.Now()
+                    status, err = createOperation(client, *baseURL, ids)
+                }
+
+                results <- sample{name, time.Since(requestStart), status, err}
+            }
+        }()
+    }
+
+    go func() {
+        waitGroup.Wait()
+        close(results)
+    }()
+
+    var samples []sample
+
+    for s := range results {
+        samples = append(samples, s)
+    }
+
+    report(samples, time.Since(start))
+}
@@ -0,0 +1,199 @@
+package main
+
+/* replay re-sends the traffic captured by a running instance with
+PRECISELY_CAPTURE_FILE against another build and reports every response that
+differs from the captured one, so regressions show before release. Start the
+build under test from the same database state the capture started from, since
+the captured requests are replayed in order and depend on the documents before.
+The capture masks personal data, so a state holding none, like an empty
+database, replays without false differences.
+
+    go run ./cmd/replay -file capture.jsonl -url http://localhost:8080 -admin-token secret
+*/
+
+import (
+    "bufio"
+    "bytes"
+    "encoding/json"
+    "flag"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "log"
+    "net/http"
+    "os"
+    "reflect"
+    "strings"
+    "time"
+)
+
+//as written by the capture of the service
+type exchange struct {
+    Method       string            `json:"method"`
+    Path         string            `json:"path"`
+    Headers      map[string]string `json:"headers"`
+    Admin        bool              `json:"admin"`
+    RequestBody  string            `json:"requestBody"`
+    Status       int               `json:"status"`
+    ResponseBody string            `json:"responseBody"`
+}
+
+//response of a replayed exchange that differs from the captured one
+type difference struct {
+    line     int
+    exchange exchange
+    status   int
+    body     string
+}
+
+func replay(client *http.Client, baseURL string, adminToken string, captured exchange) (int, []byte, error) {
+    var reader io.Reader
+
+    if captured.RequestBody != "" {
+        reader = strings.NewReader(captured.RequestBody)
+    }
+
+    request, requestErr := http.NewRequest(captured.Method, baseURL+captured.Path, reader)
+
+    if requestErr != nil {
+        return 0, nil, requestErr
+    }
+
+    for header, value := range captured.Headers {
+        request.Header.Set(header, value)
+    }
+
+    if captured.Admin {
+        request.Header.Set("Authorization", "Bearer "+adminToken)
+    }
+
+    response, responseErr := client.Do(request)
+
+    if responseErr != nil {
+        return 0, nil, responseErr
+    }
+
+    defer response.Body.Close()
+    responseBody, readErr := ioutil.ReadAll(response.Body)
+
+    return response.StatusCode, responseBody, readErr
+}
+
+//drop the ignored fields at any depth, so values changing from run to run are not compared
+func withoutIgnored(value interface{}, ignored map[string]bool) interface{} {
+    switch typed := value.(type) {
+    case map[string]interface{}:
+        for key, field := range typed {
+            if ignored[key] {
+                delete(typed, key)
+            } else {
+                typed[key] = withoutIgnored(field, ignored)
+            }
+        }
+    case []interface{}:
+        for i, element := range typed {
+            typed[i] = withoutIgnored(element, ignored)
+        }
+    }
+
+    return value
+}
+
+//whether two json bodies are equal apart from the ignored fields. Bodies that are no json are compared as text
+func sameBody(captured string, replayed string, ignored map[string]bool) bool {
+    if captured == "" || replayed == "" {
+        return strings.TrimSpace(captured) == strings.TrimSpace(replayed)
+    }
+
+    var capturedValue, replayedValue interface{}
+
+    if json.Unmarshal([]byte(captured), &capturedValue) != nil || json.Unmarshal([]byte(replayed), &replayedValue) != nil {
+        return strings.TrimSpace(captured) == strings.TrimSpace(replayed)
+    }
+
+    return reflect.DeepEqual(withoutIgnored(capturedValue, ignored), withoutIgnored(replayedValue, ignored))
+}
+
+func report(differences []difference, total int) {
+    for _, d := range differences {
+        fmt.Printf("line %d: %s %s\n", d.line, d.exchange.Method, d.exchange.Path)
+
+        if d.status != d.exchange.Status {
+            fmt.Printf("  status:   captured %d, replayed %d\n", d.exchange.Status, d.status)
+        }
+
+        fmt.Printf("  captured: %s\n", strings.TrimSpace(d.exchange.ResponseBody))
+        fmt.Printf("  replayed: %s\n", strings.TrimSpace(d.body))
+    }
+
+    fmt.Printf("replayed %d requests, %d differ\n", total, len(differences))
+}
+
+func main() {
+    file := flag.String("file", "capture.jsonl", "capture written by the service")
+    baseURL := flag.String("url", "http://localhost:8080", "base url of the build under test")
+    adminToken := flag.String("admin-token", "", "admin token of the build under test, for captured admin requests")
+    ignore := flag.String("ignore", "at,createdAt,deletedAt,checkedAt,changedAt,lastSeen,nextAttemptAt,lastReconnectAt", "comma separated json fields not compared")
+    flag.Parse()
+
+    ignored := make(map[string]bool)
+
+    for _, field := range strings.Split(*ignore, ",") {
+        if field = strings.TrimSpace(field); field != "" {
+            ignored[field] = true
+        }
+    }
+
+    captureFile, openErr := os.Open(*file)
+
+    if openErr != nil {
+        log.Fatal(openErr)
+    }
+
+    defer captureFile.Close()
+
+    client := &http.Client{Timeout: 30 * time.Second}
+    scanner := bufio.NewScanner(captureFile)
+    scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+
+    var differences []difference
+    total := 0
+
+    for line := 1; scanner.Scan(); line++ {
+        if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+            continue
+        }
+
+        var captured exchange
+
+        if parseErr := json.Unmarshal(scanner.Bytes(), &captured); parseErr != nil {
+            log.Fatalf("line %d of %s is no captured exchange: %v", line, *file, parseErr)
+        }
+
+        if captured.Admin && *adminToken == "" {
+            log.Fatalf("line %d of %s is an admin request, replaying it needs -admin-token", line, *file)
+        }
+
+        status, body, err := replay(client, *baseURL, *adminToken, captured)
+        total++
+
+        if err != nil {
+            differences = append(differences, difference{line, captured, 0, err.Error()})
+            continue
+        }
+
+        if status != captured.Status || !sameBody(captured.ResponseBody, string(body), ignored) {
+            differences = append(differences, difference{line, captured, status, string(body)})
+        }
+    }
+
+    if scanErr := scanner.Err(); scanErr != nil {
+        log.Fatal(scanErr)
+    }
+
+    report(differences, total)
+
+    if len(differences) > 0 {
+        os.Exit(1)
+    }
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+    "github.com/gin-gonic/gin"
+    "io/ioutil"
+    "net/http"
+    "strings"
+)
+
+//a line that differs between the stored content and a compared text
+type DiffLine struct {
+    Op   string `json:"op"`   //"delete" if only in the stored content, "insert" if only in the compared text
+    Line int    `json:"line"` //1-based line number, in the stored content for deletes and the compared text for inserts
+    Text string `json:"text"`
+}
+
+type Comparison struct {
+    Identical bool       `json:"identical"`
+    Diff      []DiffLine `json:"diff"`
+}
+
+/* texts needing more line edits than this are reported as entirely replaced,
+keeping time bounded for unrelated texts */
+const maxDiffEdits = 2000
+
+/* line diff from a to b, using the linear space variant of Myers' algorithm: the
+middle snake of the shortest edit path splits the texts, and both halves are diffed
+alike. Memory stays proportional to the number of lines, however many edits there are.
+Only deleted and inserted lines are returned, in order of appearance */
+func diffLines(a []string, b []string) []DiffLine {
+    var diff []DiffLine
+
+    if !diffInto(&diff, a, b, 0, 0, maxDiffEdits) {
+        return replaceAll(a, b)
+    }
+
+    return diff
+}
+
+/* append the diff from a to b, which start at the given lines of the whole texts, or
+return false if it needs more than maxEdits edits */
+func diffInto(diff *[]DiffLine, a []string, b []string, aStart int, bStart int, maxEdits int) bool {
+    //equal lines at both ends need no search
+    for len(a) > 0 && len(b) > 0 && a[0] == b[0] {
+        a, b = a[1:], b[1:]
+        aStart++
+        bStart++
+    }
+
+    for len(a) > 0 && len(b) > 0 && a[len(a)-1] == b[len(b)-1] {
+        a, b = a[:len(a)-1], b[:len(b)-1]
+    }
+
+    if len(a) == 0 || len(b) == 0 {
+        if len(a) + len(b) > maxEdits {
+            return false
+        }
+
+        for i, line := range a {
+            *diff = append(*diff, DiffLine{"delete", aStart + i + 1, line})
+        }
+
+        for i, line := range b {
+            *diff = append(*diff, DiffLine{"insert", bStart + i + 1, line})
+        }
+
+        return true
+    }
+
+    x, y, u, v, found := middleSnake(a, b, maxEdits)
+
+    if !found {
+        return false
+    }
+
+    //both halves need fewer edits than the whole, which was within bounds
+    diffInto(diff, a[:x], b[:y], aStart, bStart, len(a) + len(b))
+    diffInto(diff, a[u:], b[v:], aStart + u, bStart + v, len(a) + len(b))
+    return true
+}
+
+/* the middle snake of the shortest edit path from a to b, running from (x, y) to
+(u, v), searched from both ends at once until the paths overlap. Searches for paths of
+more than maxEdits edits are given up */
+func middleSnake(a []string, b []string, maxEdits int) (x int, y int, u int, v int, found bool) {
+    n, m := len(a), len(b)
+    delta := n - m
+    maxD := (n + m + 1) / 2
+
+    if limit := (maxEdits + 1) / 2; limit < maxD {
+        maxD = limit
+    }
+
+    /* forward[offset+k] is the furthest x reached from the start on diagonal k = x - y,
+    backward[offset+k] the same from the end, on the texts reversed */
+    offset := maxD + 1
+    forward := make([]int, 2*offset+1)
+    backward := make([]int, 2*offset+1)
+
+    for d := 0; d <= maxD; d++ {
+        for k := -d; k <= d; k += 2 {
+            if k == -d || (k != d && forward[offset+k-1] < forward[offset+k+1]) {
+                x = forward[offset+k+1] //step down, inserting from b
+            } else {
+                x = forward[offset+k-1] + 1 //step right, deleting from a
+            }
+
+            y = x - k
+            u, v = x, y
+
+            for u < n && v < m && a[u] == b[v] {
+                u++
+                v++
+            }
+
+            forward[offset+k] = u
+            reversedK := delta - k
+
+            //on an odd delta the paths can first overlap in a forward round
+            if delta%2 != 0 && reversedK >= -(d-1) && reversedK <= d-1 && u + backward[offset+reversedK] >= n {
+                return x, y, u, v, true
+            }
+        }
+
+        for k := -d; k <= d; k += 2 {
+            var reversedX int
+
+            if k == -d || (k != d && backward[offset+k-1] < backward[offset+k+1]) {
+                reversedX = backward[offset+k+1]
+            } else {
+                reversedX = backward[offset+k-1] + 1
+            }
+
+            reversedY := reversedX - k
+            startX, startY := reversedX, reversedY
+
+            for reversedX < n && reversedY < m && a[n-1-reversedX] == b[m-1-reversedY] {
+                reversedX++
+                reversedY++
+            }
+
+            backward[offset+k] = reversedX
+            forwardK := delta - k
+
+            //on an even delta in a backward round
+            if delta%2 == 0 && forwardK >= -d && forwardK <= d && forward[offset+forwardK] + reversedX >= n {
+                return n - reversedX, m - reversedY, n - startX, m - startY, true
+            }
+        }
+    }
+
+    return 0, 0, 0, 0, false
+}
+
+func replaceAll(a []string, b []string) []DiffLine {
+    diff := make([]DiffLine, 0, len(a)+len(b))
+
+    for i, line := range a {
+        diff = append(diff, DiffLine{"delete", i + 1, line})
+    }
+
+    for i, line := range b {
+        diff = append(diff, DiffLine{"insert", i + 1, line})
+    }
+
+    return diff
+}
+
+func splitLines(text string) []string {
+    return strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+}
+
+//compare a text in the request body with the stored content data of a document
+func handleCompareDocument(ginCon *gin.Context) {
+    id, toIntErr := toInt(getIDParam(ginCon))
+
+    if toIntErr != nil {
+      sendJsonHttpResponse(ginCon, http.StatusBadRequest, HttpError{CodeValidationFailed, "requested id '" + getIDParam(ginCon) + "' is not a number"})
+      return
+    }
+
+    //a character is at most 4 bytes in utf-8
+    text, readErr := ioutil.ReadAll(http.MaxBytesReader(ginCon.Writer, ginCon.Request.Body, int64(maxDataLength)*4))
+
+    if readErr != nil {
+      sendJsonHttpResponse(ginCon, http.StatusRequestEntityTooLarge, HttpError{CodePayloadTooLarge, "compared text exceeds the maximum length of content.data"})
+      return
+    }
+
+    status, document := getDocumentIn(ginCon.Request.Context(), id, "primary")
+
+    switch status {
+    case OK:
+    default:
+      sendDocumentStatus(ginCon, status, id)
+      return
+    }
+
+    if document.Content == nil || document.Content.Data == nil {
+      sendJsonHttpResponse(ginCon, http.StatusInternalServerError, HttpError{CodeInternal, "unexpected server state"})
+      return
+    }
+
+    if document.Content.Encoding != nil && *document.Content.Encoding != TextEncoding {
+      sendJsonHttpResponse(ginCon, http.StatusNotAcceptable, HttpError{CodeUnsupportedEncoding, "document " + getIDParam(ginCon) + " holds " + *document.Content.Encoding + " content, which can not be compared as text"})
+      return
+    }
+
+    diff := diffLines(splitLines(*document.Content.Data), splitLines(string(text)))
+    sendJsonHttpResponse(ginCon, http.StatusOK, Comparison{len(diff) == 0, diff})
+}
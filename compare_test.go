@@ -0,0 +1,133 @@
+package main
+
+import (
+    "math/rand"
+    "reflect"
+    "strings"
+    "testing"
+)
+
+//the lines of a after applying the diff, which should be those of b
+func applyDiff(t *testing.T, a []string, diff []DiffLine) []string {
+    var applied []string
+    next := 0 //next line of a to copy
+
+    for _, edit := range diff {
+        switch edit.Op {
+        case "delete":
+          applied = append(applied, a[next:edit.Line-1]...)
+          next = edit.Line
+
+          if a[edit.Line-1] != edit.Text {
+              t.Fatalf("deleted line %d is %q, not %q", edit.Line, a[edit.Line-1], edit.Text)
+          }
+        case "insert":
+          //lines of a before the insert are copied up to its line in b
+          for len(applied) < edit.Line-1 {
+              applied = append(applied, a[next])
+              next++
+          }
+
+          applied = append(applied, edit.Text)
+        }
+    }
+
+    return append(applied, a[next:]...)
+}
+
+//the fewest deletes and inserts turning a into b, by the longest common subsequence
+func fewestEdits(a []string, b []string) int {
+    common := make([][]int, len(a)+1)
+
+    for i := range common {
+        common[i] = make([]int, len(b)+1)
+    }
+
+    for i := len(a) - 1; i >= 0; i-- {
+        for j := len(b) - 1; j >= 0; j-- {
+            if a[i] == b[j] {
+                common[i][j] = common[i+1][j+1] + 1
+            } else if common[i+1][j] > common[i][j+1] {
+                common[i][j] = common[i+1][j]
+            } else {
+                common[i][j] = common[i][j+1]
+            }
+        }
+    }
+
+    return len(a) + len(b) - 2*common[0][0]
+}
+
+func TestDiffLines(t *testing.T) {
+    a := splitLines("Lease agreement\nThe tenant shall pay the rent monthly.\nThe fee is 100 dollars.\nSigned")
+    b := splitLines("Lease agreement\nThe tenant shall pay the rent monthly.\nThe fee is 10 dollars.\nSigned")
+    expected := []DiffLine{{"delete", 3, "The fee is 100 dollars."}, {"insert", 3, "The fee is 10 dollars."}}
+
+    if diff := diffLines(a, b); !reflect.DeepEqual(diff, expected) {
+        t.Errorf("diff is %+v, expected %+v", diff, expected)
+    }
+
+    if diff := diffLines(a, a); len(diff) != 0 {
+        t.Errorf("identical texts differ in %+v", diff)
+    }
+}
+
+//random texts of few distinct lines, so they share many
+func TestDiffLinesIsShortest(t *testing.T) {
+    random := rand.New(rand.NewSource(1))
+    randomLines := func() []string {
+        lines := make([]string, random.Intn(30))
+
+        for i := range lines {
+            lines[i] = string(rune('a' + random.Intn(4)))
+        }
+
+        return lines
+    }
+
+    for i := 0; i < 500; i++ {
+        a, b := randomLines(), randomLines()
+        diff := diffLines(a, b)
+
+        if applied := applyDiff(t, a, diff); strings.Join(applied, "\n") != strings.Join(b, "\n") {
+            t.Fatalf("diff %+v turns %q into %q, not %q", diff, a, applied, b)
+        }
+
+        if len(diff) != fewestEdits(a, b) {
+            t.Fatalf("diff from %q to %q has %d edits, the shortest %d", a, b, len(diff), fewestEdits(a, b))
+        }
+    }
+}
+
+func TestDiffLinesReplacesUnrelatedTexts(t *testing.T) {
+    a, b := make([]string, maxDiffEdits), make([]string, maxDiffEdits)
+
+    for i := range a {
+        a[i], b[i] = "stored " + toString(i), "compared " + toString(i)
+    }
+
+    if diff := diffLines(a, b); !reflect.DeepEqual(diff, replaceAll(a, b)) {
+        t.Errorf("texts needing %d edits are not entirely replaced", 2*maxDiffEdits)
+    }
+}
+
+//a long text with every tenth line changed, close to the bound of edits
+func BenchmarkDiffLines(b *testing.B) {
+    stored, compared := make([]string, 9000), make([]string, 9000)
+
+    for i := range stored {
+        stored[i], compared[i] = "line " + toString(i), "line " + toString(i)
+
+        if i%10 == 0 {
+            compared[i] = "changed " + toString(i)
+        }
+    }
+
+    b.ReportAllocs()
+
+    for i := 0; i < b.N; i++ {
+        if diff := diffLines(stored, compared); len(diff) != 1800 {
+            b.Fatalf("%d lines differ", len(diff))
+        }
+    }
+}
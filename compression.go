@@ -0,0 +1,181 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "flag"
+    "github.com/klauspost/compress/zstd"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/bsoncodec"
+    "go.mongodb.org/mongo-driver/bson/bsonrw"
+    "go.mongodb.org/mongo-driver/mongo/options"
+    "log"
+    "math"
+    "reflect"
+    "time"
+)
+
+/* large content data can be stored zstd compressed, which saves storage and traffic to
+the database for text heavy documents. With PRECISELY_COMPRESS_CONTENT=true, data of
+at least PRECISELY_COMPRESS_CONTENT_MIN_BYTES is compressed on write if that makes it
+smaller. The stored content then holds the compressed bytes and the compression used
+instead of data. Compression is a matter of storage only: documents are decompressed
+as they are read from the database, so clients, the history and all checks see plain
+data, whatever a document is stored as. Documents written before, or while compression
+was off, are converted with --migrate-content-compression */
+var compressContent bool = getEnvBool("PRECISELY_COMPRESS_CONTENT", false)
+var compressContentMinBytes int = getEnvInt("PRECISELY_COMPRESS_CONTENT_MIN_BYTES", 4096)
+
+var migrateCompressionOnly = flag.Bool("migrate-content-compression", false, "store the content data of all documents as PRECISELY_COMPRESS_CONTENT asks, then exit")
+
+const ZstdCompression = "zstd"
+
+//safe for concurrent use, each call runs on one goroutine
+var zstdEncoder, _ = zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+var zstdDecoder, _ = zstd.NewReader(nil)
+
+//content as stored in the database, with the default field names of DocumentContent
+type storedContent struct {
+    Header         *string
+    Data           *string //nil while compressed
+    ContentType    *string
+    Encoding       *string
+    Compression    string `bson:",omitempty"`
+    CompressedData []byte `bson:",omitempty"`
+}
+
+//the stored form of data: compressed if enabled and worth it, as is otherwise
+func toStoredData(data *string) (*string, string, []byte) {
+    if data == nil || !compressContent || len(*data) < compressContentMinBytes {
+        return data, "", nil
+    }
+
+    compressed := zstdEncoder.EncodeAll([]byte(*data), nil)
+
+    if len(compressed) >= len(*data) {
+        return data, "", nil
+    }
+
+    return nil, ZstdCompression, compressed
+}
+
+func (content DocumentContent) MarshalBSON() ([]byte, error) {
+    stored := storedContent{Header: content.Header, ContentType: content.ContentType, Encoding: content.Encoding}
+    stored.Data, stored.Compression, stored.CompressedData = toStoredData(content.Data)
+
+    return bson.Marshal(stored)
+}
+
+/* decodes stored content, decompressing its data. Registered on the client for the
+struct itself, so null content stays a nil pointer. Data set by a patch is stored as is,
+leaving compressed bytes behind which are no longer current, see toDataUpdate. Data, if
+present, is always the current one */
+func decodeDocumentContent(decodeCon bsoncodec.DecodeContext, reader bsonrw.ValueReader, value reflect.Value) error {
+    var stored storedContent
+    decoder, lookupErr := decodeCon.LookupDecoder(reflect.TypeOf(stored))
+
+    if lookupErr != nil {
+        return lookupErr
+    }
+
+    if decodeErr := decoder.DecodeValue(decodeCon, reader, reflect.ValueOf(&stored).Elem()); decodeErr != nil {
+        return decodeErr
+    }
+
+    content := DocumentContent{Header: stored.Header, Data: stored.Data, ContentType: stored.ContentType, Encoding: stored.Encoding}
+
+    if stored.Data == nil && stored.Compression != "" {
+        if stored.Compression != ZstdCompression {
+            return errors.New("content data is compressed with unknown " + stored.Compression)
+        }
+
+        data, decompressErr := zstdDecoder.DecodeAll(stored.CompressedData, nil)
+
+        if decompressErr != nil {
+            return decompressErr
+        }
+
+        decompressed := string(data)
+        content.Data = &decompressed
+    }
+
+    value.Set(reflect.ValueOf(content))
+    return nil
+}
+
+//the registry of the client, reading content as decodeDocumentContent does
+var documentRegistry *bsoncodec.Registry = bson.NewRegistryBuilder().
+    RegisterTypeDecoder(reflect.TypeOf(DocumentContent{}), bsoncodec.ValueDecoderFunc(decodeDocumentContent)).
+    Build()
+
+//fields set by an update of content data, storing it as it would be stored on create
+func toDataUpdate(data string) bson.D {
+    storedData, compression, compressedData := toStoredData(&data)
+
+    if compression == "" {
+        return bson.D{{Key: "content.data", Value: data}, {Key: "content.compression", Value: nil}, {Key: "content.compresseddata", Value: nil}}
+    }
+
+    return bson.D{{Key: "content.data", Value: storedData}, {Key: "content.compression", Value: compression}, {Key: "content.compresseddata", Value: compressedData}}
+}
+
+/* store the content data of all documents as currently configured: compress it, or
+decompress it after compression was turned off. Like a rebuild of projections, this runs
+in batches with pauses, skips documents written meanwhile and is recorded nowhere */
+func migrateContentCompression() {
+    //only documents stored otherwise than configured need to be rewritten
+    filter := bson.D{{Key: "content.compression", Value: bson.D{{Key: "$ne", Value: nil}}}}
+
+    if compressContent {
+        filter = bson.D{{Key: "content.data", Value: bson.D{{Key: "$type", Value: "string"}}}}
+    }
+
+    lastID := math.MinInt
+    opts := options.Find().SetSort(bson.D{{Key: "id", Value: 1}}).SetLimit(int64(rebuildBatchSize))
+    scanned, migrated := 0, 0
+
+    for {
+        pageFilter := append(bson.D{{Key: "id", Value: bson.D{{Key: "$gt", Value: lastID}}}}, filter...)
+        cursor, findErr := mongoCollection().Find(context.TODO(), pageFilter, opts)
+
+        if findErr != nil {
+            log.Fatal("Migrating content compression stopped after ", scanned, " documents: ", findErr)
+        }
+
+        var documents []Document
+
+        if allErr := cursor.All(context.TODO(), &documents); allErr != nil {
+            log.Fatal("Migrating content compression stopped after ", scanned, " documents: ", allErr)
+        }
+
+        if len(documents) == 0 {
+            log.Print("Migrated content compression: ", scanned, " documents scanned, ", migrated, " rewritten")
+            return
+        }
+
+        for _, document := range documents {
+            lastID = *document.ID
+            scanned++
+
+            if document.Content == nil || document.Content.Data == nil {
+                continue
+            }
+
+            //small data stays as is when compressing
+            if _, compression, _ := toStoredData(document.Content.Data); compressContent && compression == "" {
+                continue
+            }
+
+            update := bson.D{{Key: "$set", Value: toDataUpdate(*document.Content.Data)}}
+            result, updateErr := mongoCollection().UpdateOne(context.TODO(), unchangedRevisionFilter(document), update)
+
+            if updateErr != nil {
+                log.Fatal("Migrating content compression stopped at document ", lastID, ": ", updateErr)
+            }
+
+            migrated += int(result.ModifiedCount)
+        }
+
+        time.Sleep(rebuildPause)
+    }
+}
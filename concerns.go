@@ -0,0 +1,52 @@
+package main
+
+import (
+    "go.mongodb.org/mongo-driver/mongo/readconcern"
+    "go.mongodb.org/mongo-driver/mongo/writeconcern"
+    "strconv"
+)
+
+/* how durable a write must be before it is acknowledged, and how settled the data a
+read sees, trade safety against latency. They are set per class of operation: writes of
+single documents, like signing, default to majority, so an acknowledged write survives
+the loss of the primary. Batch writes, the transactions of POST /documents/transaction,
+bulk creates and signee reassignments, e.g. mass imports, can be acknowledged sooner. Reads of single
+documents and listings, counts and exports each have a read concern of their own */
+var documentWriteConcern *writeconcern.WriteConcern = parseWriteConcern("PRECISELY_WRITE_CONCERN", getEnv("PRECISELY_WRITE_CONCERN", "majority"))
+var batchWriteConcern *writeconcern.WriteConcern = parseWriteConcern("PRECISELY_BATCH_WRITE_CONCERN", getEnv("PRECISELY_BATCH_WRITE_CONCERN", getEnv("PRECISELY_WRITE_CONCERN", "majority")))
+
+var documentReadConcern *readconcern.ReadConcern = parseReadConcern("PRECISELY_READ_CONCERN", getEnv("PRECISELY_READ_CONCERN", "local"))
+var listReadConcern *readconcern.ReadConcern = parseReadConcern("PRECISELY_LIST_READ_CONCERN", getEnv("PRECISELY_LIST_READ_CONCERN", getEnv("PRECISELY_READ_CONCERN", "local")))
+
+//majority or a number of members of at least 1. Unacknowledged writes can not be part of a transaction
+func parseWriteConcern(setting string, value string) *writeconcern.WriteConcern {
+    if value == "majority" {
+        return writeconcern.New(writeconcern.WMajority())
+    }
+
+    members, convErr := strconv.Atoi(value)
+
+    if convErr != nil || members < 1 {
+        settingProblem(setting + " must be majority or a number of at least 1: " + value)
+        return writeconcern.New(writeconcern.WMajority())
+    }
+
+    return writeconcern.New(writeconcern.W(members))
+}
+
+//a read concern level usable outside of transactions
+func parseReadConcern(setting string, value string) *readconcern.ReadConcern {
+    switch value {
+    case "local":
+      return readconcern.Local()
+    case "available":
+      return readconcern.Available()
+    case "majority":
+      return readconcern.Majority()
+    case "linearizable":
+      return readconcern.Linearizable()
+    }
+
+    settingProblem(setting + " must be local, available, majority or linearizable: " + value)
+    return readconcern.Local()
+}
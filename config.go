@@ -0,0 +1,81 @@
+package main
+
+import (
+    "os"
+    "strconv"
+    "sync/atomic"
+)
+
+/* settings are read from environment variables, falling back to the given
+default when a variable is unset or empty */
+func getEnv(key string, fallback string) string {
+    if value := os.Getenv(key); value != "" {
+        return value
+    }
+
+    return fallback
+}
+
+/* problems of settings found while reading them. Settings are read as the package
+initializes, so rather than stopping at the first malformed one, each problem is
+noted and the default used, and all of them are reported by the startup checks,
+which then stop startup, see startup.go */
+var settingProblems []string
+
+func settingProblem(problem string) {
+    settingProblems = append(settingProblems, problem)
+}
+
+//like getEnv, for settings holding a whole number
+func getEnvInt(key string, fallback int) int {
+    value := os.Getenv(key)
+
+    if value == "" {
+        return fallback
+    }
+
+    number, convErr := strconv.Atoi(value)
+
+    if convErr != nil {
+        settingProblem(key + " is not a number: " + value)
+        return fallback
+    }
+
+    return number
+}
+
+//like getEnv, for on/off settings
+func getEnvBool(key string, fallback bool) bool {
+    value := os.Getenv(key)
+
+    if value == "" {
+        return fallback
+    }
+
+    flag, parseErr := strconv.ParseBool(value)
+
+    if parseErr != nil {
+        settingProblem(key + " is not true or false: " + value)
+        return fallback
+    }
+
+    return flag
+}
+
+//a whole number setting admins can change while the service runs, see runtime.go
+type tunableInt struct {
+    value int64
+}
+
+func newTunableInt(value int) *tunableInt {
+    return &tunableInt{int64(value)}
+}
+
+func (setting *tunableInt) Get() int {
+    return int(atomic.LoadInt64(&setting.value))
+}
+
+//set a new value, returning the replaced one
+func (setting *tunableInt) Set(value int) int {
+    return int(atomic.SwapInt64(&setting.value, int64(value)))
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+    "os"
+    "testing"
+)
+
+//the problems recorded while running read, leaving settingProblems as it was
+func recordedProblems(read func()) []string {
+    saved := settingProblems
+    settingProblems = nil
+    defer func() { settingProblems = saved }()
+
+    read()
+    return settingProblems
+}
+
+func TestMalformedSettingsAreReported(t *testing.T) {
+    os.Setenv("PRECISELY_TEST_NUMBER", "ten")
+    os.Setenv("PRECISELY_TEST_SWITCH", "yes")
+    defer os.Unsetenv("PRECISELY_TEST_NUMBER")
+    defer os.Unsetenv("PRECISELY_TEST_SWITCH")
+
+    tests := []struct {
+        name     string
+        read     func()
+        problems int
+    }{
+        {"number", func() {
+            if value := getEnvInt("PRECISELY_TEST_NUMBER", 10); value != 10 {
+                t.Errorf("malformed number read as %d, expected the fallback 10", value)
+            }
+        }, 1},
+        {"switch", func() {
+            if value := getEnvBool("PRECISELY_TEST_SWITCH", true); !value {
+                t.Error("malformed switch read as false, expected the fallback true")
+            }
+        }, 1},
+        {"unset number", func() { getEnvInt("PRECISELY_TEST_UNSET", 10) }, 0},
+        {"write concern", func() { parseWriteConcern("PRECISELY_WRITE_CONCERN", "0") }, 1},
+        {"read concern", func() { parseReadConcern("PRECISELY_READ_CONCERN", "snapshot") }, 1},
+        {"proxies", func() {
+            if networks := parseTrustedProxies("10.0.0.0/8, proxy, 300.1.1.1"); len(networks) != 1 {
+                t.Errorf("%d trusted proxies, expected only the well-formed one", len(networks))
+            }
+        }, 2},
+        {"micro cache", func() { parseMicroCache("/documents/:id=soon") }, 1},
+    }
+
+    for _, test := range tests {
+        if problems := recordedProblems(test.read); len(problems) != test.problems {
+            t.Errorf("%s recorded %q, expected %d problems", test.name, problems, test.problems)
+        }
+    }
+}
+
+func TestCheckSettingsReportsSettingProblems(t *testing.T) {
+    saved := settingProblems
+    settingProblems = []string{"PRECISELY_TEST_NUMBER is not a number: ten"}
+    defer func() { settingProblems = saved }()
+
+    if problem := checkSettings(); problem == "" {
+        t.Error("checkSettings passed despite a recorded setting problem")
+    }
+}
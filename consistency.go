@@ -0,0 +1,99 @@
+package main
+
+import (
+    "context"
+    "encoding/base64"
+    "github.com/gin-gonic/gin"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+    "net/http"
+)
+
+/* writes answer with an X-Consistency-Token header holding the cluster time of the
+write. Sending it back with a read makes the read wait until the member serving it
+has caught up with that write, so a client reading from secondaries always sees its
+own writes. The token is opaque to clients */
+const consistencyTokenHeader = "X-Consistency-Token"
+
+type consistencyToken struct {
+    ClusterTime   bson.Raw            //signed by the cluster, so it can be handed back to it
+    OperationTime primitive.Timestamp
+}
+
+//token of the last operation of a session, or an empty string if the session has none
+func encodeConsistencyToken(session mongo.Session) string {
+    if session.ClusterTime() == nil || session.OperationTime() == nil {
+        return ""
+    }
+
+    serialToken, serialErr := bson.Marshal(consistencyToken{session.ClusterTime(), *session.OperationTime()})
+
+    if serialErr != nil {
+        return ""
+    }
+
+    return base64.RawURLEncoding.EncodeToString(serialToken)
+}
+
+func decodeConsistencyToken(encodedToken string) (consistencyToken, error) {
+    var token consistencyToken
+    serialToken, decodeErr := base64.RawURLEncoding.DecodeString(encodedToken)
+
+    if decodeErr != nil {
+        return token, decodeErr
+    }
+
+    return token, bson.Unmarshal(serialToken, &token)
+}
+
+//answer a write with its consistency token
+func sendConsistencyToken(ginCon *gin.Context, request *writeRequest) {
+    if request.ConsistencyToken != "" {
+        ginCon.Header(consistencyTokenHeader, request.ConsistencyToken)
+    }
+}
+
+func newWriteRequest(ginCon *gin.Context) *writeRequest {
+    return &writeRequest{TraceParent: requestTraceParent(ginCon), Context: ginCon.Request.Context()}
+}
+
+/* context for the reads of a request, causally consistent with the write of the token
+sent along, if any. The returned function ends the reads and must always be called.
+Sends the error response and returns a nil context if the token is malformed */
+func readContext(ginCon *gin.Context) (context.Context, func()) {
+    encodedToken := ginCon.GetHeader(consistencyTokenHeader)
+
+    if encodedToken == "" {
+        return ginCon.Request.Context(), func() {}
+    }
+
+    token, tokenErr := decodeConsistencyToken(encodedToken)
+
+    if tokenErr != nil {
+        sendJsonHttpResponse(ginCon, http.StatusBadRequest, HttpError{CodeValidationFailed, consistencyTokenHeader + " is not a token returned by a write"})
+        return nil, func() {}
+    }
+
+    session, sessionErr := getMongoClient().StartSession(options.Session().SetCausalConsistency(true))
+
+    if sessionErr != nil {
+        sendDbUnavailable(ginCon)
+        return nil, func() {}
+    }
+
+    advanceErr := session.AdvanceClusterTime(token.ClusterTime)
+
+    if advanceErr == nil {
+        advanceErr = session.AdvanceOperationTime(&token.OperationTime)
+    }
+
+    if advanceErr != nil {
+        session.EndSession(context.TODO())
+        sendJsonHttpResponse(ginCon, http.StatusBadRequest, HttpError{CodeValidationFailed, consistencyTokenHeader + " is not a token returned by a write"})
+        return nil, func() {}
+    }
+
+    return mongo.NewSessionContext(ginCon.Request.Context(), session), func() { session.EndSession(context.TODO()) }
+}
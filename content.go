@@ -0,0 +1,112 @@
+package main
+
+import (
+    "bytes"
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/hex"
+    "github.com/gin-gonic/gin"
+    "mime"
+    "net/http"
+    "strings"
+    "time"
+)
+
+/* media types content is served as when clients stored them. Clients choose contentType
+freely, so any type a browser would run, like text/html or image/svg+xml, would let a
+stored document script the API's origin; those are served as application/octet-stream */
+var inertContentTypes = map[string]bool{
+    "text/plain":               true,
+    "text/csv":                 true,
+    "application/octet-stream": true,
+    "application/pdf":          true,
+    "application/json":         true,
+    "application/zip":          true,
+    "application/gzip":         true,
+    "image/png":                true,
+    "image/jpeg":               true,
+    "image/gif":                true,
+    "image/webp":               true,
+}
+
+//the stored content type if inert, keeping only its charset, else application/octet-stream
+func servedContentType(stored string) string {
+    mediaType, params, parseErr := mime.ParseMediaType(stored)
+
+    if parseErr != nil || !inertContentTypes[mediaType] {
+        return "application/octet-stream"
+    }
+
+    if charset, hasCharset := params["charset"]; hasCharset && strings.HasPrefix(mediaType, "text/") {
+        return mime.FormatMediaType(mediaType, map[string]string{"charset": charset})
+    }
+
+    return mediaType
+}
+
+/* the content data of a document on its own, as raw bytes instead of a string within
+json: base64 content decoded, e.g. a scanned contract as application/pdf, and text
+content as utf-8. Clients can download it directly, resume broken downloads and read
+parts of it with range requests. The ETag is a hash of the data, so If-None-Match and
+If-Range requests are answered without sending unchanged data again */
+func handleGetDocumentContent(ginCon *gin.Context) {
+    id, toIntErr := toInt(getIDParam(ginCon))
+
+    if toIntErr != nil {
+      sendJsonHttpResponse(ginCon, http.StatusBadRequest, HttpError{CodeValidationFailed, "requested id '" + getIDParam(ginCon) + "' is not a number"})
+      return
+    }
+
+    params := ReadParams{defaultReadPreference}
+
+    if paramsProblem := bindQuery(ginCon, &params); paramsProblem != "" {
+      sendJsonHttpResponse(ginCon, http.StatusBadRequest, HttpError{CodeValidationFailed, paramsProblem})
+      return
+    }
+
+    readPreference := params.ReadPreference
+
+    status, document := getDocumentIn(ginCon.Request.Context(), id, readPreference)
+
+    switch status {
+    case OK:
+    default:
+      sendDocumentStatus(ginCon, status, id)
+      return
+    }
+
+    if document.Content == nil || document.Content.Data == nil {
+      sendJsonHttpResponse(ginCon, http.StatusInternalServerError, HttpError{CodeInternal, "unexpected server state"})
+      return
+    }
+
+    data := []byte(*document.Content.Data)
+    contentType := "text/plain; charset=utf-8"
+
+    if document.Content.Encoding != nil && *document.Content.Encoding == Base64Encoding {
+      var decodeErr error
+
+      //validated on write, so only data stored outside of the API fails to decode
+      if data, decodeErr = base64.StdEncoding.DecodeString(*document.Content.Data); decodeErr != nil {
+        sendJsonHttpResponse(ginCon, http.StatusInternalServerError, HttpError{CodeInternal, "unexpected server state"})
+        return
+      }
+
+      contentType = "application/octet-stream"
+    }
+
+    if document.Content.ContentType != nil {
+      contentType = servedContentType(*document.Content.ContentType)
+    }
+
+    hash := sha256.Sum256(data)
+    ginCon.Header("Content-Type", contentType)
+    //downloaded rather than shown, and never sniffed into something a browser would run
+    ginCon.Header("Content-Disposition", "attachment")
+    ginCon.Header("X-Content-Type-Options", "nosniff")
+    ginCon.Header("ETag", `"` + hex.EncodeToString(hash[:16]) + `"`)
+    ginCon.Header("Cache-Control", "no-cache")
+
+    //serves ranges and conditional requests; without a modification time, only by ETag
+    http.ServeContent(ginCon.Writer, ginCon.Request, "", time.Time{}, bytes.NewReader(data))
+}
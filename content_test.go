@@ -0,0 +1,29 @@
+package main
+
+import (
+    "testing"
+)
+
+func TestServedContentType(t *testing.T) {
+    tests := []struct {
+        stored string
+        served string
+    }{
+        {"application/pdf", "application/pdf"},
+        {"image/PNG", "image/png"},
+        {"text/plain; charset=ISO-8859-1", "text/plain; charset=ISO-8859-1"},
+        {"application/json; charset=utf-8; boundary=x", "application/json"},
+        {"text/html", "application/octet-stream"},
+        {"text/html; charset=utf-8", "application/octet-stream"},
+        {"image/svg+xml", "application/octet-stream"},
+        {"application/xhtml+xml", "application/octet-stream"},
+        {"text/plain, text/html", "application/octet-stream"},
+        {"", "application/octet-stream"},
+    }
+
+    for _, test := range tests {
+        if served := servedContentType(test.stored); served != test.served {
+            t.Errorf("%q is served as %q, expected %q", test.stored, served, test.served)
+        }
+    }
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/mongo"
+    "reflect"
+    "testing"
+)
+
+func stripPatch() Document {
+    return Document{ID: intOf(7), Title: stringOf("Lease agreement, amended"), Content: &DocumentContent{Header: stringOf("Lease of the premises at Main Street 1")}, Signee: stringOf("John Roe")}
+}
+
+//nested objects to dot notation keys, as the flatten package did for toStrippedMap
+func flattenInto(flat map[string]interface{}, prefix string, value interface{}) {
+    nested, isObject := value.(map[string]interface{})
+
+    if !isObject {
+        flat[prefix] = value
+        return
+    }
+
+    for key, child := range nested {
+        if prefix != "" {
+            key = prefix + "." + key
+        }
+
+        flattenInto(flat, key, child)
+    }
+}
+
+//the update as built before toStrippedUpdate: marshalled to json, flattened and unmarshalled again
+func toStrippedMapRoundTrip(document Document) (map[string]interface{}, error) {
+    serialDocument, serialErr := json.Marshal(document)
+
+    if serialErr != nil {
+        return nil, serialErr
+    }
+
+    var nested map[string]interface{}
+
+    if unmarshalErr := json.Unmarshal(serialDocument, &nested); unmarshalErr != nil {
+        return nil, unmarshalErr
+    }
+
+    flatDocument := make(map[string]interface{})
+    flattenInto(flatDocument, "", nested)
+    serialFlat, flatErr := json.Marshal(flatDocument)
+
+    if flatErr != nil {
+        return nil, flatErr
+    }
+
+    strippedMap := make(map[string]interface{})
+    return strippedMap, json.Unmarshal(serialFlat, &strippedMap)
+}
+
+//before: json round-trip
+func BenchmarkToStrippedMapRoundTrip(b *testing.B) {
+    patch := stripPatch()
+    b.ReportAllocs()
+
+    for i := 0; i < b.N; i++ {
+        if _, stripErr := toStrippedMapRoundTrip(patch); stripErr != nil {
+            b.Fatal(stripErr)
+        }
+    }
+}
+
+//after: fields appended directly
+func BenchmarkToStrippedUpdate(b *testing.B) {
+    patch := stripPatch()
+    b.ReportAllocs()
+
+    for i := 0; i < b.N; i++ {
+        toStrippedUpdate(patch)
+    }
+}
+
+//both ways set the same fields, but only the direct one keeps the id an int
+func TestToStrippedUpdateMatchesRoundTrip(t *testing.T) {
+    patch := stripPatch()
+    roundTrip, stripErr := toStrippedMapRoundTrip(patch)
+
+    if stripErr != nil {
+        t.Fatal(stripErr)
+    }
+
+    update := toStrippedUpdate(patch).Map()
+
+    if len(update) != len(roundTrip) {
+        t.Fatalf("update sets %v, the round-trip %v", update, roundTrip)
+    }
+
+    for key, value := range roundTrip {
+        if key == "id" {
+            if _, isFloat := value.(float64); !isFloat {
+                t.Errorf("round-trip id is %T, expected the float64 it used to lose precision to", value)
+            }
+
+            if update[key] != 7 {
+                t.Errorf("update id is %#v, expected int 7", update[key])
+            }
+
+            continue
+        }
+
+        if update[key] != value {
+            t.Errorf("update sets %s to %#v, the round-trip to %#v", key, update[key], value)
+        }
+    }
+}
+
+//the raw bson of a document as the collection returns it, with compressed content data
+func rawStoredDocument(tb testing.TB) bson.Raw {
+    document := sampleDocument()
+    storedData, compression, compressedData := toStoredData(document.Content.Data)
+    content := bson.D{{Key: "header", Value: document.Content.Header}, {Key: "data", Value: storedData}, {Key: "compression", Value: compression}, {Key: "compresseddata", Value: compressedData}}
+    raw, marshalErr := bson.Marshal(bson.D{
+        {Key: "id", Value: document.ID},
+        {Key: "title", Value: document.Title},
+        {Key: "content", Value: content},
+        {Key: "signee", Value: document.Signee},
+        {Key: "metadata", Value: document.Metadata},
+        {Key: "references", Value: document.References},
+    })
+
+    if marshalErr != nil {
+        tb.Fatal(marshalErr)
+    }
+
+    return raw
+}
+
+func TestDecodeDocumentMatchesClient(t *testing.T) {
+    raw := rawStoredDocument(t)
+    var expected, decoded Document
+
+    if unmarshalErr := bson.UnmarshalWithRegistry(documentRegistry, raw, &expected); unmarshalErr != nil {
+        t.Fatal(unmarshalErr)
+    }
+
+    //twice, the second time through a reused reader and decoder
+    for i := 0; i < 2; i++ {
+        decoded = Document{}
+
+        if decodeErr := decodeDocument(raw, &decoded); decodeErr != nil {
+            t.Fatal(decodeErr)
+        }
+
+        if !reflect.DeepEqual(decoded, expected) {
+            t.Errorf("decoded %+v, the client %+v", decoded, expected)
+        }
+    }
+
+    if *decoded.Content.Data != *sampleDocument().Content.Data {
+        t.Errorf("content data isn't decompressed: %q", *decoded.Content.Data)
+    }
+}
+
+//before: decoded as the client does, with a fresh reader per document
+func BenchmarkUnmarshalDocument(b *testing.B) {
+    raw := rawStoredDocument(b)
+    b.ReportAllocs()
+
+    for i := 0; i < b.N; i++ {
+        var document Document
+
+        if unmarshalErr := bson.UnmarshalWithRegistry(documentRegistry, raw, &document); unmarshalErr != nil {
+            b.Fatal(unmarshalErr)
+        }
+    }
+}
+
+//after: decoded through a pooled reader and decoder
+func BenchmarkDecodeDocument(b *testing.B) {
+    raw := rawStoredDocument(b)
+    b.ReportAllocs()
+
+    for i := 0; i < b.N; i++ {
+        var document Document
+
+        if decodeErr := decodeDocument(raw, &document); decodeErr != nil {
+            b.Fatal(decodeErr)
+        }
+    }
+}
+
+func TestAfterCursorFilter(t *testing.T) {
+    tests := []struct {
+        sortField  string
+        descending bool
+        after      *ListCursor
+        filter     bson.D
+    }{
+        {"id", false, nil, bson.D{}},
+        {"id", false, &ListCursor{SortValue: 4.0, ID: 4}, bson.D{{Key: "id", Value: bson.D{{Key: "$gt", Value: 4}}}}},
+        {"id", true, &ListCursor{SortValue: 4.0, ID: 4, Sort: "-id"}, bson.D{{Key: "id", Value: bson.D{{Key: "$lt", Value: 4}}}}},
+        {"title", false, &ListCursor{SortValue: "Lease", ID: 4, Sort: "title"}, bson.D{{Key: "$or", Value: bson.A{
+            bson.D{{Key: "title", Value: bson.D{{Key: "$gt", Value: "Lease"}}}},
+            bson.D{{Key: "title", Value: "Lease"}, {Key: "id", Value: bson.D{{Key: "$gt", Value: 4}}}},
+        }}}},
+    }
+
+    for _, test := range tests {
+        if filter := afterCursorFilter(test.sortField, test.descending, test.after); !reflect.DeepEqual(filter, test.filter) {
+            t.Errorf("filter after %+v by %s is %v, expected %v", test.after, test.sortField, filter, test.filter)
+        }
+    }
+}
+
+func TestFailedWriteIndexes(t *testing.T) {
+    tests := []struct {
+        writeErr error
+        failed   []int
+    }{
+        {errors.New("connection lost"), []int{0, 1, 2}},
+        {mongo.BulkWriteException{WriteErrors: []mongo.BulkWriteError{{WriteError: mongo.WriteError{Index: 1, Code: duplicateKeyCode}}}}, []int{1}},
+        {mongo.BulkWriteException{WriteConcernError: &mongo.WriteConcernError{Code: 64}}, []int{}},
+    }
+
+    for _, test := range tests {
+        if failed := failedWriteIndexes(test.writeErr, 3); !reflect.DeepEqual(failed, test.failed) {
+            t.Errorf("%v failed writes %v, expected %v", test.writeErr, failed, test.failed)
+        }
+    }
+}
+
+func TestIsDuplicateIdError(t *testing.T) {
+    duplicate := func(code int, message string) error {
+        return mongo.WriteException{WriteErrors: mongo.WriteErrors{{Code: code, Message: message}}}
+    }
+
+    tests := []struct {
+        writeErr error
+        idTaken  bool
+    }{
+        {duplicate(11000, "E11000 duplicate key error collection: precisely-db.precisely-documents index: id_1 dup key: { id: 5 }"), true},
+        {duplicate(11000, "E11000 duplicate key error collection: precisely-db.precisely-documents index: references.system_1_references.externalid_1 dup key: { references.system: \"crm\", references.externalid: \"A-1\" }"), false},
+        {duplicate(112, "WriteConflict error: this operation conflicted with another operation. index: id_1 "), false},
+        {mongo.CommandError{Code: 11000, Message: "index: id_1 dup key"}, false},
+        {nil, false},
+    }
+
+    for _, test := range tests {
+        if idTaken := isDuplicateIdError(test.writeErr); idTaken != test.idTaken {
+            t.Errorf("%v is a duplicate id: %v, expected %v", test.writeErr, idTaken, test.idTaken)
+        }
+    }
+
+    if !errIdTaken.HasErrorLabel("TransientTransactionError") {
+        t.Error("errIdTaken must make WithTransaction run the transaction again")
+    }
+}
+
+//documents created through the repository, returning their ids
+func createTestDocuments(tb testing.TB, count int) []int {
+    ids := make([]int, count)
+
+    for i := range ids {
+        document := sampleDocument()
+        document.References = nil //unique to one document
+        status, created := createDocument(document, &writeRequest{})
+
+        if status != OK {
+            tb.Fatalf("creating document %d: status %d", i, status)
+        }
+
+        ids[i] = *created.ID
+    }
+
+    return ids
+}
+
+/* the repository operations against a MongoDB of the tests, see useTestDatabase. Run
+with e.g. go test -run none -bench Repository -benchmem */
+func BenchmarkRepositoryCreate(b *testing.B) {
+    useTestDatabase(b)
+    document := sampleDocument()
+    document.References = nil
+    b.ReportAllocs()
+    b.ResetTimer()
+
+    for i := 0; i < b.N; i++ {
+        if status, _ := createDocument(document, &writeRequest{}); status != OK {
+            b.Fatalf("status %d", status)
+        }
+    }
+}
+
+func BenchmarkRepositoryGet(b *testing.B) {
+    useTestDatabase(b)
+    ids := createTestDocuments(b, 100)
+    b.ReportAllocs()
+    b.ResetTimer()
+
+    for i := 0; i < b.N; i++ {
+        if status, _ := getDocumentIn(context.Background(), ids[i % len(ids)], "primary"); status != OK {
+            b.Fatalf("status %d", status)
+        }
+    }
+}
+
+func BenchmarkRepositoryList(b *testing.B) {
+    useTestDatabase(b)
+    createTestDocuments(b, 100)
+    query := ListQuery{ReadPreference: "primary", Limit: 50}
+    b.ReportAllocs()
+    b.ResetTimer()
+
+    for i := 0; i < b.N; i++ {
+        if status, documents := getDocuments(context.Background(), query); status != OK || len(documents) != 50 {
+            b.Fatalf("status %d with %d documents", status, len(documents))
+        }
+    }
+}
+
+func BenchmarkRepositoryUpdate(b *testing.B) {
+    useTestDatabase(b)
+    ids := createTestDocuments(b, 100)
+    b.ReportAllocs()
+    b.ResetTimer()
+
+    for i := 0; i < b.N; i++ {
+        patch := Document{ID: intOf(ids[i % len(ids)]), Signee: stringOf("Signee " + toString(i))}
+
+        if status, _ := updateDocument(patch, &writeRequest{}); status != OK {
+            b.Fatalf("status %d", status)
+        }
+    }
+}
+
+func BenchmarkRepositoryDelete(b *testing.B) {
+    useTestDatabase(b)
+    ids := createTestDocuments(b, b.N)
+    b.ReportAllocs()
+    b.ResetTimer()
+
+    for i := 0; i < b.N; i++ {
+        if status := deleteDocument(ids[i], &writeRequest{}); status != OK {
+            b.Fatalf("status %d", status)
+        }
+    }
+}
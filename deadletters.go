@@ -0,0 +1,139 @@
+package main
+
+import (
+    "context"
+    "github.com/gin-gonic/gin"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+    "log"
+    "net/http"
+    "time"
+)
+
+/* outbox entries failing this many deliveries are moved to the dead-letter collection,
+where they wait for an operator to redeliver them once the receiving side is fixed */
+var maxOutboxAttempts *tunableInt = newTunableInt(getEnvInt("PRECISELY_OUTBOX_MAX_ATTEMPTS", 10))
+
+var deadLetterCollectionName string = "precisely-dead-letters"
+
+func deadLetterCollection() *mongo.Collection {
+    return getMongoClient().Database(databaseName).Collection(deadLetterCollectionName)
+}
+
+//move an entry from the outbox to the dead letters, in one transaction so it is never lost or duplicated
+func moveToDeadLetters(entry OutboxEntry) error {
+    session, sessionErr := getMongoClient().StartSession()
+
+    if sessionErr != nil {
+        return sessionErr
+    }
+
+    defer session.EndSession(context.TODO())
+
+    _, transactionErr := session.WithTransaction(context.TODO(), func(ctx mongo.SessionContext) (interface{}, error) {
+        if _, insertErr := deadLetterCollection().InsertOne(ctx, entry); insertErr != nil {
+            return nil, insertErr
+        }
+
+        _, deleteErr := outboxCollection().DeleteOne(ctx, bson.D{{Key: "_id", Value: entry.ID}})
+        return nil, deleteErr
+    })
+
+    return transactionErr
+}
+
+func getDeadLetters() (DocumentStatus, []OutboxEntry) {
+    opts := options.Find().
+        SetSort(bson.D{{Key: "createdat", Value: 1}}).
+        SetLimit(int64(maxListSize.Get()))
+    cursor, findErr := deadLetterCollection().Find(context.TODO(), bson.D{}, opts)
+
+    if findErr != nil {
+        return CouldNotProceed, nil
+    }
+
+    deadLetters := []OutboxEntry{}
+
+    if allErr := cursor.All(context.TODO(), &deadLetters); allErr != nil {
+        return CouldNotProceed, nil
+    }
+
+    return OK, deadLetters
+}
+
+//move a dead letter back into the outbox with a fresh set of attempts, due right away
+func redeliverDeadLetter(id primitive.ObjectID) (DocumentStatus, *OutboxEntry) {
+    session, sessionErr := getMongoClient().StartSession()
+
+    if sessionErr != nil {
+        return CouldNotProceed, nil
+    }
+
+    defer session.EndSession(context.TODO())
+
+    status := OK
+    var entry OutboxEntry
+
+    _, transactionErr := session.WithTransaction(context.TODO(), func(ctx mongo.SessionContext) (interface{}, error) {
+        findErr := deadLetterCollection().FindOneAndDelete(ctx, bson.D{{Key: "_id", Value: id}}).Decode(&entry)
+
+        if findErr != nil {
+            if findErr == mongo.ErrNoDocuments {
+                status = NotFound
+            }
+
+            return nil, findErr
+        }
+
+        entry.Attempts = 0
+        entry.NextAttemptAt = time.Now().UTC()
+
+        _, insertErr := outboxCollection().InsertOne(ctx, entry)
+        return nil, insertErr
+    })
+
+    if status != OK {
+        return status, nil
+    }
+
+    if transactionErr != nil {
+        return CouldNotProceed, nil
+    }
+
+    log.Print("Dead letter ", id.Hex(), " for ", entry.Channel, " queued for redelivery")
+
+    return OK, &entry
+}
+
+func handleGetDeadLetters(ginCon *gin.Context) {
+    status, deadLetters := getDeadLetters()
+
+    switch status {
+    case OK:
+      sendJsonHttpResponse(ginCon, http.StatusOK, deadLetters)
+    default:
+      sendStatus(ginCon, status, "the dead letters")
+    }
+}
+
+func handleRedeliverDeadLetter(ginCon *gin.Context) {
+    id, idErr := primitive.ObjectIDFromHex(getIDParam(ginCon))
+
+    if idErr != nil {
+      sendJsonHttpResponse(ginCon, http.StatusBadRequest, HttpError{CodeValidationFailed, "requested id '" + getIDParam(ginCon) + "' is not a dead letter id"})
+      return
+    }
+
+    status, entry := redeliverDeadLetter(id)
+
+    switch status {
+    case OK:
+      sendJsonHttpResponse(ginCon, http.StatusAccepted, entry)
+    case NotFound:
+      sendJsonHttpResponse(ginCon, http.StatusNotFound, HttpError{CodeDeadLetterNotFound, "could not find dead letter with id " + getIDParam(ginCon)})
+    default:
+      sendStatus(ginCon, status, "the dead letter")
+    }
+}
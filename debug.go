@@ -0,0 +1,145 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "github.com/gin-gonic/gin"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/mongo/options"
+    "go.mongodb.org/mongo-driver/mongo/readpref"
+    "strconv"
+    "time"
+)
+
+/* admins can send "X-Debug: true" with a list request to learn how it was served:
+the filter sent to MongoDB, the index the query planner chose, and where the time
+went. The header is ignored on requests without the admin token, since it reveals
+internals of the database */
+type DebugInfo struct {
+    Filter       json.RawMessage    `json:"filter"` //MongoDB extended json
+    Sort         json.RawMessage    `json:"sort"`
+    Limit        int                `json:"limit"`
+    Index        string             `json:"index"` //"COLLSCAN" when no index was used
+    KeysExamined int64              `json:"keysExamined"`
+    DocsExamined int64              `json:"docsExamined"`
+    TimingsMs    map[string]float64 `json:"timingsMs"`
+}
+
+//list response carrying debug information next to the documents
+type DebugListResponse struct {
+    ListResponse
+    Debug DebugInfo `json:"_debug"`
+}
+
+func isDebugRequest(ginCon *gin.Context) bool {
+    debug, _ := strconv.ParseBool(ginCon.GetHeader("X-Debug"))
+    return debug && isAdminRequest(ginCon)
+}
+
+func millisSince(start time.Time) float64 {
+    return float64(time.Since(start).Microseconds()) / 1000
+}
+
+//name of the index used by an explain plan, searched for anywhere since plans nest differently per topology
+func findPlanIndex(plan interface{}) string {
+    switch typedPlan := plan.(type) {
+    case bson.M:
+      if typedPlan["stage"] == "IXSCAN" {
+          if indexName, isString := typedPlan["indexName"].(string); isString {
+              return indexName
+          }
+      }
+
+      if typedPlan["stage"] == "COLLSCAN" {
+          return "COLLSCAN"
+      }
+
+      for _, child := range typedPlan {
+          if index := findPlanIndex(child); index != "" {
+              return index
+          }
+      }
+    case bson.A:
+      for _, child := range typedPlan {
+          if index := findPlanIndex(child); index != "" {
+              return index
+          }
+      }
+    }
+
+    return ""
+}
+
+func toInt64(value interface{}) int64 {
+    switch number := value.(type) {
+    case int32:
+      return int64(number)
+    case int64:
+      return number
+    case float64:
+      return int64(number)
+    }
+
+    return 0
+}
+
+/* explain a list query with execution statistics. This runs the query once more,
+so it is only done for debug requests */
+func explainListQuery(query ListQuery, debug *DebugInfo) error {
+    filter := listFilter(query)
+    var serialErr error
+
+    if debug.Filter, serialErr = bson.MarshalExtJSON(filter, false, false); serialErr != nil {
+        return serialErr
+    }
+
+    if debug.Sort, serialErr = bson.MarshalExtJSON(listSort(query), false, false); serialErr != nil {
+        return serialErr
+    }
+
+    debug.Limit = query.Limit
+
+    readMode, modeErr := readpref.ModeFromString(query.ReadPreference)
+
+    if modeErr != nil {
+        return modeErr
+    }
+
+    readPreference, prefErr := readpref.New(readMode)
+
+    if prefErr != nil {
+        return prefErr
+    }
+
+    command := bson.D{
+        {Key: "explain", Value: bson.D{
+            {Key: "find", Value: collectionName},
+            {Key: "filter", Value: filter},
+            {Key: "sort", Value: listSort(query)},
+            {Key: "limit", Value: query.Limit},
+            {Key: "skip", Value: query.Skip},
+        }},
+        {Key: "verbosity", Value: "executionStats"},
+    }
+
+    var explanation bson.M
+    start := time.Now()
+    explainErr := getMongoClient().Database(databaseName).
+        RunCommand(context.TODO(), command, options.RunCmd().SetReadPreference(readPreference)).
+        Decode(&explanation)
+    debug.TimingsMs["explain"] = millisSince(start)
+
+    if explainErr != nil {
+        return explainErr
+    }
+
+    debug.Index = findPlanIndex(explanation["queryPlanner"])
+
+    if stats, isMap := explanation["executionStats"].(bson.M); isMap {
+        debug.KeysExamined = toInt64(stats["totalKeysExamined"])
+        debug.DocsExamined = toInt64(stats["totalDocsExamined"])
+        debug.TimingsMs["execution"] = float64(toInt64(stats["executionTimeMillis"]))
+    }
+
+    return nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+    "bytes"
+    "compress/gzip"
+    "github.com/gin-gonic/gin"
+    "io"
+    "io/ioutil"
+    "net/http"
+    "strings"
+)
+
+/* clients may gzip request bodies, sending "Content-Encoding: gzip". The body is
+decompressed before the handler sees it, on routes accepting a body only. Both the
+compressed body and the decompressed one are held to the body limit of the route, so
+small compressed bodies can not expand into huge ones */
+
+//a reader keeping the error of the body it reads, to tell a body beyond limitBody from malformed gzip
+type errorKeepingReader struct {
+    reader io.Reader
+    err    error
+}
+
+func (keeping *errorKeepingReader) Read(data []byte) (int, error) {
+    count, readErr := keeping.reader.Read(data)
+
+    if readErr != nil && readErr != io.EOF {
+        keeping.err = readErr
+    }
+
+    return count, readErr
+}
+
+//route middleware decompressing gzipped request bodies up to limit, after limitBody, see routePolicy.middleware
+func decompressRequest(limit int64) gin.HandlerFunc {
+    return func(ginCon *gin.Context) {
+        decompressBody(ginCon, limit)
+    }
+}
+
+func decompressBody(ginCon *gin.Context, limit int64) {
+    encoding := strings.ToLower(strings.TrimSpace(ginCon.GetHeader("Content-Encoding")))
+
+    if encoding == "" || encoding == "identity" {
+        ginCon.Next()
+        return
+    }
+
+    if encoding != "gzip" {
+        sendJsonHttpResponse(ginCon, http.StatusUnsupportedMediaType, HttpError{CodeUnsupportedCompression, "request bodies may only be encoded with gzip"})
+        ginCon.Abort()
+        return
+    }
+
+    compressed := &errorKeepingReader{reader: ginCon.Request.Body}
+    gzipReader, gzipErr := gzip.NewReader(compressed)
+
+    if compressed.err != nil {
+        sendJsonHttpResponse(ginCon, http.StatusRequestEntityTooLarge, HttpError{CodePayloadTooLarge, "request body exceeds " + toString(int(limit)) + " bytes"})
+        ginCon.Abort()
+        return
+    }
+
+    if gzipErr != nil {
+        sendJsonHttpResponse(ginCon, http.StatusBadRequest, HttpError{CodeValidationFailed, "request body is not valid gzip"})
+        ginCon.Abort()
+        return
+    }
+
+    defer gzipReader.Close()
+
+    //one byte beyond the limit tells a body of exactly the limit from a larger one
+    body, readErr := ioutil.ReadAll(io.LimitReader(gzipReader, limit + 1))
+
+    if compressed.err != nil {
+        sendJsonHttpResponse(ginCon, http.StatusRequestEntityTooLarge, HttpError{CodePayloadTooLarge, "request body exceeds " + toString(int(limit)) + " bytes"})
+        ginCon.Abort()
+        return
+    }
+
+    if readErr != nil {
+        sendJsonHttpResponse(ginCon, http.StatusBadRequest, HttpError{CodeValidationFailed, "request body is not valid gzip"})
+        ginCon.Abort()
+        return
+    }
+
+    if int64(len(body)) > limit {
+        sendJsonHttpResponse(ginCon, http.StatusRequestEntityTooLarge, HttpError{CodePayloadTooLarge, "decompressed request body exceeds " + toString(int(limit)) + " bytes"})
+        ginCon.Abort()
+        return
+    }
+
+    ginCon.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+    ginCon.Request.ContentLength = int64(len(body))
+    ginCon.Request.Header.Del("Content-Encoding")
+    ginCon.Next()
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+    "bytes"
+    "compress/gzip"
+    "github.com/gin-gonic/gin"
+    "io/ioutil"
+    "math/rand"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+)
+
+func gzipped(t *testing.T, data []byte) []byte {
+    var compressed bytes.Buffer
+    gzipWriter := gzip.NewWriter(&compressed)
+
+    if _, writeErr := gzipWriter.Write(data); writeErr != nil {
+        t.Fatal(writeErr)
+    }
+
+    if closeErr := gzipWriter.Close(); closeErr != nil {
+        t.Fatal(closeErr)
+    }
+
+    return compressed.Bytes()
+}
+
+func TestDecompressRequest(t *testing.T) {
+    const limit = 1024
+    incompressible := make([]byte, 2 * limit)
+    rand.New(rand.NewSource(1)).Read(incompressible)
+
+    tests := []struct {
+        name     string
+        encoding string
+        body     []byte
+        code     int
+    }{
+        {"plain", "", bytes.Repeat([]byte("a"), limit), http.StatusOK},
+        {"within the limit", "gzip", gzipped(t, bytes.Repeat([]byte("a"), limit)), http.StatusOK},
+        {"expanding beyond the limit", "gzip", gzipped(t, bytes.Repeat([]byte("a"), limit + 1)), http.StatusRequestEntityTooLarge},
+        {"compressed beyond the limit", "gzip", gzipped(t, incompressible), http.StatusRequestEntityTooLarge},
+        {"malformed", "gzip", []byte("not gzip at all"), http.StatusBadRequest},
+        {"other encoding", "br", []byte("data"), http.StatusUnsupportedMediaType},
+    }
+
+    router := gin.New()
+    router.POST("/documents", limitBody(limit), decompressRequest(limit), func(ginCon *gin.Context) {
+        body, readErr := ioutil.ReadAll(ginCon.Request.Body)
+
+        if readErr != nil || len(body) != limit {
+            ginCon.Status(http.StatusInternalServerError)
+            return
+        }
+
+        ginCon.Status(http.StatusOK)
+    })
+
+    for _, test := range tests {
+        request := httptest.NewRequest(http.MethodPost, "/documents", bytes.NewReader(test.body))
+        request.ContentLength = -1 //sent chunked, so only reading tells the size
+        request.Header.Set("Content-Encoding", test.encoding)
+        recorder := httptest.NewRecorder()
+        router.ServeHTTP(recorder, request)
+
+        if recorder.Code != test.code {
+            t.Errorf("%s body is answered %d, expected %d: %s", test.name, recorder.Code, test.code, recorder.Body.String())
+        }
+    }
+}
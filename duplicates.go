@@ -0,0 +1,56 @@
+package main
+
+import (
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/mongo"
+    "log"
+    "time"
+)
+
+/* every document stores a hash of its title and content. When duplicate detection is
+enabled, creating a document identical to an existing one is refused, which keeps
+clients retrying a create from leaving copies behind. The check is made before the
+write, so creates racing each other can still both succeed. Documents written before
+the hash was introduced are not found until they are next updated or projections
+are rebuilt, see projections.go */
+var detectDuplicates bool = getEnvBool("PRECISELY_DETECT_DUPLICATES", false)
+
+func initDuplicates() {
+    ctx, cancel := context.WithTimeout(context.Background(), 10 * time.Second)
+    defer cancel()
+
+    _, indexErr := mongoCollection().Indexes().CreateOne(ctx, mongo.IndexModel{
+        Keys: bson.D{{Key: "duplicatehash", Value: 1}},
+    })
+
+    if indexErr != nil {
+        log.Print("Error creating index of duplicate hashes: ", indexErr)
+    }
+}
+
+//sha256 over the title and content of a document, which are equal for duplicates
+func duplicateHash(document Document) string {
+    serialDocument, _ := json.Marshal(Document{Title: document.Title, Content: document.Content}) //plain strings always serialize
+    hash := sha256.Sum256(serialDocument)
+    return hex.EncodeToString(hash[:])
+}
+
+//a document with the same title and content, read from primary so a create just made is seen
+func findDuplicate(ctx context.Context, document Document) (DocumentStatus, *Document) {
+    var duplicate Document
+    findErr := mongoCollection().FindOne(ctx, bson.D{{Key: "duplicatehash", Value: duplicateHash(document)}}).Decode(&duplicate)
+
+    if findErr != nil {
+        if findErr == mongo.ErrNoDocuments {
+            return NotFound, nil
+        }
+
+        return CouldNotProceed, nil
+    }
+
+    return OK, &duplicate
+}
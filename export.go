@@ -0,0 +1,129 @@
+package main
+
+import (
+    "archive/zip"
+    "compress/gzip"
+    "context"
+    "encoding/json"
+    "github.com/gin-gonic/gin"
+    "go.mongodb.org/mongo-driver/mongo"
+    "io"
+    "log"
+    "net/http"
+)
+
+/* the export writes every document as one json array, streamed straight from the
+database cursor through the optional compressor to the client. Memory use stays
+bounded however many documents there are. Once streaming has begun the status can
+no longer change, so a failure midway is logged and the response cut short, which
+clients notice as malformed json or a truncated archive */
+func handleExportDocuments(ginCon *gin.Context) {
+    params := struct {
+        ReadParams
+        Compress string `query:"compress" enum:"gzip,zip"`
+    }{ReadParams: ReadParams{defaultReadPreference}}
+
+    if paramsProblem := bindQuery(ginCon, &params); paramsProblem != "" {
+      sendJsonHttpResponse(ginCon, http.StatusBadRequest, HttpError{CodeValidationFailed, paramsProblem})
+      return
+    }
+
+    readPreference, compress := params.ReadPreference, params.Compress
+
+    metadata, metadataProblem := metadataFilterParams(ginCon.Request.URL.Query())
+
+    if metadataProblem != "" {
+      sendJsonHttpResponse(ginCon, http.StatusBadRequest, HttpError{CodeValidationFailed, metadataProblem})
+      return
+    }
+
+    status, cursor := openDocumentCursor(ginCon.Request.Context(), ListQuery{ReadPreference: readPreference, Metadata: metadata})
+
+    switch status {
+    case OK:
+    default:
+      sendStatus(ginCon, status, "the documents")
+      return
+    }
+
+    defer cursor.Close(context.TODO())
+
+    var output io.Writer = ginCon.Writer
+    var closeOutput func() error
+
+    switch compress {
+    case "gzip":
+      ginCon.Header("Content-Type", "application/gzip")
+      ginCon.Header("Content-Disposition", `attachment; filename="documents.json.gz"`)
+      gzipWriter := gzip.NewWriter(ginCon.Writer)
+      output, closeOutput = gzipWriter, gzipWriter.Close
+    case "zip":
+      ginCon.Header("Content-Type", "application/zip")
+      ginCon.Header("Content-Disposition", `attachment; filename="documents.zip"`)
+      ginCon.Status(http.StatusOK)
+      zipWriter := zip.NewWriter(ginCon.Writer)
+      entry, entryErr := zipWriter.Create("documents.json") //writes the entry header, so headers must be set before
+
+      if entryErr != nil {
+        log.Print("Export of documents aborted: ", entryErr)
+        return
+      }
+
+      output, closeOutput = entry, zipWriter.Close
+    default:
+      ginCon.Header("Content-Type", "application/json; charset=utf-8")
+    }
+
+    ginCon.Status(http.StatusOK)
+
+    if exportErr := writeDocumentArray(ginCon, cursor, output); exportErr != nil {
+        log.Print("Export of documents aborted: ", exportErr)
+        return
+    }
+
+    if closeOutput != nil {
+        if closeErr := closeOutput(); closeErr != nil {
+            log.Print("Export of documents aborted: ", closeErr)
+        }
+    }
+}
+
+//write the documents of the cursor as a json array, one document per line
+func writeDocumentArray(ginCon *gin.Context, cursor *mongo.Cursor, output io.Writer) error {
+    separator := "\n"
+
+    if _, writeErr := io.WriteString(output, "["); writeErr != nil {
+        return writeErr
+    }
+
+    for cursor.Next(ginCon.Request.Context()) {
+        var document Document
+
+        if decodeErr := decodeDocument(cursor.Current, &document); decodeErr != nil {
+            return decodeErr
+        }
+
+        serialDocument, serialErr := json.Marshal(inRequestNaming(ginCon, document))
+
+        if serialErr != nil {
+            return serialErr
+        }
+
+        if _, writeErr := io.WriteString(output, separator); writeErr != nil {
+            return writeErr
+        }
+
+        if _, writeErr := output.Write(serialDocument); writeErr != nil {
+            return writeErr
+        }
+
+        separator = ",\n"
+    }
+
+    if cursorErr := cursor.Err(); cursorErr != nil {
+        return cursorErr
+    }
+
+    _, writeErr := io.WriteString(output, "\n]\n")
+    return writeErr
+}
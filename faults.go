@@ -0,0 +1,106 @@
+package main
+
+import (
+    "encoding/json"
+    "github.com/gin-gonic/gin"
+    "io/ioutil"
+    "log"
+    "math/rand"
+    "time"
+)
+
+/* for resilience testing in staging only: requests can be delayed, have their connection
+dropped, or fail as if the database did not respond, at configurable rates per endpoint,
+so the retry logic of clients can be tried out. Faults are read at startup from the JSON
+file named by PRECISELY_FAULTS_FILE, holding a list like
+[{"endpoint": "GET /documents/:id", "latencyMs": 500, "latencyRate": 0.2, "dbErrorRate": 0.05}].
+An endpoint of * applies to every endpoint without faults of its own */
+var faultsFile string = getEnv("PRECISELY_FAULTS_FILE", "")
+
+type EndpointFaults struct {
+    Endpoint    string  `json:"endpoint"`              //method and route, e.g. "GET /documents/:id", or *
+    LatencyMs   int     `json:"latencyMs,omitempty"`   //delay added to delayed requests
+    LatencyRate float64 `json:"latencyRate,omitempty"` //share of requests delayed, from 0 to 1
+    DropRate    float64 `json:"dropRate,omitempty"`    //share of requests whose connection is closed without an answer
+    DbErrorRate float64 `json:"dbErrorRate,omitempty"` //share of requests answered as if the database failed
+}
+
+//faults by endpoint
+var endpointFaults map[string]EndpointFaults = loadFaults()
+
+//the faults of the configured file. Problems of malformed faults are reported, and none of the faults loaded
+func loadFaults() map[string]EndpointFaults {
+    if faultsFile == "" {
+        return nil
+    }
+
+    serialFaults, readErr := ioutil.ReadFile(faultsFile)
+
+    if readErr != nil {
+        settingProblem("PRECISELY_FAULTS_FILE names a file that can not be read: " + readErr.Error())
+        return nil
+    }
+
+    var faultList []EndpointFaults
+
+    if parseErr := json.Unmarshal(serialFaults, &faultList); parseErr != nil {
+        settingProblem("faults of " + faultsFile + " are not a list of endpoint faults: " + parseErr.Error())
+        return nil
+    }
+
+    faults := make(map[string]EndpointFaults, len(faultList))
+    malformed := false
+
+    for _, fault := range faultList {
+        for _, rate := range []float64{fault.LatencyRate, fault.DropRate, fault.DbErrorRate} {
+            if rate < 0 || rate > 1 {
+                settingProblem("faults of " + fault.Endpoint + " have a rate outside of 0 to 1")
+                malformed = true
+                break
+            }
+        }
+
+        faults[fault.Endpoint] = fault
+    }
+
+    if malformed {
+        return nil
+    }
+
+    log.Print("WARNING: injecting faults into ", len(faults), " endpoints as configured in ", faultsFile, ", do not use in production")
+    return faults
+}
+
+//middleware injecting the configured faults
+func injectFaults(ginCon *gin.Context) {
+    if len(endpointFaults) == 0 {
+        ginCon.Next()
+        return
+    }
+
+    faults, configured := endpointFaults[ginCon.Request.Method + " " + ginCon.FullPath()]
+
+    if !configured {
+        faults = endpointFaults["*"]
+    }
+
+    if faults.LatencyRate > 0 && rand.Float64() < faults.LatencyRate {
+        time.Sleep(time.Duration(faults.LatencyMs) * time.Millisecond)
+    }
+
+    if faults.DropRate > 0 && rand.Float64() < faults.DropRate {
+        if connection, _, hijackErr := ginCon.Writer.Hijack(); hijackErr == nil {
+            connection.Close()
+            ginCon.Abort()
+            return
+        }
+    }
+
+    if faults.DbErrorRate > 0 && rand.Float64() < faults.DbErrorRate {
+        sendDbUnavailable(ginCon)
+        ginCon.Abort()
+        return
+    }
+
+    ginCon.Next()
+}
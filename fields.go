@@ -0,0 +1,92 @@
+package main
+
+import (
+    "go.mongodb.org/mongo-driver/bson"
+    "reflect"
+    "strings"
+)
+
+/* list views rarely need the content of a document. With fields=id,title,signee, GET
+/documents and GET /documents/:id read and answer only the named fields. Names are
+those of the json fields, in any naming, e.g. legalHold or legal_hold, and select a field
+as a whole. Fields unknown to this version are only served without a selection */
+
+//fields clients can select, by their names in lower case without underscores, which are also the stored names
+var selectableFields = documentFieldIndexes()
+
+/* the index within Document of every field with a json name, so a field added to
+Document is selectable without listing it here. Fields kept from clients, like the
+duplicate hash, and those unknown to this version have none */
+func documentFieldIndexes() map[string]int {
+    documentType := reflect.TypeOf(Document{})
+    indexes := make(map[string]int, documentType.NumField())
+
+    for i := 0; i < documentType.NumField(); i++ {
+        name := strings.Split(documentType.Field(i).Tag.Get("json"), ",")[0]
+
+        if name != "" && name != "-" {
+            indexes[strings.ToLower(name)] = i
+        }
+    }
+
+    return indexes
+}
+
+//the stored names of a comma separated list of fields, nil if it is empty, or a problem
+func parseFieldSelection(param string) ([]string, string) {
+    if param == "" {
+        return nil, ""
+    }
+
+    var fields []string
+    selected := make(map[string]bool)
+
+    for _, name := range strings.Split(param, ",") {
+        field := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(name), "_", ""))
+
+        if _, selectable := selectableFields[field]; !selectable {
+            return nil, "unknown field '" + strings.TrimSpace(name) + "' in fields"
+        }
+
+        if !selected[field] {
+            selected[field] = true
+            fields = append(fields, field)
+        }
+    }
+
+    return fields, ""
+}
+
+//projection reading the selected fields and those needed besides, e.g. to sort
+func fieldProjection(fields []string, needed ...string) bson.D {
+    projection := bson.D{{Key: "_id", Value: 0}}
+    projected := make(map[string]bool)
+
+    for _, field := range append(fields, needed...) {
+        if !projected[field] {
+            projected[field] = true
+            projection = append(projection, bson.E{Key: field, Value: 1})
+        }
+    }
+
+    return projection
+}
+
+//a copy of a document holding only the selected fields, or all of them if none are selected
+func selectFields(document Document, fields []string) Document {
+    if len(fields) == 0 {
+        return document
+    }
+
+    var selected Document
+    source := reflect.ValueOf(document)
+    target := reflect.ValueOf(&selected).Elem()
+
+    for _, field := range fields {
+        if index, selectable := selectableFields[field]; selectable {
+            target.Field(index).Set(source.Field(index))
+        }
+    }
+
+    return selected
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+    "reflect"
+    "strings"
+    "testing"
+)
+
+//the selectable names are the stored ones, which the bson codec derives from the field names
+func TestSelectableFieldsAreStoredNames(t *testing.T) {
+    documentType := reflect.TypeOf(Document{})
+
+    for name, index := range selectableFields {
+        if stored := strings.ToLower(documentType.Field(index).Name); stored != name {
+            t.Errorf("field %s is selected as %s but stored as %s", documentType.Field(index).Name, name, stored)
+        }
+    }
+
+    for _, hidden := range []string{"duplicatehash", "extra"} {
+        if _, selectable := selectableFields[hidden]; selectable {
+            t.Errorf("%s must not be selectable", hidden)
+        }
+    }
+
+    if len(selectableFields) != 14 {
+        t.Errorf("%d selectable fields, expected the 14 json fields of Document", len(selectableFields))
+    }
+}
+
+func TestParseFieldSelection(t *testing.T) {
+    tests := []struct {
+        param   string
+        fields  []string
+        problem bool
+    }{
+        {"", nil, false},
+        {"id,title", []string{"id", "title"}, false},
+        {" legal_hold , legalHold,LegalHold", []string{"legalhold"}, false},
+        {"policy_warnings", []string{"policywarnings"}, false},
+        {"id,duplicateHash", nil, true},
+        {"id,", nil, true},
+    }
+
+    for _, test := range tests {
+        fields, problem := parseFieldSelection(test.param)
+
+        if !reflect.DeepEqual(fields, test.fields) || (problem != "") != test.problem {
+            t.Errorf("fields=%s selects %v with problem %q, expected %v", test.param, fields, problem, test.fields)
+        }
+    }
+}
+
+func TestSelectFields(t *testing.T) {
+    document := sampleDocument()
+    document.DuplicateHash = "0f3a"
+
+    if selected := selectFields(document, nil); !reflect.DeepEqual(selected, document) {
+        t.Errorf("no selection gives %+v, expected the whole document", selected)
+    }
+
+    selected := selectFields(document, []string{"id", "references"})
+    expected := Document{ID: document.ID, References: document.References}
+
+    if !reflect.DeepEqual(selected, expected) {
+        t.Errorf("selecting id and references gives %+v, expected %+v", selected, expected)
+    }
+}
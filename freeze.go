@@ -0,0 +1,146 @@
+package main
+
+import (
+    "encoding/json"
+    "github.com/gin-gonic/gin"
+    "go.mongodb.org/mongo-driver/bson"
+    "io/ioutil"
+    "log"
+    "net/http"
+    "time"
+)
+
+/* during a freeze window, e.g. the close of a fiscal year, the documents it covers can
+not be updated, replaced or deleted. Windows are read at startup from the JSON file
+named by PRECISELY_FREEZE_WINDOWS_FILE, holding a list like
+[{"name": "fiscal-close", "from": "2024-12-20T00:00:00Z", "to": "2025-01-10T00:00:00Z",
+"metadata": {"region": "EMEA"}}]. A window covers the documents holding all of its
+metadata values, or every document if it has none. Like a legal hold, a freeze is
+part of the filter of a write, so a write never slips past it */
+var freezeWindowsFile string = getEnv("PRECISELY_FREEZE_WINDOWS_FILE", "")
+
+type FreezeWindow struct {
+    Name     string            `json:"name"`
+    From     time.Time         `json:"from"`
+    To       time.Time         `json:"to"`
+    Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+type FrozenError struct {
+    HttpError
+    Window FreezeWindow `json:"window"`
+}
+
+var freezeWindows []FreezeWindow = loadFreezeWindows()
+
+//the windows of the configured file. Problems of malformed windows are reported, and none of the windows loaded
+func loadFreezeWindows() []FreezeWindow {
+    if freezeWindowsFile == "" {
+        return nil
+    }
+
+    serialWindows, readErr := ioutil.ReadFile(freezeWindowsFile)
+
+    if readErr != nil {
+        settingProblem("PRECISELY_FREEZE_WINDOWS_FILE names a file that can not be read: " + readErr.Error())
+        return nil
+    }
+
+    var windows []FreezeWindow
+
+    if parseErr := json.Unmarshal(serialWindows, &windows); parseErr != nil {
+        settingProblem("freeze windows of " + freezeWindowsFile + " are not a list of windows: " + parseErr.Error())
+        return nil
+    }
+
+    malformed := false
+
+    for i, window := range windows {
+        if window.Name == "" || !window.From.Before(window.To) {
+            settingProblem("freeze window " + toString(i) + " needs a name and to after from")
+            malformed = true
+        }
+
+        if problem := metadataProblem(window.Metadata); problem != "" {
+            settingProblem("freeze window " + window.Name + " has malformed metadata: " + problem)
+            malformed = true
+        }
+    }
+
+    if malformed {
+        return nil
+    }
+
+    log.Print("Loaded ", len(windows), " freeze windows from ", freezeWindowsFile)
+    return windows
+}
+
+func activeFreezeWindows(now time.Time) []FreezeWindow {
+    var active []FreezeWindow
+
+    for _, window := range freezeWindows {
+        if !now.Before(window.From) && now.Before(window.To) {
+            active = append(active, window)
+        }
+    }
+
+    return active
+}
+
+//filter excluding the documents frozen at the given time, empty while no window is active
+func notFrozenFilter(now time.Time) bson.D {
+    active := activeFreezeWindows(now)
+
+    if len(active) == 0 {
+        return bson.D{}
+    }
+
+    covered := make(bson.A, len(active))
+
+    for i, window := range active {
+        covered[i] = metadataFilter(window.Metadata)
+    }
+
+    return bson.D{{Key: "$nor", Value: covered}}
+}
+
+//the window freezing a document at the given time, or nil if it is not frozen
+func freezingWindow(document Document, now time.Time) *FreezeWindow {
+    for _, window := range activeFreezeWindows(now) {
+        covered := true
+
+        for key, value := range window.Metadata {
+            if document.Metadata[key] != value {
+                covered = false
+                break
+            }
+        }
+
+        if covered {
+            return &window
+        }
+    }
+
+    return nil
+}
+
+//answer a write refused because the document is frozen with 423 Locked and the window freezing it
+func sendDocumentFrozen(ginCon *gin.Context, id int) {
+    status, document := getDocumentIn(ginCon.Request.Context(), id, "primary")
+
+    if status != OK {
+        sendDocumentMissing(ginCon, id)
+        return
+    }
+
+    window := freezingWindow(*document, time.Now())
+
+    //the window closed in the meantime
+    if window == nil {
+        sendJsonHttpResponse(ginCon, http.StatusLocked, HttpError{CodeDocFrozen, "document " + toString(id) + " was frozen, try again"})
+        return
+    }
+
+    message := "document " + toString(id) + " is frozen by " + window.Name + " until " + window.To.UTC().Format(time.RFC3339)
+    sendJsonHttpResponse(ginCon, http.StatusLocked, FrozenError{HttpError{CodeDocFrozen, message}, *window})
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+    "context"
+    "github.com/gin-gonic/gin"
+    "go.mongodb.org/mongo-driver/mongo/readpref"
+    "log"
+    "math/rand"
+    "net/http"
+    "sync"
+    "time"
+)
+
+/* the driver recovers from most connection problems by itself, but a client can
+end up stuck failing every operation. The primary is pinged periodically, and after
+several failed pings in a row the client is replaced by a freshly connected one */
+var healthCheckSeconds *tunableInt = newTunableInt(getEnvInt("PRECISELY_HEALTH_CHECK_SECONDS", 10))
+const failedPingsBeforeReconnect = 3
+const maxReconnectBackoff = 30 * time.Second
+
+//in-flight requests on a replaced client get this long to finish before it is disconnected
+const replacedClientGracePeriod = 30 * time.Second
+
+type DatabaseHealth struct {
+    Status          string     `json:"status"` //"up" or "down"
+    Reconnects      int        `json:"reconnects"`
+    LastReconnectAt *time.Time `json:"lastReconnectAt,omitempty"`
+}
+
+type HealthReport struct {
+    Database DatabaseHealth `json:"database"`
+}
+
+var databaseHealth = DatabaseHealth{Status: "up"}
+var databaseHealthMutex sync.Mutex
+
+func setDatabaseStatus(status string) {
+    databaseHealthMutex.Lock()
+    defer databaseHealthMutex.Unlock()
+    databaseHealth.Status = status
+}
+
+func pingMongoDB() error {
+    ctx, cancel := context.WithTimeout(context.Background(), 5 * time.Second)
+    defer cancel()
+
+    return getMongoClient().Ping(ctx, readpref.Primary())
+}
+
+//connect a new client, retrying with jittered backoff until it succeeds, then swap it in
+func reconnectMongoDB() {
+    backoff := time.Second
+
+    for {
+        client, connectErr := connectMongoDB()
+
+        if connectErr == nil {
+            replacedClient := getMongoClient()
+            currentMongoClient.Store(client)
+
+            now := time.Now().UTC()
+            databaseHealthMutex.Lock()
+            databaseHealth.Status = "up"
+            databaseHealth.Reconnects++
+            databaseHealth.LastReconnectAt = &now
+            databaseHealthMutex.Unlock()
+
+            log.Print("Reconnected to MongoDB")
+
+            time.AfterFunc(replacedClientGracePeriod, func() {
+                ctx, cancel := context.WithTimeout(context.Background(), 10 * time.Second)
+                defer cancel()
+                replacedClient.Disconnect(ctx)
+            })
+
+            return
+        }
+
+        //jitter keeps many instances from reconnecting in lockstep
+        pause := backoff/2 + time.Duration(rand.Int63n(int64(backoff)))
+        log.Print("Error reconnecting to MongoDB, retrying in ", pause.Round(time.Millisecond), ": ", connectErr)
+        time.Sleep(pause)
+
+        if backoff *= 2; backoff > maxReconnectBackoff {
+            backoff = maxReconnectBackoff
+        }
+    }
+}
+
+//watch the connection, reconnecting when needed. Runs for the lifetime of the process
+func monitorMongoDB() {
+    failedPings := 0
+
+    for {
+        time.Sleep(time.Duration(healthCheckSeconds.Get()) * time.Second)
+
+        if pingErr := pingMongoDB(); pingErr != nil {
+            failedPings++
+            setDatabaseStatus("down")
+            log.Print("MongoDB ping failed (", failedPings, " in a row): ", pingErr)
+
+            if failedPings >= failedPingsBeforeReconnect {
+                reconnectMongoDB()
+                failedPings = 0
+            }
+
+            continue
+        }
+
+        if failedPings > 0 {
+            log.Print("MongoDB answers again")
+            failedPings = 0
+        }
+
+        setDatabaseStatus("up")
+    }
+}
+
+//health of the service, for load balancers and monitoring
+func handleGetHealth(ginCon *gin.Context) {
+    databaseHealthMutex.Lock()
+    health := databaseHealth
+    databaseHealthMutex.Unlock()
+
+    if health.Status != "up" {
+        sendJsonHttpResponse(ginCon, http.StatusServiceUnavailable, HealthReport{health})
+        return
+    }
+
+    sendJsonHttpResponse(ginCon, http.StatusOK, HealthReport{health})
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "github.com/gin-gonic/gin"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+    "log"
+    "net/http"
+    "reflect"
+    "strconv"
+    "time"
+)
+
+/* every document write is recorded as an event in the history collection. Each
+event includes the hash of the previous one, forming a hash chain over the whole
+collection: modifying, removing or reordering events out-of-band breaks the chain,
+and a document modified out-of-band no longer matches its last recorded state */
+var historyCollectionName string = "precisely-history"
+
+func historyCollection() *mongo.Collection {
+    return getMongoClient().Database(databaseName).Collection(historyCollectionName)
+}
+
+//operations recorded in the history
+const (
+    CreateOperation    = "create"
+    UpdateOperation    = "update"
+    DeleteOperation    = "delete"
+    LegalHoldOperation = "legalHold"
+    LinkCheckOperation = "linkCheck" //a link of the document broke or recovered
+    RebuildOperation   = "rebuild"   //derived fields were recomputed, see projections.go
+)
+
+type HistoryEvent struct {
+    Seq        int64     `json:"seq"` //position in the chain, starting at 1
+    DocumentID int       `json:"documentId"`
+    Operation  string    `json:"operation"`
+    At         time.Time `json:"at"`
+    Document   *Document `json:"document,omitempty"` //state after the write, nil for deletes
+    PrevHash   string    `json:"prevHash"`
+    Hash       string    `json:"hash"`
+}
+
+//a break in the chain, or a document not matching its history
+type IntegrityProblem struct {
+    Seq        *int64 `json:"seq,omitempty"`
+    DocumentID *int   `json:"documentId,omitempty"`
+    Problem    string `json:"problem"`
+}
+
+type IntegrityReport struct {
+    Valid     bool               `json:"valid"`
+    Events    int64              `json:"events"`
+    Documents int64              `json:"documents"`
+    Problems  []IntegrityProblem `json:"problems"`
+}
+
+//retries when another writer appended the same sequence number concurrently
+const maxAppendAttempts = 10
+
+func initHistory() {
+    ctx, cancel := context.WithTimeout(context.Background(), 10 * time.Second)
+    defer cancel()
+
+    //the unique sequence number is what keeps the chain linear between concurrent writers
+    _, indexErr := historyCollection().Indexes().CreateMany(ctx, []mongo.IndexModel{
+        {Keys: bson.D{{Key: "seq", Value: 1}}, Options: options.Index().SetUnique(true)},
+        {Keys: bson.D{{Key: "documentid", Value: 1}, {Key: "seq", Value: 1}}},
+    })
+
+    if indexErr != nil {
+        log.Print("Error creating indexes of history collection: ", indexErr)
+    }
+}
+
+//hash of an event, covering every field but the hash itself, chained to the previous hash
+func hashEvent(event HistoryEvent) (string, error) {
+    event.Hash = ""
+    serialEvent, serialErr := json.Marshal(event)
+
+    if serialErr != nil {
+        return "", serialErr
+    }
+
+    hash := sha256.Sum256(append([]byte(event.PrevHash + "\n"), serialEvent...))
+    return hex.EncodeToString(hash[:]), nil
+}
+
+func getLastHistoryEvent(ctx context.Context) (*HistoryEvent, error) {
+    var last HistoryEvent
+
+    findErr := historyCollection().FindOne(
+        ctx,
+        bson.D{},
+        options.FindOne().SetSort(bson.D{{Key: "seq", Value: -1}}),
+    ).Decode(&last)
+
+    if findErr == mongo.ErrNoDocuments {
+        return nil, nil
+    }
+
+    if findErr != nil {
+        return nil, findErr
+    }
+
+    return &last, nil
+}
+
+/* append a write to the history. The document write has already happened, so a
+failure here is logged rather than failing the request; the missing event is
+then reported by the integrity check */
+func recordHistory(operation string, documentID int, document *Document) {
+    ctx := context.TODO()
+
+    for attempt := 0; attempt < maxAppendAttempts; attempt++ {
+        last, lastErr := getLastHistoryEvent(ctx)
+
+        if lastErr != nil {
+            log.Print("Error recording history of document ", documentID, ": ", lastErr)
+            return
+        }
+
+        //bson keeps milliseconds, truncate so the hash is reproducible after a round trip
+        event := HistoryEvent{Seq: 1, DocumentID: documentID, Operation: operation,
+                              At: time.Now().UTC().Truncate(time.Millisecond), Document: document}
+
+        if last != nil {
+            event.Seq = last.Seq + 1
+            event.PrevHash = last.Hash
+        }
+
+        hash, hashErr := hashEvent(event)
+
+        if hashErr != nil {
+            log.Print("Error hashing history of document ", documentID, ": ", hashErr)
+            return
+        }
+
+        event.Hash = hash
+        _, insertErr := historyCollection().InsertOne(ctx, event)
+
+        if insertErr == nil {
+            return
+        }
+
+        if !mongo.IsDuplicateKeyError(insertErr) {
+            log.Print("Error recording history of document ", documentID, ": ", insertErr)
+            return
+        }
+    }
+
+    log.Print("Error recording history of document ", documentID, ": too many concurrent writers")
+}
+
+/* walk the whole chain, checking every hash and link, then compare every stored
+document with its last recorded state */
+func verifyIntegrity() (DocumentStatus, *IntegrityReport) {
+    ctx := context.TODO()
+    report := IntegrityReport{Problems: []IntegrityProblem{}}
+    problem := func(seq *int64, documentID *int, description string) {
+        report.Problems = append(report.Problems, IntegrityProblem{seq, documentID, description})
+    }
+
+    cursor, findErr := historyCollection().Find(ctx, bson.D{}, options.Find().SetSort(bson.D{{Key: "seq", Value: 1}}))
+
+    if findErr != nil {
+        return CouldNotProceed, nil
+    }
+
+    defer cursor.Close(ctx)
+
+    //last recorded state per document, nil once deleted
+    lastStates := make(map[int]*Document)
+    previousHash := ""
+    var expectedSeq int64 = 1
+
+    for cursor.Next(ctx) {
+        var event HistoryEvent
+
+        if decodeErr := cursor.Decode(&event); decodeErr != nil {
+            problem(nil, nil, "undecodable event after seq " + strconv.FormatInt(expectedSeq-1, 10))
+            continue
+        }
+
+        seq := event.Seq
+        report.Events++
+
+        if event.Seq != expectedSeq {
+            problem(&seq, nil, "expected seq " + strconv.FormatInt(expectedSeq, 10) + "; events are missing or reordered")
+        }
+
+        if event.PrevHash != previousHash {
+            problem(&seq, nil, "prevHash does not match the hash of the previous event")
+        }
+
+        if hash, hashErr := hashEvent(event); hashErr != nil || hash != event.Hash {
+            problem(&seq, nil, "hash does not match the content of the event")
+        }
+
+        lastStates[event.DocumentID] = event.Document
+        previousHash = event.Hash
+        expectedSeq = event.Seq + 1
+    }
+
+    if cursor.Err() != nil {
+        return CouldNotProceed, nil
+    }
+
+    documentCursor, documentErr := mongoCollection().Find(ctx, bson.D{})
+
+    if documentErr != nil {
+        return CouldNotProceed, nil
+    }
+
+    defer documentCursor.Close(ctx)
+    seen := make(map[int]bool)
+
+    for documentCursor.Next(ctx) {
+        var document Document
+
+        if decodeErr := documentCursor.Decode(&document); decodeErr != nil || document.ID == nil {
+            problem(nil, nil, "stored document without a readable id")
+            continue
+        }
+
+        id := *document.ID
+        seen[id] = true
+        report.Documents++
+
+        lastState, recorded := lastStates[id]
+
+        if !recorded || lastState == nil {
+            problem(nil, &id, "document has no recorded history")
+        } else if !matchesRecordedState(*lastState, document) {
+            problem(nil, &id, "document differs from its last recorded state")
+        }
+    }
+
+    if documentCursor.Err() != nil {
+        return CouldNotProceed, nil
+    }
+
+    for id, lastState := range lastStates {
+        if lastState != nil && !seen[id] {
+            documentID := id
+            problem(nil, &documentID, "document was removed without a recorded delete")
+        }
+    }
+
+    report.Valid = len(report.Problems) == 0
+    return OK, &report
+}
+
+/* whether a stored document is in the state its history recorded. Stored documents
+hold fields MongoDB manages, like _id, which the copies in the history lack, and the
+times of link checks confirming a status, which are not recorded, see links.go */
+func matchesRecordedState(recorded Document, stored Document) bool {
+    recorded.Extra = withoutInternalFields(recorded.Extra)
+    stored.Extra = withoutInternalFields(stored.Extra)
+    recorded.Links = withoutCheckTimes(recorded.Links)
+    stored.Links = withoutCheckTimes(stored.Links)
+    return reflect.DeepEqual(recorded, stored)
+}
+
+/* state of a document at the given instant, taken from the last event recorded
+at or before it. NotFound if the document did not exist yet or was deleted */
+func getDocumentAsOf(ctx context.Context, id int, asOf time.Time, readPreference string) (DocumentStatus, *Document) {
+    collection, collErr := readCollection(readPreference, documentReadConcern)
+
+    if collErr != nil {
+        return ImplementationError, nil
+    }
+
+    history := collection.Database().Collection(historyCollectionName)
+    var event HistoryEvent
+
+    findErr := history.FindOne(
+        ctx,
+        bson.D{{Key: "documentid", Value: id}, {Key: "at", Value: bson.D{{Key: "$lte", Value: asOf}}}},
+        options.FindOne().SetSort(bson.D{{Key: "seq", Value: -1}}),
+    ).Decode(&event)
+
+    if findErr != nil {
+        if findErr == mongo.ErrNoDocuments {
+            return NotFound, nil
+        }
+
+        return CouldNotProceed, nil
+    }
+
+    if event.Document == nil {
+        return NotFound, nil
+    }
+
+    return OK, event.Document
+}
+
+func handleGetIntegrity(ginCon *gin.Context) {
+    status, report := verifyIntegrity()
+
+    switch status {
+    case OK:
+      sendJsonHttpResponse(ginCon, http.StatusOK, report)
+    default:
+      sendStatus(ginCon, status, "the history")
+    }
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "testing"
+    "time"
+)
+
+//a value as MongoDB hands it back: encoded, and decoded with the registry of the client
+func roundTripBson(t *testing.T, value interface{}, decoded interface{}) {
+    serial, marshalErr := bson.Marshal(value)
+
+    if marshalErr != nil {
+        t.Fatal(marshalErr)
+    }
+
+    if unmarshalErr := bson.UnmarshalWithRegistry(documentRegistry, serial, decoded); unmarshalErr != nil {
+        t.Fatal(unmarshalErr)
+    }
+}
+
+//a document as stored in the collection, where MongoDB adds an _id
+func storedDocument(t *testing.T, document Document) Document {
+    var fields bson.D
+    roundTripBson(t, document, &fields)
+
+    var stored Document
+    roundTripBson(t, append(bson.D{{Key: "_id", Value: primitive.NewObjectID()}}, fields...), &stored)
+    return stored
+}
+
+//the copy of a document recorded in its history
+func recordedDocument(t *testing.T, document Document) Document {
+    var event HistoryEvent
+    roundTripBson(t, HistoryEvent{Seq: 1, DocumentID: *document.ID, Operation: CreateOperation, Document: &document}, &event)
+    return *event.Document
+}
+
+func TestMatchesRecordedState(t *testing.T) {
+    created := sampleDocument()
+    created.Revision = intOf(1)
+    stored := storedDocument(t, created)
+
+    if _, hasID := stored.Extra["_id"]; !hasID {
+        t.Fatal("expected the stored document to keep _id among its unknown fields")
+    }
+
+    if !matchesRecordedState(recordedDocument(t, created), stored) {
+        t.Error("created document differs from its recorded state")
+    }
+
+    //unknown fields written by a newer version are part of the state
+    withUnknown := created
+    withUnknown.Extra = bson.M{"retention": "10y"}
+
+    if !matchesRecordedState(recordedDocument(t, withUnknown), storedDocument(t, withUnknown)) {
+        t.Error("document with unknown fields differs from its recorded state")
+    }
+
+    if matchesRecordedState(recordedDocument(t, created), storedDocument(t, withUnknown)) {
+        t.Error("document with an unknown field added out-of-band matches its recorded state")
+    }
+
+    modified := created
+    modified.Signee = stringOf("Mallory")
+
+    if matchesRecordedState(recordedDocument(t, created), storedDocument(t, modified)) {
+        t.Error("document modified out-of-band matches its recorded state")
+    }
+}
+
+func TestHashEvent(t *testing.T) {
+    document := sampleDocument()
+    first := HistoryEvent{Seq: 1, DocumentID: 7, Operation: CreateOperation, At: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC), Document: &document}
+    firstHash, hashErr := hashEvent(first)
+
+    if hashErr != nil {
+        t.Fatal(hashErr)
+    }
+
+    first.Hash = firstHash
+
+    //the hash covers every field but itself
+    if rehash, _ := hashEvent(first); rehash != firstHash {
+        t.Error("hash depends on the hash field")
+    }
+
+    var decoded HistoryEvent
+    roundTripBson(t, first, &decoded)
+
+    if rehash, _ := hashEvent(decoded); rehash != firstHash {
+        t.Error("hash changes when the event is stored and read back")
+    }
+
+    second := HistoryEvent{Seq: 2, DocumentID: 7, Operation: DeleteOperation, At: first.At.Add(time.Minute), PrevHash: firstHash}
+    secondHash, _ := hashEvent(second)
+    second.PrevHash = "0" + firstHash[1:]
+
+    if rehash, _ := hashEvent(second); rehash == secondHash {
+        t.Error("hash does not depend on the previous hash")
+    }
+
+    tampered := first
+    tampered.Document = &Document{ID: intOf(7), Title: stringOf("Forged")}
+
+    if rehash, _ := hashEvent(tampered); rehash == firstHash {
+        t.Error("hash does not depend on the recorded document")
+    }
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+    "context"
+    "github.com/gin-gonic/gin"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+    "log"
+    "net/http"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+)
+
+/* every list and export query is recorded by its shape, the fields it filters on
+by equality followed by the field it sorts on, which is also the key of the index
+best serving it. Shapes are kept in memory per instance, so each instance suggests
+indexes for the queries it served itself */
+const maxQueryShapes = 1000 //metadata keys are chosen by clients, so the number of shapes must be capped
+
+//in safe mode, indexes are only created for shapes queried at least this often
+var indexSuggestionMinQueries int = getEnvInt("PRECISELY_INDEX_SUGGESTION_MIN_QUERIES", 100)
+
+//create suggested indexes automatically, up to maxAutoIndexes of them
+var autoCreateIndexes bool = getEnvBool("PRECISELY_AUTO_CREATE_INDEXES", false)
+var maxAutoIndexes int = getEnvInt("PRECISELY_MAX_AUTO_INDEXES", 5)
+
+//automatically created indexes are named with this prefix, so they are easily told apart
+const autoIndexPrefix = "auto_"
+
+type queryShape struct {
+    Keys     []string
+    Queries  int64
+    LastSeen time.Time
+}
+
+var queryShapes = make(map[string]*queryShape)
+var queryShapesMutex sync.Mutex
+
+type IndexSuggestion struct {
+    Keys     []string  `json:"keys"`     //index key, all ascending
+    Queries  int64     `json:"queries"`  //queries of this shape since the instance started
+    LastSeen time.Time `json:"lastSeen"`
+    Indexed  bool      `json:"indexed"`  //whether an existing index already serves the queries
+}
+
+//equality fields in a stable order, then the sort field
+func shapeKeys(query ListQuery) []string {
+    var keys []string
+
+    for key := range query.Metadata {
+        keys = append(keys, "metadata." + key)
+    }
+
+    for _, filterField := range append(classificationFilter(query.Classification, query.Pii), signeeFilter(query.Signee)...) {
+        keys = append(keys, filterField.Key)
+    }
+
+    sort.Strings(keys)
+
+    for _, sortField := range listSort(query) {
+        keys = append(keys, sortField.Key)
+    }
+
+    return keys
+}
+
+func recordQueryShape(query ListQuery) {
+    keys := shapeKeys(query)
+    name := strings.Join(keys, ",")
+
+    queryShapesMutex.Lock()
+    defer queryShapesMutex.Unlock()
+
+    shape, exists := queryShapes[name]
+
+    if !exists {
+        if len(queryShapes) >= maxQueryShapes {
+            return
+        }
+
+        shape = &queryShape{Keys: keys}
+        queryShapes[name] = shape
+    }
+
+    shape.Queries++
+    shape.LastSeen = time.Now().UTC()
+}
+
+/* whether an index with the given key serves a query shape: it must begin with the
+equality fields of the shape, in any order, followed by the sort field */
+func servesShape(indexKeys []string, shapeKeys []string) bool {
+    if len(indexKeys) < len(shapeKeys) {
+        return false
+    }
+
+    equalityCount := len(shapeKeys) - 1
+    equalityFields := make(map[string]bool)
+
+    for _, key := range shapeKeys[:equalityCount] {
+        equalityFields[key] = true
+    }
+
+    for _, key := range indexKeys[:equalityCount] {
+        if !equalityFields[key] {
+            return false
+        }
+    }
+
+    return indexKeys[equalityCount] == shapeKeys[equalityCount]
+}
+
+//key fields of every index of the document collection, by index name
+func getIndexes() (DocumentStatus, map[string][]string) {
+    cursor, listErr := mongoCollection().Indexes().List(context.TODO())
+
+    if listErr != nil {
+        return CouldNotProceed, nil
+    }
+
+    var indexes []struct {
+        Name string `bson:"name"`
+        Key  bson.D `bson:"key"`
+    }
+
+    if allErr := cursor.All(context.TODO(), &indexes); allErr != nil {
+        return CouldNotProceed, nil
+    }
+
+    indexKeys := make(map[string][]string, len(indexes))
+
+    for _, index := range indexes {
+        for _, key := range index.Key {
+            indexKeys[index.Name] = append(indexKeys[index.Name], key.Key)
+        }
+    }
+
+    return OK, indexKeys
+}
+
+//every recorded query shape, most queried first, telling whether it is served by one of the indexes
+func suggestIndexes(indexes map[string][]string) []IndexSuggestion {
+    queryShapesMutex.Lock()
+    suggestions := make([]IndexSuggestion, 0, len(queryShapes))
+
+    for _, shape := range queryShapes {
+        suggestions = append(suggestions, IndexSuggestion{Keys: shape.Keys, Queries: shape.Queries, LastSeen: shape.LastSeen})
+    }
+
+    queryShapesMutex.Unlock()
+
+    for i := range suggestions {
+        for _, indexKeys := range indexes {
+            if servesShape(indexKeys, suggestions[i].Keys) {
+                suggestions[i].Indexed = true
+                break
+            }
+        }
+    }
+
+    sort.Slice(suggestions, func(i, j int) bool {
+        return suggestions[i].Queries > suggestions[j].Queries
+    })
+
+    return suggestions
+}
+
+/* create indexes for the most queried unindexed shapes, leaving alone shapes queried
+too rarely to be worth the cost an index adds to every write. Instances doing this at
+the same time may each create one index beyond the limit */
+func createSuggestedIndexes() {
+    status, indexes := getIndexes()
+
+    if status != OK {
+        return
+    }
+
+    autoIndexes := 0
+
+    for name := range indexes {
+        if strings.HasPrefix(name, autoIndexPrefix) {
+            autoIndexes++
+        }
+    }
+
+    for _, suggestion := range suggestIndexes(indexes) {
+        if suggestion.Indexed || suggestion.Queries < int64(indexSuggestionMinQueries) || autoIndexes >= maxAutoIndexes {
+            continue
+        }
+
+        keys := bson.D{}
+
+        for _, key := range suggestion.Keys {
+            keys = append(keys, bson.E{Key: key, Value: 1})
+        }
+
+        name := autoIndexPrefix + strings.Join(suggestion.Keys, "_")
+        _, indexErr := mongoCollection().Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+            Keys:    keys,
+            Options: options.Index().SetName(name),
+        })
+
+        if indexErr != nil {
+            log.Print("Error creating suggested index ", name, ": ", indexErr)
+            continue
+        }
+
+        log.Print("Created suggested index ", name, " for ", suggestion.Queries, " queries")
+        autoIndexes++
+    }
+}
+
+//create suggested indexes hourly if enabled. Runs for the lifetime of the process
+func runIndexCreator() {
+    if !autoCreateIndexes {
+        return
+    }
+
+    for {
+        time.Sleep(time.Hour)
+        createSuggestedIndexes()
+    }
+}
+
+func handleGetIndexSuggestions(ginCon *gin.Context) {
+    status, indexes := getIndexes()
+
+    switch status {
+    case OK:
+      sendJsonHttpResponse(ginCon, http.StatusOK, suggestIndexes(indexes))
+    default:
+      sendStatus(ginCon, status, "the indexes")
+    }
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+    "log"
+    "net"
+    "net/http"
+    "net/url"
+    "strings"
+    "syscall"
+    "time"
+)
+
+/* documents can link to external pages, e.g. terms referenced by a contract. A
+background checker requests every link periodically and flags the ones that no
+longer resolve. When a link breaks or recovers, the new state is written like any
+other document write, so it is recorded in the history and notified */
+type DocumentLink struct {
+    URL       string     `json:"url"`
+    Status    string     `json:"status,omitempty"`    //LinkOK or LinkBroken, set by the link checker only
+    CheckedAt *time.Time `json:"checkedAt,omitempty"` //last check by the link checker
+}
+
+//link statuses
+const (
+    LinkOK     = "ok"
+    LinkBroken = "broken"
+)
+
+const maxLinks = 20
+const maxLinkLength = 2000
+
+//how long a check result is trusted before the link is checked again
+var linkCheckInterval time.Duration = time.Duration(getEnvInt("PRECISELY_LINK_CHECK_MINUTES", 60)) * time.Minute
+
+//documents checked per round of the link checker
+const linkCheckBatchSize = 100
+
+/* links are given by clients, so the checker must not become a way to probe the
+network the service runs in. It connects only to public addresses: every address is
+checked when dialing, after the host is resolved, so redirects and hosts resolving to
+an internal address are refused as well. Proxies of the environment are not used, as
+they would be dialed instead of the link's host */
+var linkCheckClient = &http.Client{
+    Timeout:       10 * time.Second,
+    CheckRedirect: checkLinkRedirect,
+    Transport: &http.Transport{
+        DialContext:         (&net.Dialer{Timeout: 5 * time.Second, Control: checkDialedAddress}).DialContext,
+        TLSHandshakeTimeout: 5 * time.Second,
+    },
+}
+
+var errInternalAddress = errors.New("link leads to an internal address")
+
+//carrier-grade nat, shared by the hosts of some cloud networks
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+//whether the link checker may connect to ip: not loopback, private, link-local like cloud metadata endpoints, or multicast
+func isPublicAddress(ip net.IP) bool {
+    return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+             ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() || sharedAddressSpace.Contains(ip))
+}
+
+//whether host may be public. Names are only known to be once resolved, see checkDialedAddress
+func isPublicHost(host string) bool {
+    host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+    if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+        return false
+    }
+
+    if ip := net.ParseIP(host); ip != nil {
+        return isPublicAddress(ip)
+    }
+
+    return true
+}
+
+//refuses connections of the link checker to internal addresses, see linkCheckClient
+func checkDialedAddress(network string, address string, _ syscall.RawConn) error {
+    host, _, splitErr := net.SplitHostPort(address)
+
+    if splitErr != nil {
+        return splitErr
+    }
+
+    if ip := net.ParseIP(host); ip == nil || !isPublicAddress(ip) {
+        return errInternalAddress
+    }
+
+    return nil
+}
+
+//follow redirects to public http and https urls only, as net/http does at most 10 times
+func checkLinkRedirect(request *http.Request, via []*http.Request) error {
+    if len(via) >= 10 {
+        return errors.New("stopped after 10 redirects")
+    }
+
+    if (request.URL.Scheme != "http" && request.URL.Scheme != "https") || !isPublicHost(request.URL.Hostname()) {
+        return errInternalAddress
+    }
+
+    return nil
+}
+
+//describe the first malformed link, or return an empty string if all are fine
+func linksProblem(links []DocumentLink) string {
+    for _, link := range links {
+        parsedURL, parseErr := url.Parse(link.URL)
+
+        if parseErr != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") || parsedURL.Host == "" {
+            return "link '" + link.URL + "' is not an absolute http or https url"
+        }
+
+        if !isPublicHost(parsedURL.Hostname()) {
+            return "link '" + link.URL + "' leads to an internal address"
+        }
+    }
+
+    return ""
+}
+
+//like exceededSizeLimit, for the links of a document
+func exceededLinksLimit(links []DocumentLink) string {
+    if len(links) > maxLinks {
+        return "links exceed the maximum of " + toString(maxLinks) + " per document"
+    }
+
+    for _, link := range links {
+        if len(link.URL) > maxLinkLength {
+            return "link urls may not exceed " + toString(maxLinkLength) + " characters"
+        }
+    }
+
+    return ""
+}
+
+//a copy of links without the times they were checked, leaving links as they are
+func withoutCheckTimes(links []DocumentLink) []DocumentLink {
+    if links == nil {
+        return nil
+    }
+
+    copied := make([]DocumentLink, len(links))
+
+    for i, link := range links {
+        copied[i] = DocumentLink{URL: link.URL, Status: link.Status}
+    }
+
+    return copied
+}
+
+//links as given by a client, who can not set what only the checker knows
+func withoutCheckResults(links []DocumentLink) []DocumentLink {
+    for i := range links {
+        links[i].Status = ""
+        links[i].CheckedAt = nil
+    }
+
+    return links
+}
+
+//request the url, ok if it answers without an error status. Internal addresses count as broken
+func checkLink(linkURL string) string {
+    response, headErr := linkCheckClient.Head(linkURL)
+
+    //some servers do not implement HEAD
+    if headErr == nil && (response.StatusCode == http.StatusMethodNotAllowed || response.StatusCode == http.StatusNotImplemented) {
+        response.Body.Close()
+        response, headErr = linkCheckClient.Get(linkURL)
+    }
+
+    if headErr != nil {
+        return LinkBroken
+    }
+
+    response.Body.Close()
+
+    if response.StatusCode >= 400 {
+        return LinkBroken
+    }
+
+    return LinkOK
+}
+
+/* store the result of checking a link of a document. Only a changed status is a
+document write with history and notification; a confirmed status just moves the
+time of the check */
+func recordLinkCheck(id int, link DocumentLink, status string, checkedAt time.Time) {
+    filter := bson.D{{Key: "id", Value: id}}
+    update := bson.D{{Key: "$set", Value: bson.D{
+        {Key: "links.$[link].status", Value: status},
+        {Key: "links.$[link].checkedat", Value: checkedAt},
+    }}}
+    arrayFilters := options.ArrayFilters{Filters: []interface{}{bson.D{{Key: "link.url", Value: link.URL}}}}
+
+    if status == link.Status {
+        _, updateErr := mongoCollection().UpdateOne(context.TODO(), filter, update, options.Update().SetArrayFilters(arrayFilters))
+
+        if updateErr != nil {
+            log.Print("Error recording check of link ", link.URL, " of document ", id, ": ", updateErr)
+        }
+
+        return
+    }
+
+    opts := options.FindOneAndUpdate().
+        SetArrayFilters(arrayFilters).
+        SetReturnDocument(options.After)
+
+    writeStatus, _ := writeDocument(LinkCheckOperation, &writeRequest{}, func(ctx mongo.SessionContext) (DocumentStatus, int, *Document) {
+        var document Document
+        updateErr := mongoCollection().FindOneAndUpdate(ctx, filter, update, opts).Decode(&document)
+
+        if updateErr != nil {
+            if updateErr == mongo.ErrNoDocuments {
+                return NotFound, id, nil
+            }
+
+            return CouldNotProceed, id, nil
+        }
+
+        return OK, id, &document
+    })
+
+    if writeStatus != OK && writeStatus != NotFound { //deleted since, nothing to flag
+        log.Print("Error recording check of link ", link.URL, " of document ", id, ": status ", writeStatus)
+    }
+}
+
+//check the links of documents not checked within linkCheckInterval, as long as this instance remains the checker
+func checkLinks(renewLease func() bool) {
+    now := time.Now().UTC()
+    filter := bson.D{{Key: "links", Value: bson.D{{Key: "$elemMatch", Value: bson.D{{Key: "$or", Value: bson.A{
+        bson.D{{Key: "checkedat", Value: nil}},
+        bson.D{{Key: "checkedat", Value: bson.D{{Key: "$lt", Value: now.Add(-linkCheckInterval)}}}},
+    }}}}}}}
+    opts := options.Find().SetLimit(linkCheckBatchSize)
+
+    cursor, findErr := mongoCollection().Find(context.TODO(), filter, opts)
+
+    if findErr != nil {
+        log.Print("Error reading documents with links to check: ", findErr)
+        return
+    }
+
+    var documents []Document
+
+    if allErr := cursor.All(context.TODO(), &documents); allErr != nil {
+        log.Print("Error reading documents with links to check: ", allErr)
+        return
+    }
+
+    for _, document := range documents {
+        for _, link := range document.Links {
+            if link.CheckedAt != nil && link.CheckedAt.After(now.Add(-linkCheckInterval)) {
+                continue
+            }
+
+            if !renewLease() {
+                return
+            }
+
+            recordLinkCheck(*document.ID, link, checkLink(link.URL), time.Now().UTC())
+        }
+    }
+}
+
+//check links periodically, on one instance at a time. Runs for the lifetime of the process
+func runLinkChecker() {
+    runWithLease("link-checker", time.Minute, checkLinks)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+    "net"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "time"
+)
+
+func TestIsPublicHost(t *testing.T) {
+    tests := []struct {
+        host   string
+        public bool
+    }{
+        {"example.com", true},
+        {"93.184.216.34", true},
+        {"2606:2800:220:1:248:1893:25c8:1946", true},
+        {"localhost", false},
+        {"api.LOCALHOST.", false},
+        {"127.0.0.1", false},
+        {"10.1.2.3", false},
+        {"172.16.0.1", false},
+        {"192.168.1.1", false},
+        {"100.64.0.1", false},
+        {"169.254.169.254", false}, //cloud metadata
+        {"0.0.0.0", false},
+        {"224.0.0.1", false},
+        {"::1", false},
+        {"fd00:ec2::254", false},
+        {"fe80::1", false},
+        {"::ffff:127.0.0.1", false},
+    }
+
+    for _, test := range tests {
+        if public := isPublicHost(test.host); public != test.public {
+            t.Errorf("%s is public: %v, expected %v", test.host, public, test.public)
+        }
+    }
+}
+
+func TestLinksProblemRefusesInternalAddresses(t *testing.T) {
+    for _, linkURL := range []string{"http://127.0.0.1:8080/admin", "http://[::1]/", "http://169.254.169.254/latest/meta-data/", "https://localhost/"} {
+        if linksProblem([]DocumentLink{{URL: linkURL}}) == "" {
+            t.Errorf("link %s is accepted", linkURL)
+        }
+    }
+
+    if problem := linksProblem([]DocumentLink{{URL: "https://example.com/terms"}}); problem != "" {
+        t.Errorf("public link is refused: %s", problem)
+    }
+}
+
+//a server on loopback stands in for any internal host, however the checker is led there
+func TestCheckLinkRefusesInternalAddresses(t *testing.T) {
+    requested := false
+    server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+        requested = true
+    }))
+    defer server.Close()
+
+    if status := checkLink(server.URL); status != LinkBroken {
+        t.Errorf("internal link is %s, expected %s", status, LinkBroken)
+    }
+
+    if requested {
+        t.Error("the link checker requested an internal address")
+    }
+
+    if dialErr := checkDialedAddress("tcp", server.Listener.Addr().String(), nil); dialErr != errInternalAddress {
+        t.Errorf("dialing %s gives %v, expected %v", server.Listener.Addr(), dialErr, errInternalAddress)
+    }
+
+    if dialErr := checkDialedAddress("tcp", net.JoinHostPort("93.184.216.34", "443"), nil); dialErr != nil {
+        t.Errorf("dialing a public address gives %v", dialErr)
+    }
+}
+
+func TestCheckLinkRedirect(t *testing.T) {
+    redirect := func(target string) error {
+        request := httptest.NewRequest(http.MethodHead, target, nil)
+        return checkLinkRedirect(request, []*http.Request{httptest.NewRequest(http.MethodHead, "https://example.com/", nil)})
+    }
+
+    if redirectErr := redirect("https://example.org/terms"); redirectErr != nil {
+        t.Errorf("redirect to a public url gives %v", redirectErr)
+    }
+
+    for _, target := range []string{"http://169.254.169.254/latest/meta-data/", "http://localhost/", "ftp://example.org/"} {
+        if redirect(target) == nil {
+            t.Errorf("redirect to %s is followed", target)
+        }
+    }
+}
+
+//a re-check confirming a status moves checkedAt without a history event
+func TestMatchesRecordedStateIgnoresCheckTimes(t *testing.T) {
+    checked := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+    rechecked := checked.Add(time.Hour)
+    document := sampleDocument()
+    document.Links = []DocumentLink{{URL: "https://example.com/terms", Status: LinkOK, CheckedAt: &checked}}
+    recorded := recordedDocument(t, document)
+
+    confirmed := document
+    confirmed.Links = []DocumentLink{{URL: "https://example.com/terms", Status: LinkOK, CheckedAt: &rechecked}}
+
+    if !matchesRecordedState(recorded, storedDocument(t, confirmed)) {
+        t.Error("document differs from its recorded state after a confirming re-check")
+    }
+
+    if document.Links[0].CheckedAt == nil {
+        t.Error("comparing cleared the check time of the document")
+    }
+
+    broken := document
+    broken.Links = []DocumentLink{{URL: "https://example.com/terms", Status: LinkBroken, CheckedAt: &rechecked}}
+
+    if matchesRecordedState(recorded, storedDocument(t, broken)) {
+        t.Error("document with a status changed out-of-band matches its recorded state")
+    }
+}
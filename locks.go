@@ -0,0 +1,95 @@
+package main
+
+import (
+    "context"
+    "crypto/rand"
+    "encoding/hex"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+    "log"
+    "os"
+    "time"
+)
+
+/* background workers that must run on one instance at a time hold a lease in the
+locks collection. A lease expires unless renewed, so when its holder dies another
+instance takes over after at most one lease duration */
+var lockCollectionName string = "precisely-locks"
+
+func lockCollection() *mongo.Collection {
+    return getMongoClient().Database(databaseName).Collection(lockCollectionName)
+}
+
+var leaseDuration time.Duration = time.Duration(getEnvInt("PRECISELY_LEASE_SECONDS", 30)) * time.Second
+
+//identifies this process as the holder of leases
+var instanceID string = newInstanceID()
+
+func newInstanceID() string {
+    hostname, _ := os.Hostname()
+    random := make([]byte, 4)
+    rand.Read(random)
+
+    return hostname + "-" + hex.EncodeToString(random)
+}
+
+/* take or renew the named lease, true if this instance holds it afterwards. The
+lease document is only matched when free, expired or already ours; otherwise the
+upsert collides with the existing _id and the lease is held elsewhere */
+func acquireLease(name string) bool {
+    now := time.Now().UTC()
+    filter := bson.D{
+        {Key: "_id", Value: name},
+        {Key: "$or", Value: bson.A{
+            bson.D{{Key: "owner", Value: instanceID}},
+            bson.D{{Key: "expiresat", Value: bson.D{{Key: "$lt", Value: now}}}},
+        }},
+    }
+    update := bson.D{{Key: "$set", Value: bson.D{
+        {Key: "owner", Value: instanceID},
+        {Key: "expiresat", Value: now.Add(leaseDuration)},
+    }}}
+
+    _, updateErr := lockCollection().UpdateOne(context.TODO(), filter, update, options.Update().SetUpsert(true))
+
+    if updateErr != nil {
+        if !mongo.IsDuplicateKeyError(updateErr) {
+            log.Print("Error acquiring lease ", name, ": ", updateErr)
+        }
+
+        return false
+    }
+
+    return true
+}
+
+/* run work every interval, but only while holding the named lease. work gets a
+function renewing the lease, to call between steps of long runs; it returns false
+once the lease is lost, and work should stop */
+func runWithLease(name string, interval time.Duration, work func(renewLease func() bool)) {
+    renewLease := func() bool {
+        return acquireLease(name)
+    }
+    leading := false
+
+    for {
+        holdsLease := acquireLease(name)
+
+        if holdsLease != leading {
+            leading = holdsLease
+
+            if leading {
+                log.Print("Instance ", instanceID, " took over ", name)
+            } else {
+                log.Print("Instance ", instanceID, " handed over ", name)
+            }
+        }
+
+        if holdsLease {
+            work(renewLease)
+        }
+
+        time.Sleep(interval)
+    }
+}
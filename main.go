@@ -1,43 +1,162 @@
 package main
 
 import (
+    "bytes"
+    "encoding/base64"
+    "encoding/json"
+    "flag"
     "github.com/gin-gonic/gin"
+    "go.mongodb.org/mongo-driver/bson"
+    "log"
+    "mime"
     "net/http"
     "strconv"
+    "strings"
+    "sync"
+    "time"
+    "unicode/utf8"
 )
 
 //pointers used because of serialization, nil pointer signals no value by omitempty
 type DocumentContent struct {
-    Header *string `json:"header,omitempty"`
-    Data   *string `json:"data,omitempty"`
+    Header      *string `json:"header,omitempty"`
+    Data        *string `json:"data,omitempty"`
+    ContentType *string `json:"contentType,omitempty"` //media type of data, e.g. application/pdf
+    Encoding    *string `json:"encoding,omitempty"`    //how data is encoded, see below
 }
 
+//encodings of content data. Binary content, like scanned contracts, is sent base64 encoded
+const (
+    TextEncoding   = "text"
+    Base64Encoding = "base64"
+)
+
 type Document struct {
-    ID       *int              `json:"id,omitempty"`
-    Title    *string           `json:"title,omitempty"`
-    Content  *DocumentContent  `json:"content,omitempty"`
-    Signee   *string           `json:"signee,omitempty"`
+    ID             *int                `json:"id,omitempty"`
+    Title          *string             `json:"title,omitempty"`
+    Content        *DocumentContent    `json:"content,omitempty"`
+    Signee         *string             `json:"signee,omitempty"`
+    LegalHold      *bool               `json:"legalHold,omitempty"`      //set by admins only, blocks deletion
+    Metadata       map[string]string   `json:"metadata,omitempty"`       //free key-value pairs for integrators, see metadata.go
+    References     []ExternalReference `json:"references,omitempty"`     //records in other systems, see references.go
+    Links          []DocumentLink      `json:"links,omitempty"`          //external pages, see links.go
+    Revision       *int                `json:"revision,omitempty"`       //counted on write, see revisions.go
+    ChangeNote     *string             `json:"changeNote,omitempty"`     //what the revision changed
+    Classification *string             `json:"classification,omitempty"` //public, internal or confidential, see classification.go
+    Pii            []string            `json:"pii,omitempty"`            //kinds of personal data found on write
+    Stats          *DocumentStats      `json:"stats,omitempty"`          //computed on write, see stats.go
+    PolicyWarnings []PolicyMatch       `json:"policyWarnings,omitempty"` //computed on write, see policies.go
+    DuplicateHash  string              `json:"-"`                        //stored only, see duplicates.go
+    Extra          bson.M              `json:"-" bson:",inline"`         //fields unknown to this version, see unknownfields.go
 }
 
 type HttpError struct {
-    Message   string  `json:"error"`
+    Code      ErrorCode `json:"code"`
+    Message   string    `json:"error"`
 }
 
+/* stable, machine-readable reasons of an error response. Messages are meant for
+people and may change, clients should branch on the code instead */
+type ErrorCode string
+
+const (
+    CodeValidationFailed       ErrorCode = "VALIDATION_FAILED"
+    CodeUnauthorized           ErrorCode = "UNAUTHORIZED"
+    CodeForbidden              ErrorCode = "FORBIDDEN"
+    CodeDocNotFound            ErrorCode = "DOC_NOT_FOUND"
+    CodeDocDeleted             ErrorCode = "DOC_DELETED"
+    CodeDeadLetterNotFound     ErrorCode = "DEAD_LETTER_NOT_FOUND"
+    CodeUnsupportedEncoding    ErrorCode = "UNSUPPORTED_ENCODING"
+    CodeUnderLegalHold         ErrorCode = "UNDER_LEGAL_HOLD"
+    CodeDocFrozen              ErrorCode = "DOC_FROZEN"
+    CodeReferenceTaken         ErrorCode = "REFERENCE_TAKEN"
+    CodeDuplicateDocument      ErrorCode = "DUPLICATE_DOCUMENT"
+    CodePreconditionFailed     ErrorCode = "PRECONDITION_FAILED"
+    CodePayloadTooLarge        ErrorCode = "PAYLOAD_TOO_LARGE"
+    CodeUnsupportedCompression ErrorCode = "UNSUPPORTED_COMPRESSION"
+    CodeSizeLimitExceeded      ErrorCode = "SIZE_LIMIT_EXCEEDED"
+    CodePolicyViolation        ErrorCode = "POLICY_VIOLATION"
+    CodeRebuildRunning         ErrorCode = "REBUILD_RUNNING"
+    CodeRateLimited            ErrorCode = "RATE_LIMITED"
+    CodeDbUnavailable          ErrorCode = "DB_UNAVAILABLE"
+    CodeInternal               ErrorCode = "INTERNAL_ERROR"
+)
+
 func main() {
+    flag.Parse()
+
+    //report every problem of the configuration and dependencies at once, see startup.go
+    if !runStartupChecks(initMongoDB()) {
+        log.Fatal("Startup checks failed, see the report above")
+    }
+
+    if *checkOnly {
+        destruct()
+        return
+    }
+
+    if *rebuildOnly {
+        if !acquireLease(rebuildLeaseName) {
+            log.Fatal("A rebuild of projections is already running on another instance")
+        }
+
+        beginProjectionRebuild()
+        rebuildProjections(func() bool {
+            return acquireLease(rebuildLeaseName)
+        })
+        destruct()
+        return
+    }
+
+    if *migrateCompressionOnly {
+        migrateContentCompression()
+        destruct()
+        return
+    }
+
     router := gin.Default()
 
     defer destruct() //for dbController.go
 
-    //read single document by id
-    router.GET("/documents/:id", handleGetDocument)
-    //read all documents
-    router.GET("/documents", handleGetDocuments)
-    //create document
-    router.POST("/documents", handleCreateDocument)
-    //update document
-    router.PATCH("/documents/:id", handleUpdateDocument)
-    //delete document
-    router.DELETE("/documents/:id", handleDeleteDocument)
+    //replace the database connection if it gets stuck
+    go monitorMongoDB()
+
+    //deliver queued document events in the background
+    go runOutboxDispatcher()
+
+    //flag links of documents that no longer resolve
+    go runLinkChecker()
+
+    //apply changes of the stored settings made through other instances
+    go runSettingsWatcher()
+
+    //create indexes for frequent queries, if enabled
+    go runIndexCreator()
+
+    //post alerts when configured rules start or stop firing
+    go runAlertChecker()
+
+    //see clients behind trusted proxies, instead of the proxies. gin's own header
+    //handling is turned off, it trusts any peer and takes the forgeable first hop
+    router.TrustedProxies = nil
+    router.Use(resolveClientAddress)
+
+    //record requests and responses for replay, if enabled
+    router.Use(captureTraffic)
+
+    //count calls per endpoint and caller
+    router.Use(recordUsage)
+    go runUsageFlusher()
+
+    //note who reads which document, if enabled
+    router.Use(recordAccess)
+    go runAccessLogFlusher()
+
+    //delay, drop or fail requests for resilience testing, if configured
+    router.Use(injectFaults)
+
+    //endpoints with the middleware of their policies, see routes.go
+    registerRoutes(router)
 
     //start server
     router.Run("localhost:8080")
@@ -47,6 +166,11 @@ func getIDParam(ginCon *gin.Context) string {
     return ginCon.Param("id")
 }
 
+//the read preference of a read, see dbController.go. Params of reads embed it, holding the configured default
+type ReadParams struct {
+    ReadPreference string `query:"readPreference" enum:"primary,primaryPreferred,secondary,secondaryPreferred,nearest"`
+}
+
 func toInt (str string) (int, error) {
     return strconv.Atoi(str)
 }
@@ -55,42 +179,389 @@ func toString(i int) string {
     return strconv.Itoa(i)
 }
 
+/* a database failing to respond is answered with 503 and a hint when to retry.
+Clients written against earlier versions may expect 502, which the compatibility
+flag brings back */
+var legacyBadGateway bool = getEnvBool("PRECISELY_LEGACY_BAD_GATEWAY", false)
+var retryAfterSeconds *tunableInt = newTunableInt(getEnvInt("PRECISELY_RETRY_AFTER_SECONDS", 5))
+
+func sendDbUnavailable(ginCon *gin.Context) {
+    sendStatus(ginCon, CouldNotProceed, "the database")
+}
+
+//buffers larger than this are dropped instead of pooled, so one huge response doesn't pin memory
+const maxPooledBufferSize = 1 << 20
+
+//a buffer and an encoder writing into it, reused together between responses
+type responseEncoder struct {
+    buffer  *bytes.Buffer
+    encoder *json.Encoder
+}
+
+var responseEncoderPool = sync.Pool{
+    New: func() interface{} {
+        buffer := new(bytes.Buffer)
+        return &responseEncoder{buffer, json.NewEncoder(buffer)}
+    },
+}
+
+/* encode jsonObj in naming into the buffer. Another naming than camelCase decodes
+what was encoded and encodes it renamed into the same buffer, which saves the marshal
+inRequestNaming does beforehand */
+func (response *responseEncoder) encode(naming string, jsonObj interface{}) error {
+    response.buffer.Reset()
+
+    if encodeErr := response.encoder.Encode(jsonObj); encodeErr != nil {
+        return encodeErr
+    }
+
+    toNaming, _ := namingFunctions(naming)
+
+    if toNaming == nil {
+        return nil
+    }
+
+    value, decodeErr := decodeJsonValue(response.buffer.Bytes())
+
+    if decodeErr != nil {
+        return decodeErr
+    }
+
+    response.buffer.Reset()
+    return response.encoder.Encode(renameKeys(value, toNaming))
+}
+
+//the body answered when a response can't be encoded, written as is to not encode again
+var unencodableResponse = []byte(`{"code":"` + CodeInternal + `","error":"unexpected server state"}` + "\n")
+
+//compact json encoded into a pooled buffer, the response of all endpoints
 func sendJsonHttpResponse(ginCon *gin.Context, httpCode int, jsonObj interface{}) {
-    ginCon.IndentedJSON(httpCode, jsonObj)
+    response := responseEncoderPool.Get().(*responseEncoder)
+
+    defer func() {
+        if response.buffer.Cap() <= maxPooledBufferSize {
+            responseEncoderPool.Put(response)
+        }
+    }()
+
+    if encodeErr := response.encode(requestNaming(ginCon), jsonObj); encodeErr != nil {
+        ginCon.Data(http.StatusInternalServerError, "application/json; charset=utf-8", unencodableResponse)
+        return
+    }
+
+    ginCon.Data(httpCode, "application/json; charset=utf-8", response.buffer.Bytes())
 }
 
 func handleGetDocument(ginCon *gin.Context) {
     id, toIntErr := toInt(getIDParam(ginCon))
 
     if toIntErr != nil {
-      sendJsonHttpResponse(ginCon, http.StatusBadRequest, HttpError{"requested id '" + getIDParam(ginCon) + "' is not a number"})
+      sendJsonHttpResponse(ginCon, http.StatusBadRequest, HttpError{CodeValidationFailed, "requested id '" + getIDParam(ginCon) + "' is not a number"})
+      return
+    }
+
+    params := DocumentParams{ReadParams: ReadParams{defaultReadPreference}}
+
+    if paramsProblem := bindQuery(ginCon, &params); paramsProblem != "" {
+      sendJsonHttpResponse(ginCon, http.StatusBadRequest, HttpError{CodeValidationFailed, paramsProblem})
+      return
+    }
+
+    readPreference, view := params.ReadPreference, params.View
+    fields, fieldsProblem := parseFieldSelection(params.Fields)
+
+    if fieldsProblem != "" {
+      sendJsonHttpResponse(ginCon, http.StatusBadRequest, HttpError{CodeValidationFailed, fieldsProblem})
       return
     }
 
-    status, document := getDocument(id)
+    var status DocumentStatus
+    var document *Document
+
+    //with asOf, the document is read from its history as it was at that instant
+    if asOf := params.AsOf; !asOf.IsZero() {
+      status, document = getDocumentAsOf(ginCon.Request.Context(), id, asOf, readPreference)
+
+      if status == NotFound {
+        sendJsonHttpResponse(ginCon, http.StatusNotFound, HttpError{CodeDocNotFound, "document with id " + getIDParam(ginCon) + " did not exist at " + asOf.Format(time.RFC3339)})
+        return
+      }
+
+      //past states are read from the history as a whole
+      if status == OK {
+        selected := selectFields(*document, fields)
+        document = &selected
+      }
+    } else {
+      ctx, endReads := readContext(ginCon)
+      defer endReads()
+
+      if ctx == nil {
+        return
+      }
+
+      status, document = getSelectedDocumentIn(ctx, id, readPreference, fields)
+    }
 
     switch status {
     case OK:
-      sendJsonHttpResponse(ginCon, http.StatusOK, document)
-    case NotFound:
-      sendJsonHttpResponse(ginCon, http.StatusNotFound, HttpError{"could not find document with id " + getIDParam(ginCon)})
-    case CouldNotProceed:
-      sendJsonHttpResponse(ginCon, http.StatusBadGateway, HttpError{"external database does not respond properly"})
+      if view != RedactedView {
+        sendJsonHttpResponse(ginCon, http.StatusOK, document)
+        return
+      }
+
+      if document.Content != nil && document.Content.Encoding != nil && *document.Content.Encoding != TextEncoding {
+        sendJsonHttpResponse(ginCon, http.StatusNotAcceptable, HttpError{CodeUnsupportedEncoding, "document " + getIDParam(ginCon) + " holds " + *document.Content.Encoding + " content, which can not be redacted"})
+        return
+      }
+
+      sendJsonHttpResponse(ginCon, http.StatusOK, redactedDocument(*document))
     default:
-      sendJsonHttpResponse(ginCon, http.StatusInternalServerError, HttpError{"unexpected server state"})
+      sendDocumentStatus(ginCon, status, id)
     }
 }
 
+//query parameters of a single document, see params.go
+type DocumentParams struct {
+    ReadParams
+    View   string    `query:"view" enum:"redacted"` //see RedactedView
+    Fields string    `query:"fields"`               //see fields.go
+    AsOf   time.Time `query:"asOf"`                 //read from the history, see history.go
+}
+
+//query parameters of a listing besides its filters, see params.go
+type ListParams struct {
+    ReadParams
+    IncludeTotal bool   `query:"includeTotal"`
+    Limit        int    `query:"limit" min:"1" max:"maxListSize"`
+    Sort         string `query:"sort" enum:"id,title,signee,revision"` //see sortableFields
+    Order        string `query:"order" enum:"asc,desc"`
+    Fields       string `query:"fields"` //see fields.go
+    Cursor       string `query:"cursor"`
+    Offset       int    `query:"offset" min:"0"`
+    Envelope     bool   `query:"envelope"` //answer a ListResponse rather than the documents alone
+}
+
+//the documents of a listing together with what is otherwise sent in headers
+type ListResponse struct {
+    Documents  []Document `json:"documents"`
+    NextCursor string     `json:"nextCursor,omitempty"` //as in X-Next-Cursor, if the page is full
+    Total      *int64     `json:"total,omitempty"`      //if includeTotal was asked for
+}
+
 func handleGetDocuments(ginCon *gin.Context) {
-    status, documents := getDocuments()
+    params := ListParams{ReadParams: ReadParams{defaultReadPreference}, Limit: maxListSize.Get(), Sort: "id", Order: "asc"}
+
+    if paramsProblem := bindQuery(ginCon, &params); paramsProblem != "" {
+      sendJsonHttpResponse(ginCon, http.StatusBadRequest, HttpError{CodeValidationFailed, paramsProblem})
+      return
+    }
+
+    query := ListQuery{ReadPreference: params.ReadPreference, Limit: params.Limit, Sort: sortableFields[params.Sort], Descending: params.Order == "desc"}
+
+    if filterProblem := bindListFilters(ginCon, &query); filterProblem != "" {
+      sendJsonHttpResponse(ginCon, http.StatusBadRequest, HttpError{CodeValidationFailed, filterProblem})
+      return
+    }
+
+    fields, fieldsProblem := parseFieldSelection(params.Fields)
+
+    if fieldsProblem != "" {
+      sendJsonHttpResponse(ginCon, http.StatusBadRequest, HttpError{CodeValidationFailed, fieldsProblem})
+      return
+    }
+
+    query.Fields = fields
+    query.Skip = params.Offset
+
+    if params.Offset > 0 && params.Cursor != "" {
+      sendJsonHttpResponse(ginCon, http.StatusBadRequest, HttpError{CodeValidationFailed, "offset and cursor can not be combined"})
+      return
+    }
+
+    if token := params.Cursor; token != "" {
+      after, cursorErr := decodeListCursor(token)
+
+      if cursorErr != nil {
+        sendJsonHttpResponse(ginCon, http.StatusBadRequest, HttpError{CodeValidationFailed, "malformed cursor '" + token + "'"})
+        return
+      }
+
+      if after.Sort != query.sortKey() {
+        sendJsonHttpResponse(ginCon, http.StatusBadRequest, HttpError{CodeValidationFailed, "cursor belongs to a listing of another sort or order"})
+        return
+      }
+
+      query.After = after
+    }
+
+    ctx, endReads := readContext(ginCon)
+    defer endReads()
+
+    if ctx == nil {
+      return
+    }
+
+    debug := DebugInfo{TimingsMs: map[string]float64{}}
+    start := time.Now()
+    status, documents := getDocuments(ctx, query)
+    debug.TimingsMs["find"] = millisSince(start)
+
+    response := ListResponse{Documents: documents}
+
+    //a full page means there may be more documents, so hand out a cursor to the next page
+    if status == OK && len(documents) == query.Limit {
+      nextCursor, encodeErr := encodeListCursor(cursorAfter(documents[len(documents)-1], query))
+
+      if encodeErr != nil {
+        status = ImplementationError
+      } else {
+        ginCon.Header("X-Next-Cursor", nextCursor)
+        response.NextCursor = nextCursor
+      }
+    }
+
+    //fields read only to hand out the cursor are not answered
+    for i := range documents {
+        documents[i] = selectFields(documents[i], query.Fields)
+    }
+
+    //the total is optional, since counting may scan the whole collection
+    if status == OK && params.IncludeTotal {
+      var total int64
+      start = time.Now()
+      status, total = countDocuments(ctx, query)
+      debug.TimingsMs["count"] = millisSince(start)
+
+      if status == OK {
+        ginCon.Header("X-Total-Count", strconv.FormatInt(total, 10))
+        response.Total = &total
+      }
+    }
 
     switch status {
     case OK:
-      sendJsonHttpResponse(ginCon, http.StatusOK, documents)
-    case CouldNotProceed:
-      sendJsonHttpResponse(ginCon, http.StatusBadGateway, HttpError{"external database does not respond properly"})
+      if !isDebugRequest(ginCon) {
+        if params.Envelope {
+          sendJsonHttpResponse(ginCon, http.StatusOK, response)
+          return
+        }
+
+        sendJsonHttpResponse(ginCon, http.StatusOK, documents)
+        return
+      }
+
+      if explainErr := explainListQuery(query, &debug); explainErr != nil {
+        log.Print("Could not explain list query: ", explainErr)
+      }
+
+      sendJsonHttpResponse(ginCon, http.StatusOK, DebugListResponse{response, debug})
     default:
-      sendJsonHttpResponse(ginCon, http.StatusInternalServerError, HttpError{"unexpected server state"})
+      sendStatus(ginCon, status, "the documents")
+    }
+}
+
+//filters of a listing besides those of metadata, see params.go
+type ListFilterParams struct {
+    Classification string `query:"classification" enum:"public,internal,confidential"` //see classifications
+    Pii            string `query:"pii" enum:"email,phone,nationalId"`                   //see piiKinds
+    Signee         string `query:"signee" maxLength:"maxSigneeLength"`
+}
+
+//set the filters of a list query from the request, e.g. metadata.region=EMEA, or tell what is wrong with them
+func bindListFilters(ginCon *gin.Context, query *ListQuery) string {
+    metadata, metadataProblem := metadataFilterParams(ginCon.Request.URL.Query())
+
+    if metadataProblem != "" {
+        return metadataProblem
+    }
+
+    var params ListFilterParams
+
+    if paramsProblem := bindQuery(ginCon, &params); paramsProblem != "" {
+        return paramsProblem
+    }
+
+    query.Metadata, query.Classification, query.Pii, query.Signee = metadata, params.Classification, params.Pii, params.Signee
+    return ""
+}
+
+type DocumentCount struct {
+    Count int64 `json:"count"`
+}
+
+//count the documents a listing with the same filters would hold, without reading them
+func handleCountDocuments(ginCon *gin.Context) {
+    params := ReadParams{defaultReadPreference}
+
+    if paramsProblem := bindQuery(ginCon, &params); paramsProblem != "" {
+      sendJsonHttpResponse(ginCon, http.StatusBadRequest, HttpError{CodeValidationFailed, paramsProblem})
+      return
+    }
+
+    readPreference := params.ReadPreference
+
+    query := ListQuery{ReadPreference: readPreference}
+
+    if filterProblem := bindListFilters(ginCon, &query); filterProblem != "" {
+      sendJsonHttpResponse(ginCon, http.StatusBadRequest, HttpError{CodeValidationFailed, filterProblem})
+      return
+    }
+
+    ctx, endReads := readContext(ginCon)
+    defer endReads()
+
+    if ctx == nil {
+      return
+    }
+
+    status, count := countDocuments(ctx, query)
+
+    switch status {
+    case OK:
+      sendJsonHttpResponse(ginCon, http.StatusOK, DocumentCount{count})
+    default:
+      sendStatus(ginCon, status, "the documents")
+    }
+}
+
+type ExistsRequest struct {
+    IDs []int `json:"ids"`
+}
+
+//tell which of the given ids belong to a document, without reading the documents
+func handleDocumentsExist(ginCon *gin.Context) {
+    params := ReadParams{defaultReadPreference}
+
+    if paramsProblem := bindQuery(ginCon, &params); paramsProblem != "" {
+      sendJsonHttpResponse(ginCon, http.StatusBadRequest, HttpError{CodeValidationFailed, paramsProblem})
+      return
+    }
+
+    readPreference := params.ReadPreference
+
+    var request ExistsRequest
+    maxIds := maxListSize.Get()
+
+    if bindErr := ginCon.BindJSON(&request); bindErr != nil || len(request.IDs) > maxIds {
+      sendJsonHttpResponse(ginCon, http.StatusBadRequest, HttpError{CodeValidationFailed, "expected a json object like {\"ids\": [1, 2, 3]} with at most " + toString(maxIds) + " ids"})
+      return
+    }
+
+    ctx, endReads := readContext(ginCon)
+    defer endReads()
+
+    if ctx == nil {
+      return
+    }
+
+    status, existing := getExistingIds(ctx, request.IDs, readPreference)
+
+    switch status {
+    case OK:
+      sendJsonHttpResponse(ginCon, http.StatusOK, existing)
+    default:
+      sendStatus(ginCon, status, "the documents")
     }
 }
 
@@ -110,31 +581,199 @@ func isCompleteDocument(document Document) bool {
     }
 }
 
+//query parameters of creating documents, one or in bulk, see params.go
+type CreateParams struct {
+    Force bool `query:"force"` //create even a duplicate, see duplicates.go
+}
+
 func handleCreateDocument(ginCon *gin.Context) {
     document, initErr := bindDocument(ginCon)
 
     if initErr != nil {
-        sendJsonHttpResponse(ginCon, http.StatusBadRequest, HttpError{"illegal structure of json object"})
+        sendJsonHttpResponse(ginCon, http.StatusBadRequest, HttpError{CodeValidationFailed, "illegal structure of json object"})
         return
     }
 
-    //validate document
-    if !isCompleteDocument(document) {
-      sendJsonHttpResponse(ginCon, http.StatusBadRequest, HttpError{"not a valid document for creation; every field except id is needed."})
-      return
+    if !validateNewDocument(ginCon, &document) {
+        return
     }
 
-    status, newDocument := createDocument(document)
+    params := CreateParams{}
+
+    if paramsProblem := bindQuery(ginCon, &params); paramsProblem != "" {
+        sendJsonHttpResponse(ginCon, http.StatusBadRequest, HttpError{CodeValidationFailed, paramsProblem})
+        return
+    }
+
+    if detectDuplicates && !params.Force {
+        status, duplicate := findDuplicate(ginCon.Request.Context(), document)
+
+        switch status {
+        case OK:
+          ginCon.Header("Location", "/documents/" + toString(*duplicate.ID))
+          sendJsonHttpResponse(ginCon, http.StatusConflict, HttpError{CodeDuplicateDocument, "document " + toString(*duplicate.ID) + " has the same title and content; add force=true to create it anyway"})
+          return
+        case NotFound:
+        default:
+          sendStatus(ginCon, status, "the document")
+          return
+        }
+    }
+
+    request := newWriteRequest(ginCon)
+    status, newDocument := createDocument(document, request)
 
     switch status {
     case OK:
+      sendConsistencyToken(ginCon, request)
       sendJsonHttpResponse(ginCon, http.StatusCreated, newDocument)
-    case CouldNotProceed:
-      sendJsonHttpResponse(ginCon, http.StatusBadGateway, HttpError{"external database does not respond properly"})
-    case ImplementationError:
-      fallthrough
     default:
-      sendJsonHttpResponse(ginCon, http.StatusInternalServerError, HttpError{"unexpected server state"})
+      sendStatus(ginCon, status, "the document")
+    }
+}
+
+/* check a document given in full, as for creation, and normalize it for storage.
+Sends the error response and returns false if the document is not acceptable */
+func validateNewDocument(ginCon *gin.Context, document *Document) bool {
+    if code, problem := newDocumentProblem(document); problem != nil {
+        sendJsonHttpResponse(ginCon, code, *problem)
+        return false
+    }
+
+    if violation := policyViolation(*document); violation != nil {
+        sendJsonHttpResponse(ginCon, http.StatusUnprocessableEntity, *violation)
+        return false
+    }
+
+    return true
+}
+
+//the response code and error of the first problem of a document for creation, or nil if it is fine
+func newDocumentProblem(document *Document) (int, *HttpError) {
+    if document.LegalHold != nil {
+        return http.StatusForbidden, &HttpError{CodeForbidden, "legalHold can only be set by admins, using PUT /admin/documents/:id/legal-hold"}
+    }
+
+    //validate document
+    if !isCompleteDocument(*document) {
+        return http.StatusBadRequest, &HttpError{CodeValidationFailed, "not a valid document for creation; every field except id is needed."}
+    }
+
+    if encodingProblem := normalizeContentEncoding(document.Content); encodingProblem != "" {
+        return http.StatusBadRequest, &HttpError{CodeValidationFailed, encodingProblem}
+    }
+
+    if metadataProblem := metadataProblem(document.Metadata); metadataProblem != "" {
+        return http.StatusBadRequest, &HttpError{CodeValidationFailed, metadataProblem}
+    }
+
+    document.Metadata = withoutEmptyMetadata(document.Metadata)
+
+    if referencesProblem := referencesProblem(document.References); referencesProblem != "" {
+        return http.StatusBadRequest, &HttpError{CodeValidationFailed, referencesProblem}
+    }
+
+    if linksProblem := linksProblem(document.Links); linksProblem != "" {
+        return http.StatusBadRequest, &HttpError{CodeValidationFailed, linksProblem}
+    }
+
+    if classificationProblem := classificationProblem(document.Classification); classificationProblem != "" {
+        return http.StatusBadRequest, &HttpError{CodeValidationFailed, classificationProblem}
+    }
+
+    if changeNoteProblem := changeNoteProblem(document.ChangeNote, false); changeNoteProblem != "" {
+        return http.StatusBadRequest, &HttpError{CodeValidationFailed, changeNoteProblem}
+    }
+
+    document.Links = withoutCheckResults(document.Links)
+
+    if exceededLimit := exceededSizeLimit(*document); exceededLimit != "" {
+        return http.StatusUnprocessableEntity, &HttpError{CodeSizeLimitExceeded, exceededLimit}
+    }
+
+    return http.StatusOK, nil
+}
+
+//maximum number of characters per field, keeping single documents from degrading list performance
+var maxTitleLength int = getEnvInt("PRECISELY_MAX_TITLE_LENGTH", 500)
+var maxHeaderLength int = getEnvInt("PRECISELY_MAX_HEADER_LENGTH", 2000)
+var maxDataLength int = getEnvInt("PRECISELY_MAX_DATA_LENGTH", 1000000)
+var maxSigneeLength int = getEnvInt("PRECISELY_MAX_SIGNEE_LENGTH", 500)
+
+func exceedsLength(value *string, maxLength int) bool {
+    return value != nil && utf8.RuneCountInString(*value) > maxLength
+}
+
+//describe the first size limit a document exceeds, or return an empty string if within limits
+func exceededSizeLimit(document Document) string {
+    if exceedsLength(document.Title, maxTitleLength) {
+        return "title exceeds the maximum length of " + toString(maxTitleLength) + " characters"
+    }
+
+    if document.Content != nil {
+        if exceedsLength(document.Content.Header, maxHeaderLength) {
+            return "content.header exceeds the maximum length of " + toString(maxHeaderLength) + " characters"
+        }
+
+        if exceedsLength(document.Content.Data, maxDataLength) {
+            return "content.data exceeds the maximum length of " + toString(maxDataLength) + " characters"
+        }
+    }
+
+    if exceedsLength(document.Signee, maxSigneeLength) {
+        return "signee exceeds the maximum length of " + toString(maxSigneeLength) + " characters"
+    }
+
+    if exceededLimit := exceededMetadataLimit(document.Metadata); exceededLimit != "" {
+        return exceededLimit
+    }
+
+    if exceededLimit := exceededReferencesLimit(document.References); exceededLimit != "" {
+        return exceededLimit
+    }
+
+    return exceededLinksLimit(document.Links)
+}
+
+/* check the encoding metadata of content, returning a description of the first
+problem found or an empty string. Data without an encoding is plain text, which
+is made explicit on the document so the stored encoding always describes the
+stored data */
+func normalizeContentEncoding(content *DocumentContent) string {
+    if content == nil {
+        return ""
+    }
+
+    if content.ContentType != nil {
+        if _, _, mediaErr := mime.ParseMediaType(*content.ContentType); mediaErr != nil {
+            return "content.contentType '" + *content.ContentType + "' is not a valid media type"
+        }
+    }
+
+    if content.Data == nil {
+        if content.Encoding != nil {
+            return "content.encoding can only be set together with content.data"
+        }
+
+        return ""
+    }
+
+    if content.Encoding == nil {
+        content.Encoding = new(string)
+        *content.Encoding = TextEncoding
+    }
+
+    switch *content.Encoding {
+    case TextEncoding:
+      return ""
+    case Base64Encoding:
+      if _, decodeErr := base64.StdEncoding.DecodeString(*content.Data); decodeErr != nil {
+        return "content.data is not valid base64"
+      }
+
+      return ""
+    default:
+      return "unknown content.encoding '" + *content.Encoding + "'; use " + TextEncoding + " or " + Base64Encoding
     }
 }
 
@@ -143,37 +782,93 @@ func isValidPatchDocument(document Document) bool {
     var existingContent = false
 
     if document.Content != nil {
-        existingContent = document.Content.Header != nil || document.Content.Data != nil
+        existingContent = document.Content.Header != nil || document.Content.Data != nil ||
+                          document.Content.ContentType != nil || document.Content.Encoding != nil
     }
 
-    return existingContent || document.Title != nil || document.Signee != nil
+    return existingContent || document.Title != nil || document.Signee != nil ||
+           len(document.Metadata) > 0 || document.References != nil || document.Links != nil ||
+           document.Classification != nil
+}
+
+//the response code and error of the first problem of a patch document, or nil if it is fine
+func patchDocumentProblem(patchDocument *Document) (int, *HttpError) {
+  if patchDocument.LegalHold != nil {
+    return http.StatusForbidden, &HttpError{CodeForbidden, "legalHold can only be set by admins, using PUT /admin/documents/:id/legal-hold"}
+  }
+
+  //validate patch document
+  if !isValidPatchDocument(*patchDocument) {
+    return http.StatusBadRequest, &HttpError{CodeValidationFailed, "not a valid document for update; at least one field except id is needed."}
+  }
+
+  if encodingProblem := normalizeContentEncoding(patchDocument.Content); encodingProblem != "" {
+    return http.StatusBadRequest, &HttpError{CodeValidationFailed, encodingProblem}
+  }
+
+  if metadataProblem := metadataProblem(patchDocument.Metadata); metadataProblem != "" {
+    return http.StatusBadRequest, &HttpError{CodeValidationFailed, metadataProblem}
+  }
+
+  if referencesProblem := referencesProblem(patchDocument.References); referencesProblem != "" {
+    return http.StatusBadRequest, &HttpError{CodeValidationFailed, referencesProblem}
+  }
+
+  if linksProblem := linksProblem(patchDocument.Links); linksProblem != "" {
+    return http.StatusBadRequest, &HttpError{CodeValidationFailed, linksProblem}
+  }
+
+  if classificationProblem := classificationProblem(patchDocument.Classification); classificationProblem != "" {
+    return http.StatusBadRequest, &HttpError{CodeValidationFailed, classificationProblem}
+  }
+
+  if patchDocument.ChangeNote != nil && !isRevision(*patchDocument) {
+    return http.StatusBadRequest, &HttpError{CodeValidationFailed, "changeNote can only be given when changing the title, content or signee"}
+  }
+
+  if changeNoteProblem := changeNoteProblem(patchDocument.ChangeNote, requireChangeNote && isRevision(*patchDocument)); changeNoteProblem != "" {
+    return http.StatusBadRequest, &HttpError{CodeValidationFailed, changeNoteProblem}
+  }
+
+  patchDocument.Links = withoutCheckResults(patchDocument.Links)
+
+  if exceededLimit := exceededSizeLimit(*patchDocument); exceededLimit != "" {
+    return http.StatusUnprocessableEntity, &HttpError{CodeSizeLimitExceeded, exceededLimit}
+  }
+
+  return http.StatusOK, nil
 }
 
 func handleUpdateDocument(ginCon *gin.Context) {
   id, toIntErr := toInt(getIDParam(ginCon))
 
   if toIntErr != nil {
-    sendJsonHttpResponse(ginCon, http.StatusBadRequest, HttpError{"requested id '" + getIDParam(ginCon) + "' is not a number"})
+    sendJsonHttpResponse(ginCon, http.StatusBadRequest, HttpError{CodeValidationFailed, "requested id '" + getIDParam(ginCon) + "' is not a number"})
     return
   }
 
   patchDocument, initErr := bindDocument(ginCon)
 
   if initErr != nil {
-      sendJsonHttpResponse(ginCon, http.StatusBadRequest, HttpError{"illegal structure of json object"})
+      sendJsonHttpResponse(ginCon, http.StatusBadRequest, HttpError{CodeValidationFailed, "illegal structure of json object"})
       return
   }
 
-  //validate patch document
-  if !isValidPatchDocument(patchDocument) {
-    sendJsonHttpResponse(ginCon, http.StatusBadRequest, HttpError{"not a valid document for update; at least one field except id is needed."})
+  if code, problem := patchDocumentProblem(&patchDocument); problem != nil {
+    sendJsonHttpResponse(ginCon, code, *problem)
+    return
+  }
+
+  //only the text set by the patch is checked, the rest was checked when it was written
+  if violation := policyViolation(patchDocument); violation != nil {
+    sendJsonHttpResponse(ginCon, http.StatusUnprocessableEntity, *violation)
     return
   }
 
   //if both request id and document id are set, check so that they are the same
   if patchDocument.ID != nil {
       if *patchDocument.ID != id {
-          sendJsonHttpResponse(ginCon, http.StatusBadRequest, HttpError{"id in request (" + toString(id) + ") does not correpsond to id in json object (" + toString(*patchDocument.ID) + ")"})
+          sendJsonHttpResponse(ginCon, http.StatusBadRequest, HttpError{CodeValidationFailed, "id in request (" + toString(id) + ") does not correpsond to id in json object (" + toString(*patchDocument.ID) + ")"})
           return
       }
   } else {
@@ -182,19 +877,68 @@ func handleUpdateDocument(ginCon *gin.Context) {
       *patchDocument.ID = id
   }
 
-  status, updatedDocument := updateDocument(patchDocument)
+  request := newWriteRequest(ginCon)
+  status, updatedDocument := updateDocument(patchDocument, request)
 
   switch status {
   case OK:
+    sendConsistencyToken(ginCon, request)
     sendJsonHttpResponse(ginCon, http.StatusOK, updatedDocument)
-  case CouldNotProceed:
-    sendJsonHttpResponse(ginCon, http.StatusBadGateway, HttpError{"external database does not respond properly"})
-  case NotFound:
-    sendJsonHttpResponse(ginCon, http.StatusNotFound, HttpError{"could not find document with id " + getIDParam(ginCon)})
-  case ImplementationError:
-    fallthrough
   default:
-    sendJsonHttpResponse(ginCon, http.StatusInternalServerError, HttpError{"unexpected server state"})
+    sendDocumentStatus(ginCon, status, id)
+  }
+}
+
+/* opt-in: PUT /documents/:id creates the document with the given id or replaces it,
+for systems mirroring documents kept elsewhere under their own ids */
+var enablePut bool = getEnvBool("PRECISELY_ENABLE_PUT", false)
+
+func handlePutDocument(ginCon *gin.Context) {
+  id, toIntErr := toInt(getIDParam(ginCon))
+
+  if toIntErr != nil || id < 0 {
+    sendJsonHttpResponse(ginCon, http.StatusBadRequest, HttpError{CodeValidationFailed, "requested id '" + getIDParam(ginCon) + "' is not a non-negative number"})
+    return
+  }
+
+  document, initErr := bindDocument(ginCon)
+
+  if initErr != nil {
+    sendJsonHttpResponse(ginCon, http.StatusBadRequest, HttpError{CodeValidationFailed, "illegal structure of json object"})
+    return
+  }
+
+  if document.ID != nil && *document.ID != id {
+    sendJsonHttpResponse(ginCon, http.StatusBadRequest, HttpError{CodeValidationFailed, "id of the document does not match the id of the url"})
+    return
+  }
+
+  if !validateNewDocument(ginCon, &document) {
+    return
+  }
+
+  //"If-None-Match: *" asks to create only, since no existing document may match
+  createOnly := strings.TrimSpace(ginCon.GetHeader("If-None-Match")) == "*"
+
+  //a put that may replace a document is an edit
+  if changeNoteProblem := changeNoteProblem(document.ChangeNote, requireChangeNote && !createOnly); changeNoteProblem != "" {
+    sendJsonHttpResponse(ginCon, http.StatusBadRequest, HttpError{CodeValidationFailed, changeNoteProblem})
+    return
+  }
+
+  request := newWriteRequest(ginCon)
+  status, created, putDocument := putDocument(id, document, createOnly, request)
+
+  switch status {
+  case OK:
+    sendConsistencyToken(ginCon, request)
+    if created {
+      sendJsonHttpResponse(ginCon, http.StatusCreated, putDocument)
+    } else {
+      sendJsonHttpResponse(ginCon, http.StatusOK, putDocument)
+    }
+  default:
+    sendDocumentStatus(ginCon, status, id)
   }
 }
 
@@ -202,20 +946,18 @@ func handleDeleteDocument(ginCon *gin.Context) {
   id, toIntErr := toInt(getIDParam(ginCon))
 
   if toIntErr != nil {
-    sendJsonHttpResponse(ginCon, http.StatusBadRequest, HttpError{"requested id '" + getIDParam(ginCon) + "' is not a number"})
+    sendJsonHttpResponse(ginCon, http.StatusBadRequest, HttpError{CodeValidationFailed, "requested id '" + getIDParam(ginCon) + "' is not a number"})
     return
   }
 
-  status := deleteDocument(id)
+  request := newWriteRequest(ginCon)
+  status := deleteDocument(id, request)
 
   switch status {
   case OK:
+    sendConsistencyToken(ginCon, request)
     sendJsonHttpResponse(ginCon, http.StatusNoContent, nil)
-  case CouldNotProceed:
-    sendJsonHttpResponse(ginCon, http.StatusBadGateway, HttpError{"external database does not respond properly"})
-  case NotFound:
-    sendJsonHttpResponse(ginCon, http.StatusNotFound, HttpError{"could not find document with id " + getIDParam(ginCon)})
   default:
-    sendJsonHttpResponse(ginCon, http.StatusInternalServerError, HttpError{"unexpected server state"})
+    sendDocumentStatus(ginCon, status, id)
   }
 }
@@ -0,0 +1,130 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "math"
+    "strings"
+    "github.com/gin-gonic/gin"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+)
+
+func init() {
+    gin.SetMode(gin.TestMode)
+}
+
+func stringOf(value string) *string {
+    return &value
+}
+
+func intOf(value int) *int {
+    return &value
+}
+
+//a document as clients typically send and read it
+func sampleDocument() Document {
+    return Document{
+        ID:      intOf(7),
+        Title:   stringOf("Lease agreement"),
+        Content: &DocumentContent{Header: stringOf("Lease of the premises at Main Street 1"), Data: stringOf(string(bytes.Repeat([]byte("The tenant shall pay the rent monthly. "), 50)))},
+        Signee:  stringOf("Jane Doe"),
+        Metadata: map[string]string{"caseNumber": "2024-117", "department": "legal"},
+        References: []ExternalReference{{System: "crm", ExternalID: "A-1001"}},
+    }
+}
+
+//a gin context for a request, recording the response
+func testContext(method string, target string, body []byte) (*gin.Context, *httptest.ResponseRecorder) {
+    recorder := httptest.NewRecorder()
+    ginCon, _ := gin.CreateTestContext(recorder)
+    ginCon.Request = httptest.NewRequest(method, target, bytes.NewReader(body))
+
+    if body != nil {
+        ginCon.Request.Header.Set("Content-Type", gin.MIMEJSON)
+    }
+
+    return ginCon, recorder
+}
+
+func BenchmarkBindDocument(b *testing.B) {
+    body := []byte(`{"title":"Lease agreement","content":{"header":"Lease of the premises","data":"The tenant shall pay the rent monthly."},"signee":"Jane Doe","metadata":{"caseNumber":"2024-117"}}`)
+    b.ReportAllocs()
+
+    for i := 0; i < b.N; i++ {
+        ginCon, _ := testContext(http.MethodPost, "/documents", body)
+
+        if _, bindErr := bindDocument(ginCon); bindErr != nil {
+            b.Fatal(bindErr)
+        }
+    }
+}
+
+//the response of all endpoints, like a document of GET /documents/:id
+func BenchmarkSendJsonHttpResponse(b *testing.B) {
+    document := sampleDocument()
+    ginCon, recorder := testContext(http.MethodGet, "/documents/7", nil)
+    b.ReportAllocs()
+
+    for i := 0; i < b.N; i++ {
+        recorder.Body.Reset()
+        sendJsonHttpResponse(ginCon, http.StatusOK, document)
+    }
+}
+
+//the same in a naming other than the declared one, which renames the encoded keys
+func BenchmarkSendJsonHttpResponseSnakeCase(b *testing.B) {
+    document := sampleDocument()
+    ginCon, recorder := testContext(http.MethodGet, "/documents/7", nil)
+    ginCon.Request.Header.Set("Accept", "application/json; naming=snake_case")
+    b.ReportAllocs()
+
+    for i := 0; i < b.N; i++ {
+        recorder.Body.Reset()
+        sendJsonHttpResponse(ginCon, http.StatusOK, document)
+    }
+}
+
+func TestSendJsonHttpResponseNaming(t *testing.T) {
+    for _, naming := range []string{CamelCase, SnakeCase, PascalCase} {
+        ginCon, recorder := testContext(http.MethodGet, "/documents/7", nil)
+        ginCon.Request.Header.Set("Accept", "application/json; naming=" + naming)
+        sendJsonHttpResponse(ginCon, http.StatusOK, sampleDocument())
+        body := recorder.Body.String()
+        toNaming, _ := namingFunctions(naming)
+        externalID := "externalId"
+
+        if toNaming != nil {
+            externalID = toNaming(externalID)
+        }
+
+        if !strings.Contains(body, `"` + externalID + `":"A-1001"`) {
+            t.Errorf("%s response lacks %s: %s", naming, externalID, body)
+        }
+
+        //keys chosen by clients stay as they are
+        if !strings.Contains(body, `"caseNumber":"2024-117"`) {
+            t.Errorf("%s response renamed a metadata key: %s", naming, body)
+        }
+
+        if strings.Count(body, "\n") != 1 || !strings.HasSuffix(body, "\n") {
+            t.Errorf("%s response is not one line of compact json: %q", naming, body)
+        }
+    }
+}
+
+func TestSendJsonHttpResponseUnencodable(t *testing.T) {
+    ginCon, recorder := testContext(http.MethodGet, "/documents/7", nil)
+    sendJsonHttpResponse(ginCon, http.StatusOK, map[string]interface{}{"rent": math.Inf(1)})
+
+    if recorder.Code != http.StatusInternalServerError {
+        t.Fatalf("unencodable response answered with %d", recorder.Code)
+    }
+
+    var answered HttpError
+
+    if decodeErr := json.Unmarshal(recorder.Body.Bytes(), &answered); decodeErr != nil || answered.Code != CodeInternal {
+        t.Errorf("unencodable response answered with %q", recorder.Body.String())
+    }
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+    "go.mongodb.org/mongo-driver/bson"
+    "net/url"
+    "strings"
+    "unicode/utf8"
+)
+
+/* metadata holds string values chosen by integrators, like CRM ids, under keys of
+their choosing. Each key is stored as its own field, so keys may not contain dots
+nor start with '$' */
+var maxMetadataKeys int = getEnvInt("PRECISELY_MAX_METADATA_KEYS", 50)
+var maxMetadataKeyLength int = getEnvInt("PRECISELY_MAX_METADATA_KEY_LENGTH", 100)
+var maxMetadataValueLength int = getEnvInt("PRECISELY_MAX_METADATA_VALUE_LENGTH", 1000)
+
+//list query parameters filtering on metadata, e.g. ?metadata.crmId=42
+const metadataParamPrefix = "metadata."
+
+//describe why key can not be a metadata key, or return an empty string if it can
+func metadataKeyProblem(key string) string {
+    if key == "" {
+        return "metadata keys can not be empty"
+    }
+
+    if strings.Contains(key, ".") || strings.HasPrefix(key, "$") {
+        return "metadata key '" + key + "' may not contain '.' or start with '$'"
+    }
+
+    return ""
+}
+
+func metadataProblem(metadata map[string]string) string {
+    for key := range metadata {
+        if problem := metadataKeyProblem(key); problem != "" {
+            return problem
+        }
+    }
+
+    return ""
+}
+
+//like exceededSizeLimit, for the metadata of a document
+func exceededMetadataLimit(metadata map[string]string) string {
+    if len(metadata) > maxMetadataKeys {
+        return "metadata exceeds the maximum of " + toString(maxMetadataKeys) + " keys"
+    }
+
+    for key, value := range metadata {
+        if utf8.RuneCountInString(key) > maxMetadataKeyLength {
+            return "metadata key '" + key + "' exceeds the maximum length of " + toString(maxMetadataKeyLength) + " characters"
+        }
+
+        if utf8.RuneCountInString(value) > maxMetadataValueLength {
+            return "metadata." + key + " exceeds the maximum length of " + toString(maxMetadataValueLength) + " characters"
+        }
+    }
+
+    return ""
+}
+
+//empty values mean no value. A new document simply leaves them out
+func withoutEmptyMetadata(metadata map[string]string) map[string]string {
+    for key, value := range metadata {
+        if value == "" {
+            delete(metadata, key)
+        }
+    }
+
+    return metadata
+}
+
+/* fields of a patch's metadata, split into values to set and keys to remove, which
+are the ones given an empty value. Keys left out of the patch are kept as they are */
+func toMetadataUpdate(metadata map[string]string) (bson.D, bson.D) {
+    set := bson.D{}
+    unset := bson.D{}
+
+    for key, value := range metadata {
+        if value == "" {
+            unset = append(unset, bson.E{Key: "metadata." + key, Value: ""})
+        } else {
+            set = append(set, bson.E{Key: "metadata." + key, Value: value})
+        }
+    }
+
+    return set, unset
+}
+
+//metadata values to filter a listing by, taken from metadata.<key> query parameters
+func metadataFilterParams(params url.Values) (map[string]string, string) {
+    filter := make(map[string]string)
+
+    for param, values := range params {
+        if !strings.HasPrefix(param, metadataParamPrefix) {
+            continue
+        }
+
+        key := strings.TrimPrefix(param, metadataParamPrefix)
+
+        if problem := metadataKeyProblem(key); problem != "" {
+            return nil, problem
+        }
+
+        filter[key] = values[0]
+    }
+
+    return filter, ""
+}
+
+//filter matching documents holding all of the given metadata values
+func metadataFilter(metadata map[string]string) bson.D {
+    filter := bson.D{}
+
+    for key, value := range metadata {
+        filter = append(filter, bson.E{Key: "metadata." + key, Value: value})
+    }
+
+    return filter
+}
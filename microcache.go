@@ -0,0 +1,171 @@
+package main
+
+import (
+    "github.com/gin-gonic/gin"
+    "net/http"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+/* dashboards polling the same listing cause bursts of identical queries. Successful
+answers of the configured endpoints are kept for a moment and served to identical
+requests, and identical requests arriving while the first is still being answered wait
+for its answer instead of querying the database too. PRECISELY_MICRO_CACHE lists the
+endpoints with how many milliseconds their answers are kept, comma separated, e.g.
+"GET /documents=1000,GET /documents/:id/access-log=2000". It is empty by default, so
+reads follow writes unless a deployment opts in. Requests with Cache-Control: no-cache
+or no-store, a consistency token or debug output are always answered afresh. Answers
+are served after the rate limit of the route, so cached answers count against it too */
+var microCacheSetting string = getEnv("PRECISELY_MICRO_CACHE", "")
+
+var microCacheDurations map[string]time.Duration = parseMicroCache(microCacheSetting)
+
+//bounds the memory held by cached answers, which may be whole pages of documents
+const maxMicroCacheEntries = 100
+
+//parse the endpoints to cache. Malformed entries are left out and reported
+func parseMicroCache(setting string) map[string]time.Duration {
+    durations := make(map[string]time.Duration)
+
+    if setting == "off" {
+        return durations
+    }
+
+    for _, entry := range strings.Split(setting, ",") {
+        entry = strings.TrimSpace(entry)
+
+        if entry == "" {
+            continue
+        }
+
+        separator := strings.LastIndex(entry, "=")
+
+        if separator < 0 {
+            settingProblem("PRECISELY_MICRO_CACHE holds an entry without milliseconds: " + entry)
+            continue
+        }
+
+        millis, convErr := strconv.Atoi(entry[separator+1:])
+        endpoint := strings.TrimSpace(entry[:separator])
+
+        if convErr != nil || millis < 1 || !strings.HasPrefix(endpoint, http.MethodGet + " /") {
+            settingProblem("PRECISELY_MICRO_CACHE must list GET endpoints with milliseconds of at least 1: " + entry)
+            continue
+        }
+
+        durations[endpoint] = time.Duration(millis) * time.Millisecond
+    }
+
+    return durations
+}
+
+type cachedResponse struct {
+    status   int
+    header   http.Header
+    body     []byte
+    done     chan struct{} //closed once the answer is known
+    finished bool          //guarded by microCacheMutex, like expires
+    expires  time.Time
+}
+
+var microCache = make(map[string]*cachedResponse)
+var microCacheMutex sync.Mutex
+
+func bypassesMicroCache(ginCon *gin.Context) bool {
+    cacheControl := strings.ToLower(ginCon.GetHeader("Cache-Control"))
+
+    return strings.Contains(cacheControl, "no-cache") || strings.Contains(cacheControl, "no-store") ||
+           ginCon.GetHeader(consistencyTokenHeader) != "" || isDebugRequest(ginCon)
+}
+
+//requests are identical if they ask for the same url, in the same naming, with the same rights
+func microCacheKey(ginCon *gin.Context) string {
+    return strconv.FormatBool(isAdminRequest(ginCon)) + " " + requestNaming(ginCon) + " " + ginCon.Request.URL.RequestURI()
+}
+
+//drop expired answers, called with microCacheMutex held
+func pruneMicroCache(now time.Time) {
+    for key, entry := range microCache {
+        if entry.finished && !now.Before(entry.expires) {
+            delete(microCache, key)
+        }
+    }
+}
+
+func writeCachedResponse(ginCon *gin.Context, entry *cachedResponse) {
+    for name, values := range entry.header {
+        ginCon.Writer.Header()[name] = append([]string(nil), values...)
+    }
+
+    ginCon.Writer.WriteHeader(entry.status)
+    ginCon.Writer.Write(entry.body)
+    ginCon.Abort()
+}
+
+//route middleware answering identical requests to the configured endpoints from one query, see routePolicy.middleware
+func serveMicroCached(ginCon *gin.Context) {
+    duration, configured := microCacheDurations[ginCon.Request.Method + " " + ginCon.FullPath()]
+
+    if !configured || bypassesMicroCache(ginCon) {
+        ginCon.Next()
+        return
+    }
+
+    key := microCacheKey(ginCon)
+    now := time.Now()
+
+    microCacheMutex.Lock()
+    entry, exists := microCache[key]
+
+    if exists && (!entry.finished || now.Before(entry.expires)) {
+        microCacheMutex.Unlock()
+        <-entry.done
+
+        //failed answers are not shared, each waiting request tries on its own
+        if entry.status == http.StatusOK {
+            writeCachedResponse(ginCon, entry)
+        } else {
+            ginCon.Next()
+        }
+
+        return
+    }
+
+    if len(microCache) >= maxMicroCacheEntries {
+        pruneMicroCache(now)
+    }
+
+    if len(microCache) >= maxMicroCacheEntries {
+        microCacheMutex.Unlock()
+        ginCon.Next()
+        return
+    }
+
+    entry = &cachedResponse{done: make(chan struct{})}
+    microCache[key] = entry
+    microCacheMutex.Unlock()
+
+    //waiting requests are released even if the handler panics
+    defer func() {
+        microCacheMutex.Lock()
+        entry.finished = true
+        entry.expires = time.Now().Add(duration)
+
+        if entry.status != http.StatusOK && microCache[key] == entry {
+            delete(microCache, key)
+        }
+
+        microCacheMutex.Unlock()
+        close(entry.done)
+    }()
+
+    writer := &capturingWriter{ResponseWriter: ginCon.Writer}
+    ginCon.Writer = writer
+    ginCon.Next()
+
+    entry.header = writer.Header().Clone()
+    entry.body = writer.body.Bytes()
+    entry.status = writer.Status()
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+    "github.com/gin-gonic/gin"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "time"
+)
+
+func TestMicroCacheIsOffByDefault(t *testing.T) {
+    if durations := parseMicroCache(""); len(durations) != 0 {
+        t.Errorf("empty setting caches %v", durations)
+    }
+}
+
+//a cached answer is still a call counted against the rate limit of its route
+func TestMicroCacheServesAfterRateLimit(t *testing.T) {
+    savedDurations, savedLimit := microCacheDurations, rateLimits[ReadRate]
+    microCacheDurations = map[string]time.Duration{"GET /documents": time.Minute}
+    rateLimits[ReadRate] = 1
+    defer func() {
+        microCacheDurations, rateLimits[ReadRate] = savedDurations, savedLimit
+        microCache = make(map[string]*cachedResponse)
+        rateCounts = make(map[string]int)
+    }()
+
+    queries := 0
+    router := gin.New()
+    router.GET("/documents", append(readPolicy.middleware(), func(ginCon *gin.Context) {
+        queries++
+        ginCon.JSON(http.StatusOK, []Document{})
+    })...)
+
+    var codes []int
+
+    for i := 0; i < 2; i++ {
+        recorder := httptest.NewRecorder()
+        router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/documents?limit=7", nil))
+        codes = append(codes, recorder.Code)
+    }
+
+    if codes[0] != http.StatusOK || codes[1] != http.StatusTooManyRequests || queries != 1 {
+        t.Errorf("two identical requests beyond a limit of 1 are answered %v after %d queries", codes, queries)
+    }
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+    "context"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+    "go.mongodb.org/mongo-driver/mongo/readpref"
+    "sync"
+    "testing"
+    "time"
+)
+
+/* tests and benchmarks of database operations run against a MongoDB of their own, a
+replica set since writes use transactions, e.g. a single node started with --replSet.
+They are skipped when none answers */
+var testDatabaseURI string = getEnv("PRECISELY_TEST_MONGODB_URI", "mongodb://localhost:27017/?directConnection=true")
+
+const testDatabaseName = "precisely-test"
+
+var testClientOnce sync.Once
+var testClient *mongo.Client
+var testClientErr error
+
+//connect once per run, with a short timeout, so a missing database costs little
+func connectTestClient() (*mongo.Client, error) {
+    testClientOnce.Do(func() {
+        ctx, cancel := context.WithTimeout(context.Background(), 2 * time.Second)
+        defer cancel()
+
+        testClient, testClientErr = mongo.Connect(ctx, options.Client().ApplyURI(testDatabaseURI).SetRegistry(documentRegistry))
+
+        if testClientErr == nil {
+            testClientErr = testClient.Ping(ctx, readpref.Primary())
+        }
+    })
+
+    return testClient, testClientErr
+}
+
+//point the database operations at an empty test database, dropped again at the end
+func useTestDatabase(tb testing.TB) {
+    client, connectErr := connectTestClient()
+
+    if connectErr != nil {
+        tb.Skip("no MongoDB at " + testDatabaseURI + ", set PRECISELY_TEST_MONGODB_URI: " + connectErr.Error())
+    }
+
+    savedName, savedClient := databaseName, currentMongoClient.Load()
+    databaseName = testDatabaseName
+    currentMongoClient.Store(client)
+    dropTestDatabase(tb, client)
+
+    tb.Cleanup(func() {
+        dropTestDatabase(tb, client)
+        databaseName = savedName
+
+        if savedClient != nil {
+            currentMongoClient.Store(savedClient)
+        }
+    })
+
+    initDocumentIds()
+    initReferences()
+    initDuplicates()
+    initHistory()
+    initOutbox()
+}
+
+func dropTestDatabase(tb testing.TB, client *mongo.Client) {
+    if dropErr := client.Database(testDatabaseName).Drop(context.Background()); dropErr != nil {
+        tb.Fatal(dropErr)
+    }
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "github.com/gin-gonic/gin"
+    "io/ioutil"
+    "mime"
+    "net/http"
+    "strings"
+    "unicode"
+)
+
+/* json field names are camelCase, as declared on the types. A deployment can switch
+to snake_case or PascalCase, and a single request can ask for one with the naming
+parameter of its Accept header, e.g. "Accept: application/json; naming=snake_case".
+Request bodies are expected in the same naming as responses. Keys under metadata
+are chosen by clients and never renamed */
+const (
+    CamelCase  = "camelCase"
+    SnakeCase  = "snake_case"
+    PascalCase = "PascalCase"
+)
+
+var defaultNaming string = getEnv("PRECISELY_JSON_NAMING", CamelCase)
+
+//objects holding client chosen keys
+var verbatimKeys = map[string]bool{"metadata": true}
+
+func isValidNaming(naming string) bool {
+    return naming == CamelCase || naming == SnakeCase || naming == PascalCase
+}
+
+//naming asked for by the Accept header of the request, falling back to the deployment default
+func requestNaming(ginCon *gin.Context) string {
+    for _, accepted := range strings.Split(ginCon.GetHeader("Accept"), ",") {
+        _, params, parseErr := mime.ParseMediaType(strings.TrimSpace(accepted))
+
+        if parseErr == nil && isValidNaming(params["naming"]) {
+            return params["naming"]
+        }
+    }
+
+    return defaultNaming
+}
+
+func camelToSnake(name string) string {
+    var snake strings.Builder
+
+    for _, char := range name {
+        if unicode.IsUpper(char) {
+            snake.WriteRune('_')
+            char = unicode.ToLower(char)
+        }
+
+        snake.WriteRune(char)
+    }
+
+    return snake.String()
+}
+
+func snakeToCamel(name string) string {
+    var camel strings.Builder
+    upper := false
+
+    for _, char := range name {
+        if char == '_' {
+            upper = true
+            continue
+        }
+
+        if upper {
+            char = unicode.ToUpper(char)
+            upper = false
+        }
+
+        camel.WriteRune(char)
+    }
+
+    return camel.String()
+}
+
+//change the case of the first letter only
+func withFirst(name string, change func(rune) rune) string {
+    if name == "" {
+        return name
+    }
+
+    runes := []rune(name)
+    runes[0] = change(runes[0])
+    return string(runes)
+}
+
+//renamings from camelCase to a naming, and back
+func namingFunctions(naming string) (func(string) string, func(string) string) {
+    switch naming {
+    case SnakeCase:
+      return camelToSnake, snakeToCamel
+    case PascalCase:
+      return func(name string) string { return withFirst(name, unicode.ToUpper) },
+             func(name string) string { return withFirst(name, unicode.ToLower) }
+    default:
+      return nil, nil
+    }
+}
+
+func renameKeys(value interface{}, rename func(string) string) interface{} {
+    switch typedValue := value.(type) {
+    case map[string]interface{}:
+      renamed := make(map[string]interface{}, len(typedValue))
+
+      for key, child := range typedValue {
+          if verbatimKeys[key] || verbatimKeys[rename(key)] {
+              renamed[rename(key)] = child
+          } else {
+              renamed[rename(key)] = renameKeys(child, rename)
+          }
+      }
+
+      return renamed
+    case []interface{}:
+      for i, child := range typedValue {
+          typedValue[i] = renameKeys(child, rename)
+      }
+
+      return typedValue
+    default:
+      return value
+    }
+}
+
+//serialized json as maps and slices, keeping numbers exactly as they are
+func decodeJsonValue(serial []byte) (interface{}, error) {
+    decoder := json.NewDecoder(bytes.NewReader(serial))
+    decoder.UseNumber()
+    var value interface{}
+    return value, decoder.Decode(&value)
+}
+
+//rename the keys of serialized json
+func renameJsonKeys(serial []byte, rename func(string) string) ([]byte, error) {
+    value, decodeErr := decodeJsonValue(serial)
+
+    if decodeErr != nil {
+        return nil, decodeErr
+    }
+
+    return json.Marshal(renameKeys(value, rename))
+}
+
+/* a response object in the naming of the request. Objects that can not be renamed
+are returned as they are, so serializing them reports the error as usual */
+func inRequestNaming(ginCon *gin.Context, jsonObj interface{}) interface{} {
+    toNaming, _ := namingFunctions(requestNaming(ginCon))
+
+    if toNaming == nil {
+        return jsonObj
+    }
+
+    serial, serialErr := json.Marshal(jsonObj)
+
+    if serialErr != nil {
+        return jsonObj
+    }
+
+    renamed, renameErr := renameJsonKeys(serial, toNaming)
+
+    if renameErr != nil {
+        return jsonObj
+    }
+
+    return json.RawMessage(renamed)
+}
+
+/* middleware renaming the keys of a json request body to camelCase, so binding
+works the same for every naming. Bodies declared as another type, like the text
+compared with a document, are left alone, as are malformed ones. The body is read
+whole, so this runs after limitBody, for routes accepting a body */
+func translateRequestNaming(ginCon *gin.Context) {
+    _, fromNaming := namingFunctions(requestNaming(ginCon))
+    contentType := ginCon.ContentType()
+
+    if fromNaming == nil || ginCon.Request.ContentLength == 0 || (contentType != "" && contentType != gin.MIMEJSON) {
+        ginCon.Next()
+        return
+    }
+
+    body, readErr := ioutil.ReadAll(ginCon.Request.Body)
+
+    //a body without a length beyond the limit, see limitBody
+    if readErr != nil {
+        sendJsonHttpResponse(ginCon, http.StatusRequestEntityTooLarge, HttpError{CodePayloadTooLarge, "request body exceeds the limit of " + ginCon.Request.Method + " " + ginCon.FullPath()})
+        ginCon.Abort()
+        return
+    }
+
+    if renamed, renameErr := renameJsonKeys(body, fromNaming); renameErr == nil {
+        body = renamed
+    }
+
+    ginCon.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+    ginCon.Next()
+}
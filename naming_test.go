@@ -0,0 +1,123 @@
+package main
+
+import (
+    "bytes"
+    "github.com/gin-gonic/gin"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+)
+
+func TestNamingFunctions(t *testing.T) {
+    tests := []struct {
+        naming  string
+        name    string
+        renamed string
+    }{
+        {SnakeCase, "legalHold", "legal_hold"},
+        {SnakeCase, "id", "id"},
+        {SnakeCase, "policyWarnings", "policy_warnings"},
+        {PascalCase, "legalHold", "LegalHold"},
+        {PascalCase, "id", "Id"},
+    }
+
+    for _, test := range tests {
+        toNaming, fromNaming := namingFunctions(test.naming)
+
+        if renamed := toNaming(test.name); renamed != test.renamed {
+            t.Errorf("%s of %s is %s, expected %s", test.naming, test.name, renamed, test.renamed)
+        }
+
+        if name := fromNaming(test.renamed); name != test.name {
+            t.Errorf("%s back from %s is %s, expected %s", test.naming, test.renamed, name, test.name)
+        }
+    }
+
+    if toNaming, fromNaming := namingFunctions(CamelCase); toNaming != nil || fromNaming != nil {
+        t.Error("camelCase, the declared naming, should need no renaming")
+    }
+}
+
+func TestRenameJsonKeys(t *testing.T) {
+    toNaming, _ := namingFunctions(SnakeCase)
+    renamed, renameErr := renameJsonKeys([]byte(`{"legalHold":true,"metadata":{"caseNumber":"1"},"references":[{"externalId":"A"}],"revision":12345678901234567890}`), toNaming)
+
+    if renameErr != nil {
+        t.Fatal(renameErr)
+    }
+
+    expected := `{"legal_hold":true,"metadata":{"caseNumber":"1"},"references":[{"external_id":"A"}],"revision":12345678901234567890}`
+
+    if string(renamed) != expected {
+        t.Errorf("renamed to %s, expected %s", renamed, expected)
+    }
+}
+
+func TestRequestNaming(t *testing.T) {
+    tests := map[string]string{
+        "":                                               defaultNaming,
+        "application/json":                               defaultNaming,
+        "application/json; naming=snake_case":            SnakeCase,
+        "text/html, application/json;naming=PascalCase":  PascalCase,
+        "application/json; naming=kebab-case":            defaultNaming,
+    }
+
+    for accept, naming := range tests {
+        ginCon, _ := testContext(http.MethodGet, "/documents", nil)
+        ginCon.Request.Header.Set("Accept", accept)
+
+        if requested := requestNaming(ginCon); requested != naming {
+            t.Errorf("Accept %q asks for %s, expected %s", accept, requested, naming)
+        }
+    }
+}
+
+//a body without a length, as sent chunked
+type unsizedReader struct {
+    io.Reader
+}
+
+func TestTranslateRequestNaming(t *testing.T) {
+    var bound Document
+    router := gin.New()
+    router.Handle(http.MethodPost, "/documents", append(routePolicy{bodyLimit: 1024}.middleware(), func(ginCon *gin.Context) {
+        bound = Document{}
+
+        if bindErr := ginCon.BindJSON(&bound); bindErr == nil {
+            ginCon.Status(http.StatusCreated)
+        }
+    })...)
+
+    post := func(body io.Reader, contentLength int64) *httptest.ResponseRecorder {
+        request := httptest.NewRequest(http.MethodPost, "/documents", body)
+        request.ContentLength = contentLength
+        request.Header.Set("Content-Type", gin.MIMEJSON)
+        request.Header.Set("Accept", "application/json; naming=snake_case")
+        recorder := httptest.NewRecorder()
+        router.ServeHTTP(recorder, request)
+        return recorder
+    }
+
+    body := `{"title":"Lease","legal_hold":true,"metadata":{"case_number":"1"}}`
+
+    if recorder := post(strings.NewReader(body), int64(len(body))); recorder.Code != http.StatusCreated {
+        t.Fatalf("snake_case body answered %d", recorder.Code)
+    }
+
+    if bound.LegalHold == nil || !*bound.LegalHold || bound.Metadata["case_number"] != "1" {
+        t.Errorf("snake_case body bound as %+v", bound)
+    }
+
+    //the limit holds for bodies not telling their length too
+    large := `{"title":"` + strings.Repeat("a", 2048) + `"}`
+
+    if recorder := post(unsizedReader{strings.NewReader(large)}, -1); recorder.Code != http.StatusRequestEntityTooLarge {
+        t.Errorf("unsized body beyond the limit answered %d", recorder.Code)
+    }
+
+    if recorder := post(bytes.NewReader([]byte(large)), int64(len(large))); recorder.Code != http.StatusRequestEntityTooLarge {
+        t.Errorf("sized body beyond the limit answered %d", recorder.Code)
+    }
+}
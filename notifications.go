@@ -0,0 +1,204 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "errors"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+)
+
+//a write to a document, as told to notification channels
+type DocumentEvent struct {
+    Operation   string        `json:"operation"` //one of the operations recorded in the history
+    DocumentID  int           `json:"documentId"`
+    Document    *Document     `json:"document,omitempty"` //state after the write, nil for deletes
+    At          time.Time     `json:"at"`
+    TraceParent string        `json:"traceParent,omitempty"` //W3C trace context of the request making the write
+    Changes     []FieldChange `json:"changes,omitempty"`     //fields changed by an update, see changes.go
+}
+
+//destination for document event notifications and alerts, e.g. a chat channel
+type NotificationChannel interface {
+    Name() string
+    Notify(event DocumentEvent) error
+    Alert(message string) error
+}
+
+//incoming webhook urls of the chat channels to notify. Channels without url are not used
+var slackWebhookURL string = getEnv("PRECISELY_SLACK_WEBHOOK_URL", "")
+var teamsWebhookURL string = getEnv("PRECISELY_TEAMS_WEBHOOK_URL", "")
+
+//comma separated operations that are notified
+var notifiedOperations string = getEnv("PRECISELY_NOTIFY_OPERATIONS", "create,update,delete,legalHold,linkCheck")
+
+var notificationClient = &http.Client{Timeout: 10 * time.Second}
+
+//channels built from configuration, see initNotificationChannels
+var notificationChannels []NotificationChannel
+
+func getNotificationChannel(name string) NotificationChannel {
+    for _, channel := range notificationChannels {
+        if channel.Name() == name {
+            return channel
+        }
+    }
+
+    return nil
+}
+
+func initNotificationChannels() {
+    if slackWebhookURL != "" {
+        notificationChannels = append(notificationChannels, slackChannel{slackWebhookURL})
+    }
+
+    if teamsWebhookURL != "" {
+        notificationChannels = append(notificationChannels, teamsChannel{teamsWebhookURL})
+    }
+}
+
+func init() {
+    initNotificationChannels()
+}
+
+func isNotifiedOperation(operation string) bool {
+    for _, notified := range strings.Split(notifiedOperations, ",") {
+        if strings.TrimSpace(notified) == operation {
+            return true
+        }
+    }
+
+    return false
+}
+
+//one line summary of an event, e.g. `Document 7 "A first contract" was updated`
+func describeEvent(event DocumentEvent) string {
+    description := "Document " + strconv.Itoa(event.DocumentID)
+
+    if event.Document != nil && event.Document.Title != nil {
+        description += " \"" + *event.Document.Title + "\""
+    }
+
+    switch event.Operation {
+    case CreateOperation:
+      return description + " was created"
+    case UpdateOperation:
+      if event.Document != nil && event.Document.Revision != nil {
+        description += " was updated to revision " + strconv.Itoa(*event.Document.Revision)
+      } else {
+        description += " was updated"
+      }
+
+      if event.Document != nil && event.Document.ChangeNote != nil {
+        description += ": " + *event.Document.ChangeNote
+      }
+
+      if len(event.Changes) > 0 {
+        description += " (" + describeChanges(event.Changes) + ")"
+      }
+
+      return description
+    case DeleteOperation:
+      return description + " was deleted"
+    case LegalHoldOperation:
+      if event.Document != nil && event.Document.LegalHold != nil && *event.Document.LegalHold {
+        return description + " was placed under legal hold"
+      }
+
+      return description + " was released from legal hold"
+    case LinkCheckOperation:
+      broken := 0
+
+      if event.Document != nil {
+          for _, link := range event.Document.Links {
+              if link.Status == LinkBroken {
+                  broken++
+              }
+          }
+      }
+
+      return description + " has " + strconv.Itoa(broken) + " broken link(s)"
+    default:
+      return description + ": " + event.Operation
+    }
+}
+
+//post payload to url, continuing the given trace, if any
+func postJson(url string, traceParent string, payload interface{}) error {
+    serialPayload, serialErr := json.Marshal(payload)
+
+    if serialErr != nil {
+        return serialErr
+    }
+
+    request, requestErr := http.NewRequest(http.MethodPost, url, bytes.NewReader(serialPayload))
+
+    if requestErr != nil {
+        return requestErr
+    }
+
+    request.Header.Set("Content-Type", "application/json")
+
+    if childParent := childTraceParent(traceParent); childParent != "" {
+        request.Header.Set("traceparent", childParent)
+    }
+
+    response, postErr := notificationClient.Do(request)
+
+    if postErr != nil {
+        return postErr
+    }
+
+    response.Body.Close()
+
+    if response.StatusCode >= 300 {
+        return errors.New("webhook responded " + response.Status)
+    }
+
+    return nil
+}
+
+//posts to a Slack incoming webhook
+type slackChannel struct {
+    webhookURL string
+}
+
+func (channel slackChannel) Name() string {
+    return "Slack"
+}
+
+func (channel slackChannel) Notify(event DocumentEvent) error {
+    return postJson(channel.webhookURL, event.TraceParent, map[string]string{"text": describeEvent(event)})
+}
+
+func (channel slackChannel) Alert(message string) error {
+    return postJson(channel.webhookURL, "", map[string]string{"text": message})
+}
+
+//posts to a Microsoft Teams incoming webhook, using the legacy MessageCard format it accepts
+type teamsChannel struct {
+    webhookURL string
+}
+
+func (channel teamsChannel) Name() string {
+    return "Teams"
+}
+
+func (channel teamsChannel) Notify(event DocumentEvent) error {
+    return channel.post(event.TraceParent, describeEvent(event))
+}
+
+func (channel teamsChannel) Alert(message string) error {
+    return channel.post("", message)
+}
+
+func (channel teamsChannel) post(traceParent string, text string) error {
+    return postJson(channel.webhookURL, traceParent, map[string]string{
+        "@type":    "MessageCard",
+        "@context": "https://schema.org/extensions",
+        "summary":  text,
+        "text":     text,
+    })
+}
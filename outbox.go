@@ -0,0 +1,185 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+    "log"
+    "time"
+)
+
+/* events are not delivered while handling the request that caused them. Instead
+they are written to the outbox collection in the same transaction as the document
+write, and a dispatcher delivers them in the background. An event is therefore
+never lost when the process crashes, nor sent for a write that was rolled back */
+var outboxCollectionName string = "precisely-outbox"
+
+func outboxCollection() *mongo.Collection {
+    return getMongoClient().Database(databaseName).Collection(outboxCollectionName)
+}
+
+//how often the dispatcher looks for events to deliver
+var outboxPollInterval time.Duration = time.Duration(getEnvInt("PRECISELY_OUTBOX_POLL_MILLIS", 1000)) * time.Millisecond
+
+//time a dispatcher has to deliver a claimed event before another attempt may claim it
+const outboxDeliveryLease = time.Minute
+
+//failed deliveries are retried with exponential backoff, up to this delay between attempts
+const maxOutboxBackoff = time.Hour
+
+var errUnknownChannel = errors.New("channel is no longer configured")
+
+//an event waiting to be delivered to one notification channel
+type OutboxEntry struct {
+    ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+    Channel       string             `json:"channel"`
+    Event         DocumentEvent      `json:"event"`
+    Delivered     bool               `json:"delivered"`
+    Attempts      int                `json:"attempts"`
+    NextAttemptAt time.Time          `json:"nextAttemptAt"`
+    LastError     string             `json:"lastError,omitempty"`
+    CreatedAt     time.Time          `json:"createdAt"`
+}
+
+func initOutbox() {
+    ctx, cancel := context.WithTimeout(context.Background(), 10 * time.Second)
+    defer cancel()
+
+    _, indexErr := outboxCollection().Indexes().CreateOne(ctx, mongo.IndexModel{
+        Keys: bson.D{{Key: "delivered", Value: 1}, {Key: "nextattemptat", Value: 1}},
+    })
+
+    if indexErr != nil {
+        log.Print("Error creating index of outbox collection: ", indexErr)
+    }
+}
+
+/* queue an event for every channel notified of its operation. ctx is the session
+of the transaction making the document write */
+func enqueueEvent(ctx context.Context, event DocumentEvent) error {
+    if !isNotifiedOperation(event.Operation) {
+        return nil
+    }
+
+    var entries []interface{}
+
+    for _, channel := range notificationChannels {
+        entries = append(entries, OutboxEntry{Channel: channel.Name(), Event: event,
+                                              NextAttemptAt: event.At, CreatedAt: event.At})
+    }
+
+    if len(entries) == 0 {
+        return nil
+    }
+
+    _, insertErr := outboxCollection().InsertMany(ctx, entries)
+    return insertErr
+}
+
+/* claim the oldest due entry. Claiming pushes its next attempt a lease into the
+future, so if this dispatcher dies mid-delivery the entry is picked up again later */
+func claimOutboxEntry(now time.Time) (*OutboxEntry, error) {
+    filter := bson.D{
+        {Key: "delivered", Value: false},
+        {Key: "nextattemptat", Value: bson.D{{Key: "$lte", Value: now}}},
+    }
+    update := bson.D{
+        {Key: "$set", Value: bson.D{{Key: "nextattemptat", Value: now.Add(outboxDeliveryLease)}}},
+        {Key: "$inc", Value: bson.D{{Key: "attempts", Value: 1}}},
+    }
+    opts := options.FindOneAndUpdate().
+        SetSort(bson.D{{Key: "nextattemptat", Value: 1}}).
+        SetReturnDocument(options.After)
+
+    var entry OutboxEntry
+    claimErr := outboxCollection().FindOneAndUpdate(context.TODO(), filter, update, opts).Decode(&entry)
+
+    if claimErr == mongo.ErrNoDocuments {
+        return nil, nil
+    }
+
+    if claimErr != nil {
+        return nil, claimErr
+    }
+
+    return &entry, nil
+}
+
+func outboxBackoff(attempts int) time.Duration {
+    backoff := time.Second
+
+    for i := 1; i < attempts && backoff < maxOutboxBackoff; i++ {
+        backoff *= 2
+    }
+
+    if backoff > maxOutboxBackoff {
+        return maxOutboxBackoff
+    }
+
+    return backoff
+}
+
+//deliver a claimed entry and record the outcome
+func deliverOutboxEntry(entry OutboxEntry) {
+    var update bson.D
+    channel := getNotificationChannel(entry.Channel)
+    var deliveryErr error
+
+    if channel == nil {
+        deliveryErr = errUnknownChannel
+    } else {
+        deliveryErr = channel.Notify(entry.Event)
+    }
+
+    if deliveryErr == nil {
+        update = bson.D{{Key: "$set", Value: bson.D{{Key: "delivered", Value: true}}}}
+    } else if entry.Attempts >= maxOutboxAttempts.Get() {
+        log.Print("Giving up delivering event of document ", entry.Event.DocumentID, " to ", entry.Channel, ": ", deliveryErr)
+        entry.LastError = deliveryErr.Error()
+
+        if moveErr := moveToDeadLetters(entry); moveErr != nil {
+            log.Print("Error moving outbox entry ", entry.ID.Hex(), " to dead letters: ", moveErr)
+        }
+
+        return
+    } else {
+        log.Print("Error delivering event of document ", entry.Event.DocumentID, " to ", entry.Channel, ": ", deliveryErr)
+        update = bson.D{{Key: "$set", Value: bson.D{
+            {Key: "nextattemptat", Value: time.Now().UTC().Add(outboxBackoff(entry.Attempts))},
+            {Key: "lasterror", Value: deliveryErr.Error()},
+        }}}
+    }
+
+    _, updateErr := outboxCollection().UpdateOne(context.TODO(), bson.D{{Key: "_id", Value: entry.ID}}, update)
+
+    if updateErr != nil {
+        log.Print("Error recording delivery of outbox entry ", entry.ID.Hex(), ": ", updateErr)
+    }
+}
+
+//deliver all due entries, as long as this instance remains the dispatcher
+func dispatchOutbox(renewLease func() bool) {
+    for renewLease() {
+        entry, claimErr := claimOutboxEntry(time.Now().UTC())
+
+        if claimErr != nil {
+            log.Print("Error reading outbox: ", claimErr)
+            return
+        }
+
+        if entry == nil {
+            return
+        }
+
+        deliverOutboxEntry(*entry)
+    }
+}
+
+/* deliver due entries, then wait for more. One instance dispatches at a time, which
+keeps events in order. Runs for the lifetime of the process */
+func runOutboxDispatcher() {
+    runWithLease("outbox-dispatcher", outboxPollInterval, dispatchOutbox)
+}
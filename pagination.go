@@ -0,0 +1,122 @@
+package main
+
+import (
+    "encoding/base64"
+    "encoding/json"
+    "errors"
+)
+
+/* position in a sorted listing, pointing just after the last document of a
+page. Since it holds the values of the last document rather than a row count,
+pages stay stable while documents are inserted or deleted between requests */
+type ListCursor struct {
+    SortValue interface{} `json:"k"`
+    ID        int         `json:"id"`
+    Sort      string      `json:"s,omitempty"` //sort of the listing, see ListQuery.sortKey
+}
+
+//parameters of a list query, as given by the client
+type ListQuery struct {
+    ReadPreference string
+    Limit          int
+    After          *ListCursor //nil for the first page
+    Skip           int         //documents skipped before the page, when paging by offset
+    Metadata       map[string]string //only documents holding all of these metadata values
+    Classification string            //only documents of this label, if set
+    Pii            string            //only documents holding this kind of personal data, if set
+    Signee         string            //only documents of this signee, if set
+    Sort           string            //stored field to sort by, id if empty, see sortableFields
+    Descending     bool
+    Fields         []string          //stored fields to read, all if empty, see fields.go
+}
+
+//fields listings can be sorted by, as named by clients, with their stored names. Clients name them as ListParams.Sort allows
+var sortableFields = map[string]string{"id": "id", "title": "title", "signee": "signee", "revision": "revision"}
+
+func (query ListQuery) sortField() string {
+    if query.Sort == "" {
+        return "id"
+    }
+
+    return query.Sort
+}
+
+//identifies the sort of a listing, so a cursor is not used with another sort. Empty for ascending id
+func (query ListQuery) sortKey() string {
+    key := ""
+
+    if query.sortField() != "id" {
+        key = query.sortField()
+    }
+
+    if query.Descending {
+        key = "-" + query.sortField()
+    }
+
+    return key
+}
+
+//cursors are handed to clients as opaque url-safe tokens
+func encodeListCursor(cursor ListCursor) (string, error) {
+    serialCursor, serialErr := json.Marshal(cursor)
+
+    if serialErr != nil {
+        return "", serialErr
+    }
+
+    return base64.RawURLEncoding.EncodeToString(serialCursor), nil
+}
+
+func decodeListCursor(token string) (*ListCursor, error) {
+    serialCursor, decodeErr := base64.RawURLEncoding.DecodeString(token)
+
+    if decodeErr != nil {
+        return nil, decodeErr
+    }
+
+    var cursor ListCursor
+
+    if unmarshalErr := json.Unmarshal(serialCursor, &cursor); unmarshalErr != nil {
+        return nil, unmarshalErr
+    }
+
+    //the sort value is placed into the query as it is, so an object could smuggle in operators like $ne
+    switch cursor.SortValue.(type) {
+    case nil, string, float64, bool:
+    default:
+      return nil, errCursorSortValue
+    }
+
+    return &cursor, nil
+}
+
+var errCursorSortValue = errors.New("sort value of cursor is not a string, number, boolean or null")
+
+//cursor pointing after the given document, the last one of a page of query
+func cursorAfter(document Document, query ListQuery) ListCursor {
+    cursor := ListCursor{SortValue: *document.ID, ID: *document.ID, Sort: query.sortKey()}
+
+    switch query.sortField() {
+    case "title":
+      cursor.SortValue = stringSortValue(document.Title)
+    case "signee":
+      cursor.SortValue = stringSortValue(document.Signee)
+    case "revision":
+      cursor.SortValue = nil
+
+      if document.Revision != nil {
+          cursor.SortValue = *document.Revision
+      }
+    }
+
+    return cursor
+}
+
+//the sort value of an optional field, nil if it is not set
+func stringSortValue(value *string) interface{} {
+    if value == nil {
+        return nil
+    }
+
+    return *value
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+    "encoding/base64"
+    "encoding/json"
+    "net/http"
+    "strings"
+    "testing"
+)
+
+func TestListCursorRoundTrip(t *testing.T) {
+    tests := []struct {
+        document Document
+        query    ListQuery
+    }{
+        {Document{ID: intOf(3)}, ListQuery{}},
+        {Document{ID: intOf(3)}, ListQuery{Descending: true}},
+        {Document{ID: intOf(3), Title: stringOf("Lease")}, ListQuery{Sort: "title"}},
+        {Document{ID: intOf(3)}, ListQuery{Sort: "signee", Descending: true}},
+        {Document{ID: intOf(3), Revision: intOf(4)}, ListQuery{Sort: "revision"}},
+    }
+
+    for _, test := range tests {
+        cursor := cursorAfter(test.document, test.query)
+        token, encodeErr := encodeListCursor(cursor)
+
+        if encodeErr != nil {
+            t.Fatal(encodeErr)
+        }
+
+        decoded, decodeErr := decodeListCursor(token)
+
+        if decodeErr != nil {
+            t.Fatalf("cursor %+v: %v", cursor, decodeErr)
+        }
+
+        if decoded.ID != *test.document.ID || decoded.Sort != test.query.sortKey() {
+            t.Errorf("cursor %+v decoded as %+v", cursor, *decoded)
+        }
+
+        //numbers come back as float64 from json
+        if number, isInt := cursor.SortValue.(int); isInt {
+            if decoded.SortValue != float64(number) {
+                t.Errorf("sort value %v decoded as %#v", number, decoded.SortValue)
+            }
+        } else if decoded.SortValue != cursor.SortValue {
+            t.Errorf("sort value %#v decoded as %#v", cursor.SortValue, decoded.SortValue)
+        }
+    }
+}
+
+func TestDecodeListCursorRejects(t *testing.T) {
+    tests := map[string]string{
+        "not base64":        "!!!",
+        "not json":          base64.RawURLEncoding.EncodeToString([]byte("lease")),
+        "operator":          base64.RawURLEncoding.EncodeToString([]byte(`{"k":{"$ne":null},"id":1,"s":"title"}`)),
+        "array":             base64.RawURLEncoding.EncodeToString([]byte(`{"k":["a"],"id":1,"s":"title"}`)),
+        "id not a number":   base64.RawURLEncoding.EncodeToString([]byte(`{"k":"a","id":"1","s":"title"}`)),
+    }
+
+    for name, token := range tests {
+        if cursor, decodeErr := decodeListCursor(token); decodeErr == nil {
+            t.Errorf("%s: decoded as %+v", name, *cursor)
+        }
+    }
+}
+
+func TestListQuerySortKey(t *testing.T) {
+    tests := []struct {
+        query ListQuery
+        key   string
+    }{
+        {ListQuery{}, ""},
+        {ListQuery{Sort: "id"}, ""},
+        {ListQuery{Descending: true}, "-id"},
+        {ListQuery{Sort: "title"}, "title"},
+        {ListQuery{Sort: "title", Descending: true}, "-title"},
+    }
+
+    for _, test := range tests {
+        if key := test.query.sortKey(); key != test.key {
+            t.Errorf("sort key of %+v is %q, expected %q", test.query, key, test.key)
+        }
+    }
+}
+
+func TestListOffsetRejectsCursor(t *testing.T) {
+    cursor, _ := encodeListCursor(cursorAfter(Document{ID: intOf(3)}, ListQuery{}))
+    ginCon, recorder := testContext(http.MethodGet, "/documents?offset=2&cursor=" + cursor, nil)
+    handleGetDocuments(ginCon)
+
+    if recorder.Code != http.StatusBadRequest || !strings.Contains(recorder.Body.String(), "offset and cursor can not be combined") {
+        t.Errorf("offset with a cursor is answered with %d: %s", recorder.Code, recorder.Body.String())
+    }
+}
+
+//pages by offset, answered in an envelope with the total
+func TestListOffsetEnvelope(t *testing.T) {
+    useTestDatabase(t)
+    ids := createTestDocuments(t, 5)
+    ginCon, recorder := testContext(http.MethodGet, "/documents?limit=2&offset=2&envelope=true&includeTotal=true", nil)
+    handleGetDocuments(ginCon)
+
+    if recorder.Code != http.StatusOK {
+        t.Fatalf("listing is answered with %d: %s", recorder.Code, recorder.Body.String())
+    }
+
+    var response ListResponse
+
+    if decodeErr := json.Unmarshal(recorder.Body.Bytes(), &response); decodeErr != nil {
+        t.Fatal(decodeErr)
+    }
+
+    if len(response.Documents) != 2 || *response.Documents[0].ID != ids[2] || *response.Documents[1].ID != ids[3] {
+        t.Errorf("offset 2 lists %+v, expected documents %d and %d", response.Documents, ids[2], ids[3])
+    }
+
+    if response.Total == nil || *response.Total != 5 {
+        t.Errorf("total is %v, expected 5", response.Total)
+    }
+}
+
+//the cursor to the next page is answered in the envelope as well, in the naming asked for
+func TestListEnvelopeNextCursor(t *testing.T) {
+    useTestDatabase(t)
+    ids := createTestDocuments(t, 3)
+    list := func(query string) (map[string]interface{}, string) {
+        ginCon, recorder := testContext(http.MethodGet, "/documents?limit=2&envelope=true" + query, nil)
+        ginCon.Request.Header.Set("Accept", "application/json; naming=snake_case")
+        handleGetDocuments(ginCon)
+
+        if recorder.Code != http.StatusOK {
+            t.Fatalf("listing is answered with %d: %s", recorder.Code, recorder.Body.String())
+        }
+
+        var response map[string]interface{}
+
+        if decodeErr := json.Unmarshal(recorder.Body.Bytes(), &response); decodeErr != nil {
+            t.Fatal(decodeErr)
+        }
+
+        return response, recorder.Header().Get("X-Next-Cursor")
+    }
+
+    first, header := list("")
+    nextCursor, _ := first["next_cursor"].(string)
+
+    if nextCursor == "" || nextCursor != header {
+        t.Fatalf("full page answers next_cursor %q and X-Next-Cursor %q", nextCursor, header)
+    }
+
+    last, header := list("&cursor=" + nextCursor)
+    documents, _ := last["documents"].([]interface{})
+
+    if len(documents) != 1 || documents[0].(map[string]interface{})["id"] != float64(ids[2]) {
+        t.Errorf("page after the cursor lists %v, expected document %d", documents, ids[2])
+    }
+
+    if _, hasCursor := last["next_cursor"]; hasCursor || header != "" {
+        t.Errorf("last page answers a cursor: %v, X-Next-Cursor %q", last, header)
+    }
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+    "github.com/gin-gonic/gin"
+    "reflect"
+    "strconv"
+    "strings"
+    "time"
+    "unicode/utf8"
+)
+
+/* query parameters are bound to the fields of a struct by tags, so every endpoint
+validates them alike and answers bad ones with the same messages. The struct holds the
+defaults, which absent or empty parameters leave as they are:
+    query:"limit"       name of the parameter, fields without one are not bound
+    min:"1" max:"10"    bounds of a number, each a number or the name of a queryBounds entry
+    enum:"asc,desc"     values allowed for a string, matched regardless of case
+    maxLength:"500"     most characters of a string, a number or the name of a queryBounds entry
+    hex:"64"            a string of exactly so many hexadecimal digits, bound in lower case
+Strings, numbers, booleans, RFC 3339 timestamps and time zones can be bound. Fields of an
+embedded struct, like ReadParams, are bound as if they were fields of the struct itself */
+
+//bounds that are settings rather than constants, by the names used in tags
+var queryBounds = map[string]func() int{
+    "maxListSize":     maxListSize.Get,
+    "maxQRSize":       func() int { return maxQRSize },
+    "maxSigneeLength": func() int { return maxSigneeLength },
+}
+
+//bind the query parameters of the request to params, a pointer to a tagged struct, or tell what is wrong with them
+func bindQuery(ginCon *gin.Context, params interface{}) string {
+    value := reflect.ValueOf(params).Elem()
+
+    for i := 0; i < value.NumField(); i++ {
+        field := value.Type().Field(i)
+        name := field.Tag.Get("query")
+
+        if field.Anonymous && field.Type.Kind() == reflect.Struct {
+            if problem := bindQuery(ginCon, value.Field(i).Addr().Interface()); problem != "" {
+                return problem
+            }
+
+            continue
+        }
+
+        if name == "" || ginCon.Query(name) == "" {
+            continue
+        }
+
+        if problem := bindQueryParam(value.Field(i), field.Tag, name, ginCon.Query(name)); problem != "" {
+            return problem
+        }
+    }
+
+    return ""
+}
+
+func bindQueryParam(target reflect.Value, tag reflect.StructTag, name string, param string) string {
+    switch target.Interface().(type) {
+    case string:
+      enum := tag.Get("enum")
+
+      if maxLength, hasMax := queryBound(tag.Get("maxLength")); hasMax && utf8.RuneCountInString(param) > maxLength {
+          return name + " exceeds the maximum length of " + toString(maxLength) + " characters"
+      }
+
+      if digits, isHex := queryBound(tag.Get("hex")); isHex {
+          if len(param) != digits || !isHexString(param) {
+              return name + " must be " + toString(digits) + " hexadecimal digits"
+          }
+
+          param = strings.ToLower(param)
+      }
+
+      if enum == "" {
+          target.SetString(param)
+          return ""
+      }
+
+      for _, allowed := range strings.Split(enum, ",") {
+          if strings.EqualFold(param, allowed) {
+              target.SetString(allowed)
+              return ""
+          }
+      }
+
+      return name + " must be one of " + strings.ReplaceAll(enum, ",", ", ")
+    case int:
+      number, convErr := strconv.Atoi(param)
+      min, hasMin := queryBound(tag.Get("min"))
+      max, hasMax := queryBound(tag.Get("max"))
+
+      if convErr != nil || (hasMin && number < min) || (hasMax && number > max) {
+          return name + " must be a number" + describeBounds(min, hasMin, max, hasMax)
+      }
+
+      target.SetInt(int64(number))
+    case bool:
+      flag, parseErr := strconv.ParseBool(param)
+
+      if parseErr != nil {
+          return name + " must be true or false"
+      }
+
+      target.SetBool(flag)
+    case time.Time:
+      instant, timeErr := time.Parse(time.RFC3339, param)
+
+      if timeErr != nil {
+          return name + " '" + param + "' is not a RFC 3339 timestamp, like 2024-01-01T00:00:00Z"
+      }
+
+      target.Set(reflect.ValueOf(instant))
+    case *time.Location:
+      location, locationErr := time.LoadLocation(param)
+
+      if locationErr != nil {
+          return name + " '" + param + "' is not a time zone, like Europe/Stockholm"
+      }
+
+      target.Set(reflect.ValueOf(location))
+    default:
+      //tags are written along with the code, so this is a mistake of ours
+      panic("query parameter " + name + " is bound to unsupported " + target.Type().String())
+    }
+
+    return ""
+}
+
+func isHexString(param string) bool {
+    for _, char := range param {
+        if !strings.ContainsRune("0123456789abcdefABCDEF", char) {
+            return false
+        }
+    }
+
+    return true
+}
+
+//the value of a min or max tag, false if there is none
+func queryBound(bound string) (int, bool) {
+    if bound == "" {
+        return 0, false
+    }
+
+    if setting, named := queryBounds[bound]; named {
+        return setting(), true
+    }
+
+    number, convErr := strconv.Atoi(bound)
+
+    if convErr != nil {
+        panic("unknown query parameter bound " + bound)
+    }
+
+    return number, true
+}
+
+func describeBounds(min int, hasMin bool, max int, hasMax bool) string {
+    switch {
+    case hasMin && hasMax:
+      return " between " + toString(min) + " and " + toString(max)
+    case hasMin:
+      return " of at least " + toString(min)
+    case hasMax:
+      return " of at most " + toString(max)
+    }
+
+    return ""
+}
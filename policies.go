@@ -0,0 +1,174 @@
+package main
+
+import (
+    "encoding/json"
+    "io/ioutil"
+    "log"
+    "regexp"
+    "strings"
+)
+
+/* content policies catch forbidden terms, such as profanity or wording ruled out by
+legal, in the title and text content of documents. They are read at startup from the
+JSON file named by PRECISELY_CONTENT_POLICIES_FILE, holding a list like
+[{"name": "profanity", "terms": ["darn", "heck"], "severity": "block"}]. Terms match
+whole words regardless of case, patterns are regular expressions. Writes matching a
+policy of severity block are refused, matches of severity warn are noted on the
+document */
+var contentPoliciesFile string = getEnv("PRECISELY_CONTENT_POLICIES_FILE", "")
+
+const (
+    PolicyBlock = "block"
+    PolicyWarn  = "warn"
+)
+
+//matches reported per policy and field, keeping responses small for documents full of them
+const maxPolicyMatches = 10
+
+type ContentPolicy struct {
+    Name       string   `json:"name"`
+    Terms      []string `json:"terms,omitempty"`
+    Pattern    string   `json:"pattern,omitempty"`
+    Severity   string   `json:"severity"` //block or warn
+    expression *regexp.Regexp
+}
+
+type PolicyMatch struct {
+    Policy string `json:"policy"`
+    Field  string `json:"field"` //title, header or data
+    Match  string `json:"match"`
+}
+
+type PolicyError struct {
+    HttpError
+    Matches []PolicyMatch `json:"matches"`
+}
+
+var contentPolicies []ContentPolicy = loadContentPolicies()
+
+//the policies of the configured file, with their expressions compiled. Problems of malformed policies are reported, and none of the policies loaded
+func loadContentPolicies() []ContentPolicy {
+    if contentPoliciesFile == "" {
+        return nil
+    }
+
+    serialPolicies, readErr := ioutil.ReadFile(contentPoliciesFile)
+
+    if readErr != nil {
+        settingProblem("PRECISELY_CONTENT_POLICIES_FILE names a file that can not be read: " + readErr.Error())
+        return nil
+    }
+
+    var policies []ContentPolicy
+
+    if parseErr := json.Unmarshal(serialPolicies, &policies); parseErr != nil {
+        settingProblem("content policies of " + contentPoliciesFile + " are not a list of policies: " + parseErr.Error())
+        return nil
+    }
+
+    malformed := false
+
+    for i := range policies {
+        policy := &policies[i]
+
+        if policy.Name == "" || (policy.Severity != PolicyBlock && policy.Severity != PolicyWarn) {
+            settingProblem("content policy " + toString(i) + " needs a name and a severity of " + PolicyBlock + " or " + PolicyWarn)
+            malformed = true
+        }
+
+        if (len(policy.Terms) == 0) == (policy.Pattern == "") {
+            settingProblem("content policy " + policy.Name + " needs either terms or a pattern")
+            malformed = true
+            continue
+        }
+
+        pattern := policy.Pattern
+
+        if len(policy.Terms) > 0 {
+            quotedTerms := make([]string, len(policy.Terms))
+
+            for j, term := range policy.Terms {
+                quotedTerms[j] = regexp.QuoteMeta(strings.TrimSpace(term))
+            }
+
+            pattern = `(?i)\b(?:` + strings.Join(quotedTerms, "|") + `)\b`
+        }
+
+        expression, compileErr := regexp.Compile(pattern)
+
+        if compileErr != nil {
+            settingProblem("content policy " + policy.Name + " has a malformed pattern: " + compileErr.Error())
+            malformed = true
+            continue
+        }
+
+        policy.expression = expression
+    }
+
+    if malformed {
+        return nil
+    }
+
+    log.Print("Loaded ", len(policies), " content policies from ", contentPoliciesFile)
+    return policies
+}
+
+/* matches of the policies of the given severity in the fields of a document that are
+set. Binary content is not scanned */
+func policyMatches(document Document, severity string) []PolicyMatch {
+    fields := map[string]*string{"title": document.Title}
+
+    if content := document.Content; content != nil {
+        fields["header"] = content.Header
+
+        if content.Encoding == nil || *content.Encoding == TextEncoding {
+            fields["data"] = content.Data
+        }
+    }
+
+    var matches []PolicyMatch
+
+    for _, policy := range contentPolicies {
+        if policy.Severity != severity {
+            continue
+        }
+
+        //in a fixed order of fields, so the matches of the same document always compare equal
+        for _, field := range []string{"title", "header", "data"} {
+            if fields[field] == nil {
+                continue
+            }
+
+            for _, match := range policy.expression.FindAllString(*fields[field], maxPolicyMatches) {
+                matches = append(matches, PolicyMatch{policy.Name, field, match})
+            }
+        }
+    }
+
+    return matches
+}
+
+//the error refusing a document matching a blocking policy, or nil if it matches none
+func policyViolation(document Document) *PolicyError {
+    matches := policyMatches(document, PolicyBlock)
+
+    if len(matches) == 0 {
+        return nil
+    }
+
+    return &PolicyError{HttpError{CodePolicyViolation, "document matches content policy " + matches[0].Policy}, matches}
+}
+
+func sameMatches(matches []PolicyMatch, otherMatches []PolicyMatch) bool {
+    if len(matches) != len(otherMatches) {
+        return false
+    }
+
+    for i := range matches {
+        if matches[i] != otherMatches[i] {
+            return false
+        }
+    }
+
+    return true
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+    "context"
+    "flag"
+    "github.com/gin-gonic/gin"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+    "log"
+    "math"
+    "net/http"
+    "sync"
+    "time"
+)
+
+/* the fields derived from the title and content of a document, its duplicate hash,
+stats, policy warnings, personal data and the classification it forces, are computed
+when it is written. A rebuild recomputes them for every stored document, e.g. after
+content policies changed or for documents written before a field was introduced. It
+runs in the background, in batches with a pause between them so regular traffic is not
+crowded out. A rebuild is no edit: it is not notified, makes no new revision and is
+not held back by freeze windows, but every document it changes is recorded in the
+history, so the integrity check still finds it in its recorded state. Started with --rebuild-projections,
+the service rebuilds once and exits instead of serving */
+var rebuildOnly = flag.Bool("rebuild-projections", false, "recompute derived fields of all documents, then exit")
+
+var rebuildBatchSize int = getEnvInt("PRECISELY_REBUILD_BATCH_SIZE", 100)
+var rebuildPause time.Duration = time.Duration(getEnvInt("PRECISELY_REBUILD_PAUSE_MILLIS", 200)) * time.Millisecond
+
+//held while rebuilding, so only one instance rebuilds at a time
+const rebuildLeaseName = "projection-rebuild"
+
+type ProjectionRebuild struct {
+    Running    bool       `json:"running"`
+    StartedAt  *time.Time `json:"startedAt,omitempty"`
+    FinishedAt *time.Time `json:"finishedAt,omitempty"`
+    Scanned    int        `json:"scanned"` //documents read so far
+    Updated    int        `json:"updated"` //documents whose derived fields were out of date
+    Skipped    int        `json:"skipped"` //documents written while being rebuilt, already up to date
+    Error      string     `json:"error,omitempty"`
+}
+
+//progress of the last rebuild run by this instance
+var projectionRebuild ProjectionRebuild
+var projectionRebuildMutex sync.Mutex
+
+func getProjectionRebuild() ProjectionRebuild {
+    projectionRebuildMutex.Lock()
+    defer projectionRebuildMutex.Unlock()
+    return projectionRebuild
+}
+
+func updateProjectionRebuild(update func(rebuild *ProjectionRebuild)) {
+    projectionRebuildMutex.Lock()
+    defer projectionRebuildMutex.Unlock()
+    update(&projectionRebuild)
+}
+
+/* filter matching the document only while it is at the revision read. Every change of
+its title or content makes a new revision, and brings the derived fields up to date
+itself, which must not be undone */
+func unchangedRevisionFilter(document Document) bson.D {
+    var revision interface{}
+
+    if document.Revision != nil {
+        revision = *document.Revision
+    }
+
+    return bson.D{{Key: "id", Value: *document.ID}, {Key: "revision", Value: revision}}
+}
+
+//reset the progress for a new rebuild, false if this instance is rebuilding already
+func beginProjectionRebuild() bool {
+    projectionRebuildMutex.Lock()
+    defer projectionRebuildMutex.Unlock()
+
+    if projectionRebuild.Running {
+        return false
+    }
+
+    now := time.Now().UTC()
+    projectionRebuild = ProjectionRebuild{Running: true, StartedAt: &now}
+    return true
+}
+
+//recompute the derived fields of all documents, in ascending order of id. Begun with beginProjectionRebuild
+func rebuildProjections(renewLease func() bool) {
+    finish := func(problem string) {
+        finished := time.Now().UTC()
+        updateProjectionRebuild(func(rebuild *ProjectionRebuild) {
+            rebuild.Running = false
+            rebuild.FinishedAt = &finished
+            rebuild.Error = problem
+        })
+
+        if result := getProjectionRebuild(); problem == "" {
+            log.Print("Rebuilt projections: ", result.Scanned, " documents scanned, ", result.Updated, " updated, ", result.Skipped, " skipped")
+        } else {
+            log.Print("Rebuilding projections stopped after ", result.Scanned, " documents: ", problem)
+        }
+    }
+
+    lastID := math.MinInt
+    opts := options.Find().SetSort(bson.D{{Key: "id", Value: 1}}).SetLimit(int64(rebuildBatchSize))
+
+    for {
+        cursor, findErr := mongoCollection().Find(context.TODO(), bson.D{{Key: "id", Value: bson.D{{Key: "$gt", Value: lastID}}}}, opts)
+
+        if findErr != nil {
+            finish("error reading documents: " + findErr.Error())
+            return
+        }
+
+        var documents []Document
+
+        if allErr := cursor.All(context.TODO(), &documents); allErr != nil {
+            finish("error reading documents: " + allErr.Error())
+            return
+        }
+
+        if len(documents) == 0 {
+            finish("")
+            return
+        }
+
+        for _, document := range documents {
+            lastID = *document.ID
+            filter := unchangedRevisionFilter(document)
+            derivedUpdate := toDerivedUpdate(&document)
+            updated, skipped := 0, 0
+
+            if len(derivedUpdate) > 0 {
+                var rebuilt Document
+                opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+                updateErr := mongoCollection().FindOneAndUpdate(context.TODO(), filter, bson.D{{Key: "$set", Value: derivedUpdate}}, opts).Decode(&rebuilt)
+
+                switch {
+                case updateErr == mongo.ErrNoDocuments:
+                  skipped = 1
+                case updateErr != nil:
+                  finish("error updating document " + toString(lastID) + ": " + updateErr.Error())
+                  return
+                default:
+                  //recorded like any other write, so the integrity check finds the document as rebuilt
+                  recordHistory(RebuildOperation, lastID, &rebuilt)
+                  updated = 1
+                }
+            }
+
+            updateProjectionRebuild(func(rebuild *ProjectionRebuild) {
+                rebuild.Scanned++
+                rebuild.Updated += updated
+                rebuild.Skipped += skipped
+            })
+        }
+
+        if !renewLease() {
+            finish("lease " + rebuildLeaseName + " was lost")
+            return
+        }
+
+        time.Sleep(rebuildPause)
+    }
+}
+
+//start a rebuild in the background, unless one is running on any instance
+func handleRebuildProjections(ginCon *gin.Context) {
+    if !acquireLease(rebuildLeaseName) || !beginProjectionRebuild() {
+      sendJsonHttpResponse(ginCon, http.StatusConflict, HttpError{CodeRebuildRunning, "a rebuild of projections is already running"})
+      return
+    }
+
+    go rebuildProjections(func() bool {
+        return acquireLease(rebuildLeaseName)
+    })
+
+    sendJsonHttpResponse(ginCon, http.StatusAccepted, getProjectionRebuild())
+}
+
+//progress of the last rebuild started through this instance
+func handleGetProjectionRebuild(ginCon *gin.Context) {
+    sendJsonHttpResponse(ginCon, http.StatusOK, getProjectionRebuild())
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+    "regexp"
+    "testing"
+)
+
+//a rebuild changing derived fields leaves every document in its recorded state
+func TestRebuildProjectionsKeepsIntegrity(t *testing.T) {
+    useTestDatabase(t)
+
+    savedPolicies, savedPause := contentPolicies, rebuildPause
+    defer func() { contentPolicies, rebuildPause = savedPolicies, savedPause }()
+    contentPolicies, rebuildPause = nil, 0
+
+    for i := 0; i < 3; i++ {
+        document := sampleDocument()
+        document.References = nil //unique to one document
+
+        if status, _ := createDocument(document, &writeRequest{}); status != OK {
+            t.Fatalf("creating document %d: status %d", i, status)
+        }
+    }
+
+    //a policy added after the documents were written changes their policy warnings
+    contentPolicies = []ContentPolicy{{Name: "leases", Pattern: "Lease", Severity: PolicyWarn, expression: regexp.MustCompile("Lease")}}
+
+    if !beginProjectionRebuild() {
+        t.Fatal("a rebuild is running already")
+    }
+
+    rebuildProjections(func() bool { return true })
+
+    if rebuild := getProjectionRebuild(); rebuild.Error != "" || rebuild.Updated != 3 {
+        t.Fatalf("rebuild ended with %+v, expected 3 documents updated", rebuild)
+    }
+
+    status, report := verifyIntegrity()
+
+    if status != OK {
+        t.Fatalf("verifying integrity: status %d", status)
+    }
+
+    if !report.Valid || report.Documents != 3 {
+        t.Errorf("integrity after a rebuild is %+v, expected 3 documents without problems", report)
+    }
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+    "github.com/gin-gonic/gin"
+    "net"
+    "net/http"
+    "strings"
+)
+
+/* behind a load balancer the peer of every request is the balancer itself. Requests
+coming from a trusted proxy are attributed to the address that proxy saw, taken from
+X-Forwarded-For or X-Real-IP. These headers are ignored from any other peer, since
+every client can send them */
+var trustedProxies []*net.IPNet = parseTrustedProxies(getEnv("PRECISELY_TRUSTED_PROXIES", ""))
+
+//comma separated addresses or CIDR ranges, e.g. "10.0.0.0/8, 192.168.1.1". Malformed entries are left out and reported
+func parseTrustedProxies(list string) []*net.IPNet {
+    var networks []*net.IPNet
+
+    for _, entry := range strings.Split(list, ",") {
+        entry = strings.TrimSpace(entry)
+
+        if entry == "" {
+            continue
+        }
+
+        if !strings.Contains(entry, "/") {
+            if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+                entry += "/32"
+            } else {
+                entry += "/128"
+            }
+        }
+
+        _, network, parseErr := net.ParseCIDR(entry)
+
+        if parseErr != nil {
+            settingProblem("PRECISELY_TRUSTED_PROXIES holds a malformed address: " + entry)
+            continue
+        }
+
+        networks = append(networks, network)
+    }
+
+    return networks
+}
+
+func isTrustedProxy(ip net.IP) bool {
+    for _, network := range trustedProxies {
+        if network.Contains(ip) {
+            return true
+        }
+    }
+
+    return false
+}
+
+/* address of the client making the request. X-Forwarded-For is walked from the
+nearest hop backwards, skipping trusted proxies, so entries a client forged in
+front of the real ones are never reached */
+func realClientIP(request *http.Request) net.IP {
+    host, _, splitErr := net.SplitHostPort(strings.TrimSpace(request.RemoteAddr))
+
+    if splitErr != nil {
+        return nil
+    }
+
+    ip := net.ParseIP(host)
+
+    if ip == nil || !isTrustedProxy(ip) {
+        return ip
+    }
+
+    if forwardedFor := request.Header.Get("X-Forwarded-For"); forwardedFor != "" {
+        hops := strings.Split(forwardedFor, ",")
+
+        for i := len(hops) - 1; i >= 0; i-- {
+            hop := net.ParseIP(strings.TrimSpace(hops[i]))
+
+            if hop == nil { //malformed, nothing before it can be relied on
+                return ip
+            }
+
+            ip = hop
+
+            if !isTrustedProxy(hop) {
+                return hop
+            }
+        }
+
+        return ip
+    }
+
+    if realIP := net.ParseIP(strings.TrimSpace(request.Header.Get("X-Real-IP"))); realIP != nil {
+        return realIP
+    }
+
+    return ip
+}
+
+/* middleware replacing the peer address of the request by the real client address,
+so ginCon.ClientIP(), the request log and everything else see the client */
+func resolveClientAddress(ginCon *gin.Context) {
+    if ip := realClientIP(ginCon.Request); ip != nil {
+        ginCon.Request.RemoteAddr = net.JoinHostPort(ip.String(), "0")
+    }
+
+    ginCon.Next()
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+    "net"
+    "net/http/httptest"
+    "testing"
+)
+
+func TestParseTrustedProxies(t *testing.T) {
+    networks := parseTrustedProxies(" 10.0.0.0/8, 192.168.1.1 ,,::1")
+
+    if len(networks) != 3 {
+        t.Fatalf("parsed %v, expected 3 networks", networks)
+    }
+
+    for i, expected := range []string{"10.0.0.0/8", "192.168.1.1/32", "::1/128"} {
+        if networks[i].String() != expected {
+            t.Errorf("network %d is %s, expected %s", i, networks[i], expected)
+        }
+    }
+}
+
+func TestRealClientIP(t *testing.T) {
+    defer func(proxies []*net.IPNet) { trustedProxies = proxies }(trustedProxies)
+    trustedProxies = parseTrustedProxies("10.0.0.0/8")
+
+    tests := []struct {
+        name         string
+        remoteAddr   string
+        forwardedFor string
+        realIP       string
+        client       string
+    }{
+        {"direct client", "203.0.113.5:4711", "", "", "203.0.113.5"},
+        {"headers of untrusted peer ignored", "203.0.113.5:4711", "198.51.100.1", "198.51.100.2", "203.0.113.5"},
+        {"forwarded by trusted proxy", "10.0.0.2:4711", "198.51.100.1", "", "198.51.100.1"},
+        {"chain of trusted proxies", "10.0.0.2:4711", "198.51.100.1, 10.0.0.7, 10.0.0.3", "", "198.51.100.1"},
+        {"forged entry in front not reached", "10.0.0.2:4711", "192.0.2.66, 198.51.100.1", "", "198.51.100.1"},
+        {"malformed hop stops the walk", "10.0.0.2:4711", "198.51.100.1, bogus", "", "10.0.0.2"},
+        {"only trusted proxies", "10.0.0.2:4711", "10.0.0.3", "", "10.0.0.3"},
+        {"real ip of trusted proxy", "10.0.0.2:4711", "", "198.51.100.2", "198.51.100.2"},
+        {"trusted proxy without headers", "10.0.0.2:4711", "", "", "10.0.0.2"},
+    }
+
+    for _, test := range tests {
+        request := httptest.NewRequest("GET", "/documents", nil)
+        request.RemoteAddr = test.remoteAddr
+
+        if test.forwardedFor != "" {
+            request.Header.Set("X-Forwarded-For", test.forwardedFor)
+        }
+
+        if test.realIP != "" {
+            request.Header.Set("X-Real-IP", test.realIP)
+        }
+
+        if client := realClientIP(request); client.String() != test.client {
+            t.Errorf("%s: client is %s, expected %s", test.name, client, test.client)
+        }
+    }
+}
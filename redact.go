@@ -0,0 +1,95 @@
+package main
+
+import (
+    "sort"
+    "strings"
+)
+
+/* the redacted view of a document masks personal data and the matches of content
+policies, so it can be shared with parties who should not see them. Fields that may
+hold anything, such as metadata values and fields unknown to this version, are
+masked or left out as a whole */
+const RedactedView = "redacted"
+
+const redactedText = "[REDACTED]"
+
+//ranges of a text to mask, sorted and merged where they overlap
+func redactionRanges(text string) [][]int {
+    var ranges [][]int
+
+    for _, kind := range piiKinds {
+        ranges = append(ranges, piiDetectors[kind](text)...)
+    }
+
+    for _, policy := range contentPolicies {
+        ranges = append(ranges, policy.expression.FindAllStringIndex(text, -1)...)
+    }
+
+    sort.Slice(ranges, func(i, j int) bool { return ranges[i][0] < ranges[j][0] })
+    var merged [][]int
+
+    for _, textRange := range ranges {
+        if last := len(merged) - 1; last >= 0 && textRange[0] <= merged[last][1] {
+            if textRange[1] > merged[last][1] {
+                merged[last][1] = textRange[1]
+            }
+        } else {
+            merged = append(merged, []int{textRange[0], textRange[1]})
+        }
+    }
+
+    return merged
+}
+
+func redactText(text *string) *string {
+    if text == nil {
+        return nil
+    }
+
+    var redacted strings.Builder
+    redacted.Grow(len(*text))
+    end := 0
+
+    for _, textRange := range redactionRanges(*text) {
+        redacted.WriteString((*text)[end:textRange[0]])
+        redacted.WriteString(redactedText)
+        end = textRange[1]
+    }
+
+    redacted.WriteString((*text)[end:])
+    result := redacted.String()
+    return &result
+}
+
+/* a copy of a document with text content, safe to share. Policy warnings are left out,
+since they quote what they matched */
+func redactedDocument(document Document) Document {
+    redacted := Document{
+        ID:             document.ID,
+        Title:          redactText(document.Title),
+        Signee:         document.Signee,
+        LegalHold:      document.LegalHold,
+        References:     document.References,
+        Links:          document.Links,
+        Classification: document.Classification,
+        Pii:            document.Pii,
+        Stats:          document.Stats,
+    }
+
+    if document.Content != nil {
+        content := *document.Content
+        content.Header = redactText(content.Header)
+        content.Data = redactText(content.Data)
+        redacted.Content = &content
+    }
+
+    if len(document.Metadata) > 0 {
+        redacted.Metadata = make(map[string]string, len(document.Metadata))
+
+        for key := range document.Metadata {
+            redacted.Metadata[key] = redactedText
+        }
+    }
+
+    return redacted
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+    "strings"
+    "testing"
+)
+
+func TestRedactText(t *testing.T) {
+    tests := []struct {
+        text     string
+        redacted string
+    }{
+        {"", ""},
+        {"no personal data", "no personal data"},
+        {"write to jane@example.com today", "write to [REDACTED] today"},
+        {"jane@example.com", "[REDACTED]"},
+        {"a@example.com and b@example.com", "[REDACTED] and [REDACTED]"},
+    }
+
+    for _, test := range tests {
+        if redacted := *redactText(&test.text); redacted != test.redacted {
+            t.Errorf("%q is redacted to %q, expected %q", test.text, redacted, test.redacted)
+        }
+    }
+
+    if redactText(nil) != nil {
+        t.Error("a missing text is redacted to one")
+    }
+}
+
+//long content with many matches, as captured requests hold
+func BenchmarkRedactText(b *testing.B) {
+    text := strings.Repeat("The tenant can be reached at tenant@example.com for notices. ", 2000)
+    b.ReportAllocs()
+
+    for i := 0; i < b.N; i++ {
+        redactText(&text)
+    }
+}
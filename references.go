@@ -0,0 +1,124 @@
+package main
+
+import (
+    "context"
+    "github.com/gin-gonic/gin"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+    "log"
+    "net/http"
+    "time"
+)
+
+/* a record in another system the document belongs to, e.g. a Salesforce opportunity.
+A unique index keeps every reference registered to at most one document, so other
+systems can deep link to a document by their own id */
+type ExternalReference struct {
+    System     string `json:"system"`     //e.g. "salesforce"
+    ExternalID string `json:"externalId"` //id of the record within the system
+}
+
+const maxReferences = 20
+const maxReferencePartLength = 200
+
+func initReferences() {
+    ctx, cancel := context.WithTimeout(context.Background(), 10 * time.Second)
+    defer cancel()
+
+    //only documents having references are indexed, all others would collide on a missing key
+    _, indexErr := mongoCollection().Indexes().CreateOne(ctx, mongo.IndexModel{
+        Keys: bson.D{{Key: "references.system", Value: 1}, {Key: "references.externalid", Value: 1}},
+        Options: options.Index().
+            SetUnique(true).
+            SetPartialFilterExpression(bson.D{{Key: "references.system", Value: bson.D{{Key: "$exists", Value: true}}}}),
+    })
+
+    if indexErr != nil {
+        log.Print("Error creating index of external references: ", indexErr)
+    }
+}
+
+//describe the first malformed reference, or return an empty string if all are fine
+func referencesProblem(references []ExternalReference) string {
+    seen := make(map[ExternalReference]bool)
+
+    for _, reference := range references {
+        if reference.System == "" || reference.ExternalID == "" {
+            return "every reference needs a system and an externalId"
+        }
+
+        if seen[reference] {
+            return "reference " + reference.System + "/" + reference.ExternalID + " is given twice"
+        }
+
+        seen[reference] = true
+    }
+
+    return ""
+}
+
+//like exceededSizeLimit, for the external references of a document
+func exceededReferencesLimit(references []ExternalReference) string {
+    if len(references) > maxReferences {
+        return "references exceed the maximum of " + toString(maxReferences) + " per document"
+    }
+
+    for _, reference := range references {
+        if len(reference.System) > maxReferencePartLength || len(reference.ExternalID) > maxReferencePartLength {
+            return "system and externalId of a reference may not exceed " + toString(maxReferencePartLength) + " characters"
+        }
+    }
+
+    return ""
+}
+
+func getDocumentByReference(ctx context.Context, reference ExternalReference, readPreference string) (DocumentStatus, *Document) {
+    collection, collErr := readCollection(readPreference, documentReadConcern)
+
+    if collErr != nil {
+        return ImplementationError, nil
+    }
+
+    var document Document
+    filter := bson.D{{Key: "references", Value: bson.D{{Key: "$elemMatch", Value: bson.D{
+        {Key: "system", Value: reference.System},
+        {Key: "externalid", Value: reference.ExternalID},
+    }}}}}
+
+    findErr := collection.FindOne(ctx, filter).Decode(&document)
+
+    if findErr != nil {
+        if findErr == mongo.ErrNoDocuments {
+            return NotFound, nil
+        }
+
+        return CouldNotProceed, nil
+    }
+
+    return OK, &document
+}
+
+func handleGetDocumentByReference(ginCon *gin.Context) {
+    reference := ExternalReference{ginCon.Param("system"), ginCon.Param("externalId")}
+    params := ReadParams{defaultReadPreference}
+
+    if paramsProblem := bindQuery(ginCon, &params); paramsProblem != "" {
+      sendJsonHttpResponse(ginCon, http.StatusBadRequest, HttpError{CodeValidationFailed, paramsProblem})
+      return
+    }
+
+    readPreference := params.ReadPreference
+
+    status, document := getDocumentByReference(ginCon.Request.Context(), reference, readPreference)
+
+    switch status {
+    case OK:
+      ginCon.Set(accessedDocumentKey, *document.ID)
+      sendJsonHttpResponse(ginCon, http.StatusOK, document)
+    case NotFound:
+      sendJsonHttpResponse(ginCon, http.StatusNotFound, HttpError{CodeDocNotFound, "no document references " + reference.System + "/" + reference.ExternalID})
+    default:
+      sendStatus(ginCon, status, "the document")
+    }
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+    "bytes"
+    "github.com/gin-gonic/gin"
+    "github.com/microcosm-cc/bluemonday"
+    "github.com/yuin/goldmark"
+    "html/template"
+    "net/http"
+    "time"
+    _ "time/tzdata" //time zones for tz, also where the system has none installed
+)
+
+//page wrapping rendered content. html/template escapes title and header
+var documentPageTemplate = template.Must(template.New("document").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+</head>
+<body>
+<article>
+<h1>{{.Title}}</h1>
+<h2>{{.Header}}</h2>
+{{.Body}}
+</article>
+</body>
+</html>
+`))
+
+/* page for printing, e.g. to sign on paper, ending with a signature block for the
+signee. The stylesheet fits it on A4 and Letter paper */
+var printPageTemplate = template.Must(template.New("print").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+@page { margin: 2cm; }
+body { font-family: Georgia, serif; font-size: 11pt; line-height: 1.5; max-width: 17cm; margin: 0 auto; }
+header { display: flex; justify-content: space-between; font-size: 9pt; color: #555; border-bottom: 1px solid #999; }
+.signatures { margin-top: 3em; page-break-inside: avoid; }
+.line { border-bottom: 1px solid #000; height: 3em; width: 60%; }
+.verification { float: right; text-align: center; font-size: 8pt; }
+</style>
+</head>
+<body>
+<header>
+<span>Document {{.ID}}</span>
+<span>Printed {{.PrintedAt}}</span>
+</header>
+<article>
+<h1>{{.Title}}</h1>
+<h2>{{.Header}}</h2>
+{{.Body}}
+</article>
+<section class="signatures">
+<div class="verification"><img src="qr?size=128" width="128" height="128" alt="verification code"><br>Scan to verify</div>
+<div class="line"></div>
+<p>Signature of {{.Signee}}</p>
+<div class="line"></div>
+<p>Place and date</p>
+</section>
+</body>
+</html>
+`))
+
+type documentPage struct {
+    ID        int
+    Title     string
+    Header    string
+    Signee    string
+    Body      template.HTML //already sanitized
+    PrintedAt string
+}
+
+//policy for user generated content: keeps formatting and links, strips scripts, styles and event handlers
+var htmlSanitizer = bluemonday.UGCPolicy()
+
+/* render markdown into html safe to embed in a page. goldmark already leaves out
+raw html, the sanitizer is a second line of defence against e.g. javascript: links */
+func renderMarkdown(markdown string) (template.HTML, error) {
+    var rendered bytes.Buffer
+
+    if renderErr := goldmark.Convert([]byte(markdown), &rendered); renderErr != nil {
+        return "", renderErr
+    }
+
+    return template.HTML(htmlSanitizer.SanitizeBytes(rendered.Bytes())), nil
+}
+
+//render a document as an html page, treating content data as markdown
+func handleGetDocumentHtml(ginCon *gin.Context) {
+    renderDocument(ginCon, documentPageTemplate)
+}
+
+//render a document as a printable html page with a signature block
+func handleGetDocumentPrint(ginCon *gin.Context) {
+    renderDocument(ginCon, printPageTemplate)
+}
+
+func renderDocument(ginCon *gin.Context, pageTemplate *template.Template) {
+    id, toIntErr := toInt(getIDParam(ginCon))
+
+    if toIntErr != nil {
+      sendJsonHttpResponse(ginCon, http.StatusBadRequest, HttpError{CodeValidationFailed, "requested id '" + getIDParam(ginCon) + "' is not a number"})
+      return
+    }
+
+    //times are shown in UTC unless the page asks for a time zone, such as Europe/Stockholm
+    params := struct {
+        ReadParams
+        Location *time.Location `query:"tz"`
+    }{ReadParams{defaultReadPreference}, time.UTC}
+
+    if paramsProblem := bindQuery(ginCon, &params); paramsProblem != "" {
+      sendJsonHttpResponse(ginCon, http.StatusBadRequest, HttpError{CodeValidationFailed, paramsProblem})
+      return
+    }
+
+    readPreference, location := params.ReadPreference, params.Location
+
+    status, document := getDocumentIn(ginCon.Request.Context(), id, readPreference)
+
+    switch status {
+    case OK:
+    default:
+      sendDocumentStatus(ginCon, status, id)
+      return
+    }
+
+    if document.ID == nil || document.Content == nil || document.Content.Data == nil || document.Title == nil ||
+       document.Content.Header == nil || document.Signee == nil {
+      sendJsonHttpResponse(ginCon, http.StatusInternalServerError, HttpError{CodeInternal, "unexpected server state"})
+      return
+    }
+
+    if document.Content.Encoding != nil && *document.Content.Encoding != TextEncoding {
+      sendJsonHttpResponse(ginCon, http.StatusNotAcceptable, HttpError{CodeUnsupportedEncoding, "document " + getIDParam(ginCon) + " holds " + *document.Content.Encoding + " content, which can not be rendered as html"})
+      return
+    }
+
+    body, renderErr := renderMarkdown(*document.Content.Data)
+
+    if renderErr != nil {
+      sendJsonHttpResponse(ginCon, http.StatusInternalServerError, HttpError{CodeInternal, "unexpected server state"})
+      return
+    }
+
+    var page bytes.Buffer
+    templateErr := pageTemplate.Execute(&page, documentPage{*document.ID, *document.Title, *document.Content.Header,
+                                                            *document.Signee, body, time.Now().In(location).Format("2006-01-02 15:04 MST")})
+
+    if templateErr != nil {
+      sendJsonHttpResponse(ginCon, http.StatusInternalServerError, HttpError{CodeInternal, "unexpected server state"})
+      return
+    }
+
+    ginCon.Data(http.StatusOK, "text/html; charset=utf-8", page.Bytes())
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+    "go.mongodb.org/mongo-driver/bson"
+)
+
+/* documents count their revisions, starting at 1 on creation and going up with every
+edit of the title, content or signee. An edit may come with a change note telling
+signees what changed, kept with the revision it describes; an edit without a note
+clears the note of the revision before. Changes to metadata, references or links
+are no new revision. With PRECISELY_REQUIRE_CHANGE_NOTE=true, edits need a note */
+var requireChangeNote bool = getEnvBool("PRECISELY_REQUIRE_CHANGE_NOTE", false)
+
+const maxChangeNoteLength = 1000
+
+//whether a patch makes a new revision
+func isRevision(patchDocument Document) bool {
+    content := patchDocument.Content
+    contentChanged := content != nil && (content.Header != nil || content.Data != nil || content.ContentType != nil || content.Encoding != nil)
+
+    return patchDocument.Title != nil || contentChanged || patchDocument.Signee != nil
+}
+
+//describe the problem of the change note of a write, or return an empty string if it is fine
+func changeNoteProblem(changeNote *string, required bool) string {
+    if changeNote == nil {
+        if required {
+            return "changeNote is required when changing the title, content or signee"
+        }
+
+        return ""
+    }
+
+    if exceedsLength(changeNote, maxChangeNoteLength) {
+        return "changeNote exceeds the maximum length of " + toString(maxChangeNoteLength) + " characters"
+    }
+
+    return ""
+}
+
+//update operators counting the revision of a patch and setting or clearing its note
+func toRevisionUpdate(patchDocument Document) (bson.D, bson.D, bson.D) {
+    if !isRevision(patchDocument) {
+        return bson.D{}, bson.D{}, bson.D{}
+    }
+
+    increment := bson.D{{Key: "revision", Value: 1}}
+
+    if patchDocument.ChangeNote != nil {
+        return increment, bson.D{{Key: "changenote", Value: *patchDocument.ChangeNote}}, bson.D{}
+    }
+
+    return increment, bson.D{}, bson.D{{Key: "changenote", Value: ""}}
+}
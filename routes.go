@@ -0,0 +1,234 @@
+package main
+
+import (
+    "context"
+    "github.com/gin-gonic/gin"
+    "net/http"
+    "sync"
+    "time"
+)
+
+/* every endpoint is declared in one table along with its policy: whether it is for
+admins only, which rate limit it counts against, how long its reads and writes may take
+and how large a request body it accepts. Adding an endpoint is a matter of picking the
+policy of its kind, and the cross-cutting behavior follows */
+type routePolicy struct {
+    admin     bool          //only with the admin token, see requireAdmin
+    rateClass string        //calls per minute limited per client, see rateLimits. Empty for no limit
+    timeout   time.Duration //deadline of the request's database operations, 0 for none
+    bodyLimit int64         //bytes of request body accepted, 0 for any
+}
+
+var readTimeout time.Duration = time.Duration(getEnvInt("PRECISELY_READ_TIMEOUT_MILLIS", 10000)) * time.Millisecond
+var writeTimeout time.Duration = time.Duration(getEnvInt("PRECISELY_WRITE_TIMEOUT_MILLIS", 10000)) * time.Millisecond
+var batchTimeout time.Duration = time.Duration(getEnvInt("PRECISELY_BATCH_TIMEOUT_MILLIS", 60000)) * time.Millisecond
+
+var maxBodyBytes int64 = int64(getEnvInt("PRECISELY_MAX_BODY_BYTES", 8 * 1024 * 1024))
+var maxBatchBodyBytes int64 = int64(getEnvInt("PRECISELY_MAX_BATCH_BODY_BYTES", 16 * 1024 * 1024))
+
+const (
+    ReadRate  = "read"
+    WriteRate = "write"
+    BatchRate = "batch"
+)
+
+//calls per minute and client of each rate class, 0 for no limit. Admin token holders are not limited
+var rateLimits = map[string]int{
+    ReadRate:  getEnvInt("PRECISELY_RATE_LIMIT_READ", 0),
+    WriteRate: getEnvInt("PRECISELY_RATE_LIMIT_WRITE", 0),
+    BatchRate: getEnvInt("PRECISELY_RATE_LIMIT_BATCH", 0),
+}
+
+var (
+    //reads of documents, including those posting a body such as a compared text
+    readPolicy = routePolicy{rateClass: ReadRate, timeout: readTimeout, bodyLimit: maxBodyBytes}
+    //writes of single documents
+    writePolicy = routePolicy{rateClass: WriteRate, timeout: writeTimeout, bodyLimit: maxBodyBytes}
+    //writes of many documents at once
+    batchPolicy = routePolicy{rateClass: BatchRate, timeout: batchTimeout, bodyLimit: maxBatchBodyBytes}
+    //responses streamed for as long as they take
+    streamPolicy = routePolicy{rateClass: BatchRate}
+    //operating the service, which must not be held back
+    adminPolicy = routePolicy{admin: true, bodyLimit: maxBodyBytes}
+    //probes of load balancers and orchestrators
+    probePolicy = routePolicy{}
+)
+
+type route struct {
+    method   string
+    path     string
+    handler  gin.HandlerFunc
+    policy   routePolicy
+    disabled bool //left out of the router, e.g. when not enabled by configuration
+}
+
+var routes = []route{
+    //health of the service and its database connection
+    {method: http.MethodGet, path: "/health", handler: handleGetHealth, policy: probePolicy},
+    //read single document by id
+    {method: http.MethodGet, path: "/documents/:id", handler: handleGetDocument, policy: readPolicy},
+    //read the content data of a single document as raw bytes, in ranges if asked
+    {method: http.MethodGet, path: "/documents/:id/content", handler: handleGetDocumentContent, policy: readPolicy},
+    //read single document rendered as html
+    {method: http.MethodGet, path: "/documents/:id/html", handler: handleGetDocumentHtml, policy: readPolicy},
+    //read single document rendered as a printable html page
+    {method: http.MethodGet, path: "/documents/:id/print", handler: handleGetDocumentPrint, policy: readPolicy},
+    //QR code linking to the verification of a document
+    {method: http.MethodGet, path: "/documents/:id/qr", handler: handleGetDocumentQR, policy: readPolicy},
+    //verify a paper copy against the stored document
+    {method: http.MethodGet, path: "/documents/:id/verify", handler: handleVerifyDocument, policy: readPolicy},
+    //who read a document and when, for admins
+    {method: http.MethodGet, path: "/documents/:id/access-log", handler: handleGetAccessLog, policy: adminPolicy},
+    //read the document registered to a record in another system
+    {method: http.MethodGet, path: "/documents/by-ref/:system/:externalId", handler: handleGetDocumentByReference, policy: readPolicy},
+    //read all documents
+    {method: http.MethodGet, path: "/documents", handler: handleGetDocuments, policy: readPolicy},
+    //count the documents matching the filters of a listing
+    {method: http.MethodGet, path: "/documents/count", handler: handleCountDocuments, policy: readPolicy},
+    //stream all documents at once, optionally compressed
+    {method: http.MethodGet, path: "/documents/export", handler: handleExportDocuments, policy: streamPolicy},
+    //create document
+    {method: http.MethodPost, path: "/documents", handler: handleCreateDocument, policy: writePolicy},
+    //compare a text with the content of a document
+    {method: http.MethodPost, path: "/documents/:id/compare", handler: handleCompareDocument, policy: readPolicy},
+    //check which ids belong to a document
+    {method: http.MethodPost, path: "/documents/exists", handler: handleDocumentsExist, policy: readPolicy},
+    //apply creates, updates and deletes of documents atomically
+    {method: http.MethodPost, path: "/documents/transaction", handler: handleDocumentTransaction, policy: batchPolicy},
+    //create many documents, each on its own
+    {method: http.MethodPost, path: "/documents/batch", handler: handleBulkCreateDocuments, policy: batchPolicy},
+    //move every document of one signee to another
+    {method: http.MethodPost, path: "/documents/reassign-signee", handler: handleReassignSignee, policy: batchPolicy},
+    //update document
+    {method: http.MethodPatch, path: "/documents/:id", handler: handleUpdateDocument, policy: writePolicy},
+    //create or replace document with a given id, if enabled
+    {method: http.MethodPut, path: "/documents/:id", handler: handlePutDocument, policy: writePolicy, disabled: !enablePut},
+    //delete document
+    {method: http.MethodDelete, path: "/documents/:id", handler: handleDeleteDocument, policy: writePolicy},
+
+    //place or lift a legal hold on a document
+    {method: http.MethodPut, path: "/admin/documents/:id/legal-hold", handler: handleSetLegalHold, policy: adminPolicy},
+    //verify the hash chain of document writes
+    {method: http.MethodGet, path: "/admin/integrity", handler: handleGetIntegrity, policy: adminPolicy},
+    //calls per endpoint and caller over time
+    {method: http.MethodGet, path: "/admin/analytics", handler: handleGetAnalytics, policy: adminPolicy},
+    //notifications that could not be delivered
+    {method: http.MethodGet, path: "/admin/webhooks/dead-letters", handler: handleGetDeadLetters, policy: adminPolicy},
+    //queue a dead letter for delivery again
+    {method: http.MethodPost, path: "/admin/webhooks/dead-letters/:id/redeliver", handler: handleRedeliverDeadLetter, policy: adminPolicy},
+    //view and change settings of the running service
+    {method: http.MethodGet, path: "/admin/runtime", handler: handleGetRuntimeSettings, policy: adminPolicy},
+    {method: http.MethodPut, path: "/admin/runtime", handler: handleSetRuntimeSettings, policy: adminPolicy},
+    //view and change settings stored for all instances, and their past versions
+    {method: http.MethodGet, path: "/admin/settings", handler: handleGetSettings, policy: adminPolicy},
+    {method: http.MethodPut, path: "/admin/settings", handler: handleSetSettings, policy: adminPolicy},
+    {method: http.MethodGet, path: "/admin/settings/versions", handler: handleGetSettingsVersions, policy: adminPolicy},
+    //indexes missing for the queries served
+    {method: http.MethodGet, path: "/admin/index-suggestions", handler: handleGetIndexSuggestions, policy: adminPolicy},
+    //recompute the fields derived from title and content of all documents, and follow its progress
+    {method: http.MethodPost, path: "/admin/rebuild-projections", handler: handleRebuildProjections, policy: adminPolicy},
+    {method: http.MethodGet, path: "/admin/rebuild-projections", handler: handleGetProjectionRebuild, policy: adminPolicy},
+}
+
+//the middleware carrying out a policy, in the order it runs
+func (policy routePolicy) middleware() []gin.HandlerFunc {
+    var handlers []gin.HandlerFunc
+
+    if policy.admin {
+        handlers = append(handlers, requireAdmin)
+    }
+
+    if policy.rateClass != "" && rateLimits[policy.rateClass] > 0 {
+        handlers = append(handlers, limitRate(policy.rateClass))
+    }
+
+    //answer bursts of identical requests to configured endpoints from one query, once they are counted
+    handlers = append(handlers, serveMicroCached)
+
+    //decompress, capture and accept json in the field naming a deployment or request asks for, once the body is limited
+    if policy.bodyLimit > 0 {
+        handlers = append(handlers, limitBody(policy.bodyLimit), decompressRequest(policy.bodyLimit), captureRequestBody, translateRequestNaming)
+    }
+
+    if policy.timeout > 0 {
+        handlers = append(handlers, limitDuration(policy.timeout))
+    }
+
+    return handlers
+}
+
+func registerRoutes(router *gin.Engine) {
+    for _, route := range routes {
+        if !route.disabled {
+            router.Handle(route.method, route.path, append(route.policy.middleware(), route.handler)...)
+        }
+    }
+}
+
+/* calls counted per rate class and client in the current minute. Counting restarts each
+minute, so a client may make up to twice its limit around the turn of a minute. Each
+instance counts on its own */
+var rateWindowStart time.Time
+var rateCounts = make(map[string]int)
+var rateMutex sync.Mutex
+
+//count the call, false if the client has used up the limit of the class this minute
+func takeRate(class string, caller string, now time.Time) bool {
+    rateMutex.Lock()
+    defer rateMutex.Unlock()
+
+    if window := now.Truncate(time.Minute); !window.Equal(rateWindowStart) {
+        rateWindowStart = window
+        rateCounts = make(map[string]int)
+    }
+
+    key := class + " " + caller
+
+    if rateCounts[key] >= rateLimits[class] {
+        return false
+    }
+
+    rateCounts[key]++
+    return true
+}
+
+func limitRate(class string) gin.HandlerFunc {
+    return func(ginCon *gin.Context) {
+        now := time.Now()
+
+        if isAdminRequest(ginCon) || takeRate(class, ginCon.ClientIP(), now) {
+            ginCon.Next()
+            return
+        }
+
+        retryAfter := now.Truncate(time.Minute).Add(time.Minute).Sub(now)
+        ginCon.Header("Retry-After", toString(int(retryAfter.Seconds()) + 1))
+        sendJsonHttpResponse(ginCon, http.StatusTooManyRequests, HttpError{CodeRateLimited, "more than " + toString(rateLimits[class]) + " " + class + " requests per minute"})
+        ginCon.Abort()
+    }
+}
+
+//refuse bodies beyond limit, also those not telling their length upfront
+func limitBody(limit int64) gin.HandlerFunc {
+    return func(ginCon *gin.Context) {
+        if ginCon.Request.ContentLength > limit {
+            sendJsonHttpResponse(ginCon, http.StatusRequestEntityTooLarge, HttpError{CodePayloadTooLarge, "request body exceeds " + toString(int(limit)) + " bytes"})
+            ginCon.Abort()
+            return
+        }
+
+        ginCon.Request.Body = http.MaxBytesReader(ginCon.Writer, ginCon.Request.Body, limit)
+        ginCon.Next()
+    }
+}
+
+//give the database operations of the request a deadline, see readContext and newWriteRequest
+func limitDuration(timeout time.Duration) gin.HandlerFunc {
+    return func(ginCon *gin.Context) {
+        ctx, cancel := context.WithTimeout(ginCon.Request.Context(), timeout)
+        defer cancel()
+
+        ginCon.Request = ginCon.Request.WithContext(ctx)
+        ginCon.Next()
+    }
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+    "github.com/gin-gonic/gin"
+    "log"
+    "net/http"
+    "runtime"
+)
+
+/* settings admins can view and change while the service runs, without a restart.
+Changes last until the process exits; the environment still sets the values at
+startup. Every change is logged along with who made it */
+type RuntimeSettings struct {
+    GoMaxProcs            *int `json:"goMaxProcs,omitempty"`
+    MaxListSize           *int `json:"maxListSize,omitempty"`
+    RetryAfterSeconds     *int `json:"retryAfterSeconds,omitempty"`
+    OutboxMaxAttempts     *int `json:"outboxMaxAttempts,omitempty"`
+    AnalyticsFlushSeconds *int `json:"analyticsFlushSeconds,omitempty"`
+    HealthCheckSeconds    *int `json:"healthCheckSeconds,omitempty"`
+}
+
+//a field of RuntimeSettings and the tunable it shows
+type runtimeSetting struct {
+    value   **int
+    setting *tunableInt
+}
+
+//the tunable behind each setting except goMaxProcs, which lives in the go runtime
+func tunableSettings(settings *RuntimeSettings) map[string]runtimeSetting {
+    return map[string]runtimeSetting{
+        "maxListSize":           {&settings.MaxListSize, maxListSize},
+        "retryAfterSeconds":     {&settings.RetryAfterSeconds, retryAfterSeconds},
+        "outboxMaxAttempts":     {&settings.OutboxMaxAttempts, maxOutboxAttempts},
+        "analyticsFlushSeconds": {&settings.AnalyticsFlushSeconds, analyticsFlushSeconds},
+        "healthCheckSeconds":    {&settings.HealthCheckSeconds, healthCheckSeconds},
+    }
+}
+
+func getRuntimeSettings() RuntimeSettings {
+    var settings RuntimeSettings
+    goMaxProcs := runtime.GOMAXPROCS(0)
+    settings.GoMaxProcs = &goMaxProcs
+
+    for _, tunable := range tunableSettings(&settings) {
+        value := tunable.setting.Get()
+        *tunable.value = &value
+    }
+
+    return settings
+}
+
+func handleGetRuntimeSettings(ginCon *gin.Context) {
+    sendJsonHttpResponse(ginCon, http.StatusOK, getRuntimeSettings())
+}
+
+//describe the first invalid value among the given settings, or return an empty string if all are fine
+func runtimeSettingsProblem(settings RuntimeSettings) string {
+    if settings.GoMaxProcs != nil && *settings.GoMaxProcs < 1 {
+        return "goMaxProcs must be at least 1"
+    }
+
+    for name, tunable := range tunableSettings(&settings) {
+        if *tunable.value != nil && **tunable.value < 1 {
+            return name + " must be at least 1"
+        }
+    }
+
+    return ""
+}
+
+//change the given settings, leaving the others as they are. changedBy is logged along with each change
+func applyRuntimeSettings(settings RuntimeSettings, changedBy string) {
+    if settings.GoMaxProcs != nil {
+        if previous := runtime.GOMAXPROCS(*settings.GoMaxProcs); previous != *settings.GoMaxProcs {
+            log.Print("Runtime setting goMaxProcs changed from ", previous, " to ", *settings.GoMaxProcs, " by ", changedBy)
+        }
+    }
+
+    for name, tunable := range tunableSettings(&settings) {
+        if *tunable.value != nil {
+            if previous := tunable.setting.Set(**tunable.value); previous != **tunable.value {
+                log.Print("Runtime setting ", name, " changed from ", previous, " to ", **tunable.value, " by ", changedBy)
+            }
+        }
+    }
+}
+
+//change the settings present in the request, leaving the others as they are
+func handleSetRuntimeSettings(ginCon *gin.Context) {
+    var request RuntimeSettings
+
+    if bindErr := ginCon.BindJSON(&request); bindErr != nil {
+      sendJsonHttpResponse(ginCon, http.StatusBadRequest, HttpError{CodeValidationFailed, "illegal structure of json object"})
+      return
+    }
+
+    //validate everything first, so a bad request changes nothing
+    if problem := runtimeSettingsProblem(request); problem != "" {
+      sendJsonHttpResponse(ginCon, http.StatusBadRequest, HttpError{CodeValidationFailed, problem})
+      return
+    }
+
+    applyRuntimeSettings(request, ginCon.ClientIP())
+    sendJsonHttpResponse(ginCon, http.StatusOK, getRuntimeSettings())
+}
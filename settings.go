@@ -0,0 +1,204 @@
+package main
+
+import (
+    "context"
+    "github.com/gin-gonic/gin"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+    "log"
+    "net/http"
+    "sync"
+    "time"
+)
+
+/* runtime settings can also be stored in the settings collection, where they outlive
+restarts and apply to every instance. Each change is stored as a new version holding
+all stored settings, so the collection is an audit trail of who changed what and when.
+Instances apply the latest version at startup and whenever it changes */
+var settingsCollectionName string = "precisely-settings"
+
+func settingsCollection() *mongo.Collection {
+    return getMongoClient().Database(databaseName).Collection(settingsCollectionName)
+}
+
+//how often instances look for a new version of the stored settings
+const settingsPollInterval = 30 * time.Second
+
+//attempts to store a version when other changes are stored at the same time
+const maxSettingsAttempts = 5
+
+type SettingsVersion struct {
+    Version   int             `json:"version"` //0 while nothing is stored
+    Settings  RuntimeSettings `json:"settings"`
+    ChangedBy string          `json:"changedBy,omitempty"` //address of the admin making the change
+    ChangedAt *time.Time      `json:"changedAt,omitempty"`
+}
+
+//version of the stored settings this instance applied last
+var appliedSettingsVersion int
+var appliedSettingsMutex sync.Mutex
+
+func initSettings() {
+    ctx, cancel := context.WithTimeout(context.Background(), 10 * time.Second)
+    defer cancel()
+
+    _, indexErr := settingsCollection().Indexes().CreateOne(ctx, mongo.IndexModel{
+        Keys:    bson.D{{Key: "version", Value: 1}},
+        Options: options.Index().SetUnique(true),
+    })
+
+    if indexErr != nil {
+        log.Print("Error creating index of settings collection: ", indexErr)
+    }
+
+    applyStoredSettings()
+}
+
+//the latest version of the stored settings, or version 0 if none are stored
+func getStoredSettings() (DocumentStatus, SettingsVersion) {
+    var latest SettingsVersion
+    findErr := settingsCollection().FindOne(context.TODO(), bson.D{},
+        options.FindOne().SetSort(bson.D{{Key: "version", Value: -1}})).Decode(&latest)
+
+    if findErr == mongo.ErrNoDocuments {
+        return OK, SettingsVersion{}
+    }
+
+    if findErr != nil {
+        return CouldNotProceed, SettingsVersion{}
+    }
+
+    return OK, latest
+}
+
+//every stored version, newest first
+func getSettingsVersions() (DocumentStatus, []SettingsVersion) {
+    cursor, findErr := settingsCollection().Find(context.TODO(), bson.D{},
+        options.Find().SetSort(bson.D{{Key: "version", Value: -1}}))
+
+    if findErr != nil {
+        return CouldNotProceed, nil
+    }
+
+    versions := []SettingsVersion{}
+
+    if allErr := cursor.All(context.TODO(), &versions); allErr != nil {
+        return CouldNotProceed, nil
+    }
+
+    return OK, versions
+}
+
+//merge changes into the latest stored settings and store the result as a new version
+func storeSettings(changes RuntimeSettings, changedBy string) (DocumentStatus, SettingsVersion) {
+    for attempt := 0; attempt < maxSettingsAttempts; attempt++ {
+        status, latest := getStoredSettings()
+
+        if status != OK {
+            return status, SettingsVersion{}
+        }
+
+        merged := latest.Settings
+        mergedTunables := tunableSettings(&merged)
+
+        if changes.GoMaxProcs != nil {
+            merged.GoMaxProcs = changes.GoMaxProcs
+        }
+
+        for name, tunable := range tunableSettings(&changes) {
+            if *tunable.value != nil {
+                *mergedTunables[name].value = *tunable.value
+            }
+        }
+
+        changedAt := time.Now().UTC()
+        version := SettingsVersion{latest.Version + 1, merged, changedBy, &changedAt}
+        _, insertErr := settingsCollection().InsertOne(context.TODO(), version)
+
+        if insertErr == nil {
+            return OK, version
+        }
+
+        if !mongo.IsDuplicateKeyError(insertErr) { //anything but another change storing the same version first
+            return CouldNotProceed, SettingsVersion{}
+        }
+    }
+
+    return CouldNotProceed, SettingsVersion{}
+}
+
+//apply the latest stored settings to this instance, if not done already
+func applyStoredSettings() {
+    status, latest := getStoredSettings()
+
+    if status != OK {
+        log.Print("Could not read stored settings")
+        return
+    }
+
+    appliedSettingsMutex.Lock()
+    defer appliedSettingsMutex.Unlock()
+
+    if latest.Version <= appliedSettingsVersion {
+        return
+    }
+
+    applyRuntimeSettings(latest.Settings, "stored settings version " + toString(latest.Version))
+    appliedSettingsVersion = latest.Version
+}
+
+//follow changes of the stored settings. Runs for the lifetime of the process
+func runSettingsWatcher() {
+    for {
+        time.Sleep(settingsPollInterval)
+        applyStoredSettings()
+    }
+}
+
+func handleGetSettings(ginCon *gin.Context) {
+    status, latest := getStoredSettings()
+
+    switch status {
+    case OK:
+      sendJsonHttpResponse(ginCon, http.StatusOK, latest)
+    default:
+      sendStatus(ginCon, status, "the settings")
+    }
+}
+
+func handleGetSettingsVersions(ginCon *gin.Context) {
+    status, versions := getSettingsVersions()
+
+    switch status {
+    case OK:
+      sendJsonHttpResponse(ginCon, http.StatusOK, versions)
+    default:
+      sendStatus(ginCon, status, "the settings")
+    }
+}
+
+//store the settings present in the request as a new version, and apply it to this instance right away
+func handleSetSettings(ginCon *gin.Context) {
+    var request RuntimeSettings
+
+    if bindErr := ginCon.BindJSON(&request); bindErr != nil {
+      sendJsonHttpResponse(ginCon, http.StatusBadRequest, HttpError{CodeValidationFailed, "illegal structure of json object"})
+      return
+    }
+
+    if problem := runtimeSettingsProblem(request); problem != "" {
+      sendJsonHttpResponse(ginCon, http.StatusBadRequest, HttpError{CodeValidationFailed, problem})
+      return
+    }
+
+    status, version := storeSettings(request, ginCon.ClientIP())
+
+    switch status {
+    case OK:
+      applyStoredSettings()
+      sendJsonHttpResponse(ginCon, http.StatusOK, version)
+    default:
+      sendStatus(ginCon, status, "the settings")
+    }
+}
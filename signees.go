@@ -0,0 +1,88 @@
+package main
+
+import (
+    "github.com/gin-gonic/gin"
+    "go.mongodb.org/mongo-driver/bson"
+    "net/http"
+)
+
+/* moving every document of one signee to another, e.g. when an employee leaves. The
+documents are updated like by a PATCH of their signee, so each update is recorded in
+the history and notified as a new revision. They are updated in transactions of up to
+PRECISELY_MAX_BATCH_OPERATIONS documents each */
+type SigneeReassignment struct {
+    From *string `json:"from"`
+    To   *string `json:"to"`
+}
+
+type SigneeReassignmentResult struct {
+    IDs []int `json:"ids"` //documents moved to the new signee
+}
+
+func handleReassignSignee(ginCon *gin.Context) {
+    var reassignment SigneeReassignment
+
+    if bindErr := ginCon.BindJSON(&reassignment); bindErr != nil {
+      sendJsonHttpResponse(ginCon, http.StatusBadRequest, HttpError{CodeValidationFailed, "illegal structure of json object"})
+      return
+    }
+
+    if reassignment.From == nil || reassignment.To == nil || *reassignment.From == "" || *reassignment.To == "" {
+      sendJsonHttpResponse(ginCon, http.StatusBadRequest, HttpError{CodeValidationFailed, "from and to must both name a signee"})
+      return
+    }
+
+    if exceededLimit := exceededSizeLimit(Document{Signee: reassignment.To}); exceededLimit != "" {
+      sendJsonHttpResponse(ginCon, http.StatusUnprocessableEntity, HttpError{CodeSizeLimitExceeded, exceededLimit})
+      return
+    }
+
+    status, ids := getMatchingIds(bson.D{{Key: "signee", Value: *reassignment.From}})
+
+    if status != OK {
+      sendDbUnavailable(ginCon)
+      return
+    }
+
+    request := newWriteRequest(ginCon)
+    request.Batch = true
+    result := SigneeReassignmentResult{[]int{}}
+    changeNote := "signee reassigned from " + *reassignment.From
+
+    for start := 0; start < len(ids); start += maxBatchOperations {
+        end := start + maxBatchOperations
+
+        if end > len(ids) {
+            end = len(ids)
+        }
+
+        writes := make([]pendingWrite, end - start)
+
+        for i, id := range ids[start:end] {
+            patch := Document{ID: new(int), Signee: reassignment.To, ChangeNote: &changeNote}
+            *patch.ID = id
+            name := UpdateOperation
+            writes[i] = pendingWrite{&name, updateWrite(patch)}
+        }
+
+        //transactions already applied stay applied; repeating the request moves the rest
+        status, failed, _ := writeDocuments(request, writes)
+
+        switch status {
+        case OK:
+          result.IDs = append(result.IDs, ids[start:end]...)
+        case NotFound:
+          sendJsonHttpResponse(ginCon, http.StatusConflict, HttpError{CodeDocNotFound, "document " + toString(ids[start + failed]) + " was deleted while reassigning; " + toString(len(result.IDs)) + " documents were reassigned, repeat the request to reassign the rest"})
+          return
+        case Frozen:
+          sendJsonHttpResponse(ginCon, http.StatusLocked, HttpError{CodeDocFrozen, "document " + toString(ids[start + failed]) + " is frozen; " + toString(len(result.IDs)) + " documents were reassigned, repeat the request once the freeze is over to reassign the rest"})
+          return
+        default:
+          sendStatus(ginCon, status, "the documents")
+          return
+        }
+    }
+
+    sendConsistencyToken(ginCon, request)
+    sendJsonHttpResponse(ginCon, http.StatusOK, result)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+    "flag"
+    "log"
+    "net/url"
+    "sort"
+    "strings"
+)
+
+/* before serving, the configuration and the services depended on are checked, and all
+problems found are logged together as one report, instead of showing one at a time as
+failing requests. Startup stops if any check fails. Started with --check, the service
+exits after the report, e.g. for a deployment pipeline to validate a configuration */
+var checkOnly = flag.Bool("check", false, "check configuration and dependencies, then exit")
+
+type startupCheck struct {
+    name  string
+    check func() string //describes the problem found, or returns an empty string
+}
+
+//indexes of the document collection the service relies on, created by initMongoDB
+var requiredIndexes = map[string][]string{
+    "document ids":        {"id"},
+    "external references": {"references.system", "references.externalid"},
+    "duplicate hashes":    {"duplicatehash"},
+}
+
+//webhook urls notified, by the setting naming them
+var checkedWebhooks = map[string]*string{
+    "PRECISELY_SLACK_WEBHOOK_URL": &slackWebhookURL,
+    "PRECISELY_TEAMS_WEBHOOK_URL": &teamsWebhookURL,
+}
+
+func checkSettings() string {
+    problems := append([]string{}, settingProblems...)
+
+    if !isValidNaming(defaultNaming) {
+        problems = append(problems, "PRECISELY_JSON_NAMING must be " + CamelCase + ", " + SnakeCase + " or " + PascalCase + ": " + defaultNaming)
+    }
+
+    if !isValidReadPreference(defaultReadPreference) {
+        problems = append(problems, "PRECISELY_READ_PREFERENCE is no read preference: " + defaultReadPreference)
+    }
+
+    if totalCountStrategy != "exact" && totalCountStrategy != "estimated" {
+        problems = append(problems, "PRECISELY_TOTAL_COUNT_STRATEGY must be exact or estimated: " + totalCountStrategy)
+    }
+
+    if parsedURL, parseErr := url.Parse(publicURL); parseErr != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") || parsedURL.Host == "" {
+        problems = append(problems, "PRECISELY_PUBLIC_URL is no http or https url: " + publicURL)
+    }
+
+    return strings.Join(problems, "; ")
+}
+
+func checkIndexes() string {
+    status, indexes := getIndexes()
+
+    if status != OK {
+        return "indexes of the document collection can not be listed"
+    }
+
+    var missing []string
+
+    for name, keys := range requiredIndexes {
+        found := false
+
+        for _, indexKeys := range indexes {
+            if strings.Join(indexKeys, ",") == strings.Join(keys, ",") {
+                found = true
+                break
+            }
+        }
+
+        if !found {
+            missing = append(missing, name + " (" + strings.Join(keys, ", ") + ")")
+        }
+    }
+
+    if len(missing) > 0 {
+        sort.Strings(missing)
+        return "missing the index of " + strings.Join(missing, ", ")
+    }
+
+    return ""
+}
+
+/* webhooks are only checked to answer at all, with a HEAD request. Posting to them
+would show a message in the channel, and any answer, even an error status, means the
+target is reachable */
+func checkWebhooks() string {
+    var problems []string
+
+    for setting, webhookURL := range checkedWebhooks {
+        if *webhookURL == "" {
+            continue
+        }
+
+        response, requestErr := notificationClient.Head(*webhookURL)
+
+        if requestErr != nil {
+            problems = append(problems, setting + " is not reachable: " + requestErr.Error())
+            continue
+        }
+
+        response.Body.Close()
+    }
+
+    sort.Strings(problems)
+    return strings.Join(problems, "; ")
+}
+
+/* run all checks and log their report, returning whether all passed. Checks needing
+the database are skipped if it could not be connected */
+func runStartupChecks(mongoErr error) bool {
+    checks := []startupCheck{{"settings", checkSettings}, {"webhooks", checkWebhooks}}
+
+    if mongoErr == nil {
+        checks = append(checks, startupCheck{"indexes", checkIndexes})
+    }
+
+    passed := mongoErr == nil
+    report := "Startup checks:"
+
+    if mongoErr != nil {
+        report += "\n  MongoDB: FAILED, " + mongoErr.Error()
+    } else {
+        report += "\n  MongoDB: ok"
+    }
+
+    for _, check := range checks {
+        if problem := check.check(); problem != "" {
+            passed = false
+            report += "\n  " + check.name + ": FAILED, " + problem
+        } else {
+            report += "\n  " + check.name + ": ok"
+        }
+    }
+
+    log.Print(report)
+    return passed
+}
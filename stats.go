@@ -0,0 +1,60 @@
+package main
+
+import (
+    "strings"
+    "unicode/utf8"
+)
+
+/* word and character counts of the header and data of text content, along with the
+time it takes to read them, for planning reviews. They are computed on every write,
+so documents last written before they were introduced have none. Binary content has
+no stats */
+type DocumentStats struct {
+    Words          int `json:"words"`
+    Characters     int `json:"characters"`
+    ReadingMinutes int `json:"readingMinutes"` //rounded up
+}
+
+//an average adult reading speed
+const readingWordsPerMinute = 200
+
+//stats of the content of a document, or nil if there is no text to count
+func contentStats(document Document) *DocumentStats {
+    content := document.Content
+
+    if content == nil || (content.Encoding != nil && *content.Encoding != TextEncoding) {
+        return nil
+    }
+
+    var parts []string
+
+    if content.Header != nil {
+        parts = append(parts, *content.Header)
+    }
+
+    if content.Data != nil {
+        parts = append(parts, *content.Data)
+    }
+
+    if len(parts) == 0 {
+        return nil
+    }
+
+    var stats DocumentStats
+
+    for _, part := range parts {
+        stats.Words += len(strings.Fields(part))
+        stats.Characters += utf8.RuneCountInString(part)
+    }
+
+    stats.ReadingMinutes = (stats.Words + readingWordsPerMinute - 1) / readingWordsPerMinute
+    return &stats
+}
+
+func sameStats(stats *DocumentStats, otherStats *DocumentStats) bool {
+    if stats == nil || otherStats == nil {
+        return stats == otherStats
+    }
+
+    return *stats == *otherStats
+}
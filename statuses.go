@@ -0,0 +1,63 @@
+package main
+
+import (
+    "github.com/gin-gonic/gin"
+    "net/http"
+)
+
+/* a status other than OK is answered alike on every endpoint, with one response code
+and error code, so a new status only needs its answer here. Handlers answer OK
+themselves, and answer a status otherwise only where they know better, e.g. that a
+dead letter rather than a document was not found */
+
+//the response code and error answering a status. subject names what it is about, e.g. "document 7"
+func statusProblem(status DocumentStatus, subject string) (int, HttpError) {
+    switch status {
+    case NotFound:
+      return http.StatusNotFound, HttpError{CodeDocNotFound, "could not find " + subject}
+    case UnderLegalHold:
+      return http.StatusConflict, HttpError{CodeUnderLegalHold, subject + " is under legal hold and can not be deleted until the hold is lifted"}
+    case Frozen:
+      return http.StatusLocked, HttpError{CodeDocFrozen, subject + " is frozen"}
+    case AlreadyExists:
+      return http.StatusPreconditionFailed, HttpError{CodePreconditionFailed, subject + " exists already, and If-None-Match: * only allows creating it"}
+    case DuplicateReference:
+      return http.StatusConflict, HttpError{CodeReferenceTaken, "a reference of " + subject + " is registered to another document"}
+    case LimitExceeded:
+      return http.StatusUnprocessableEntity, HttpError{CodeSizeLimitExceeded, "metadata of " + subject + " would exceed the maximum of " + toString(maxMetadataKeys) + " keys"}
+    case CouldNotProceed:
+      if legacyBadGateway {
+          return http.StatusBadGateway, HttpError{CodeDbUnavailable, "external database does not respond properly"}
+      }
+
+      return http.StatusServiceUnavailable, HttpError{CodeDbUnavailable, "external database does not respond properly"}
+    default:
+      return http.StatusInternalServerError, HttpError{CodeInternal, "unexpected server state"}
+    }
+}
+
+func documentSubject(id int) string {
+    return "document " + toString(id)
+}
+
+func sendStatus(ginCon *gin.Context, status DocumentStatus, subject string) {
+    code, problem := statusProblem(status, subject)
+
+    if code == http.StatusServiceUnavailable {
+        ginCon.Header("Retry-After", toString(retryAfterSeconds.Get()))
+    }
+
+    sendJsonHttpResponse(ginCon, code, problem)
+}
+
+//answer a status about the document with id, telling deleted documents and freeze windows apart
+func sendDocumentStatus(ginCon *gin.Context, status DocumentStatus, id int) {
+    switch status {
+    case NotFound:
+      sendDocumentMissing(ginCon, id)
+    case Frozen:
+      sendDocumentFrozen(ginCon, id)
+    default:
+      sendStatus(ginCon, status, documentSubject(id))
+    }
+}
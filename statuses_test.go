@@ -0,0 +1,56 @@
+package main
+
+import (
+    "net/http"
+    "testing"
+)
+
+func TestStatusProblem(t *testing.T) {
+    tests := []struct {
+        status        DocumentStatus
+        code          int
+        errorCode     ErrorCode
+        legacyGateway bool
+    }{
+        {NotFound, http.StatusNotFound, CodeDocNotFound, false},
+        {UnderLegalHold, http.StatusConflict, CodeUnderLegalHold, false},
+        {Frozen, http.StatusLocked, CodeDocFrozen, false},
+        {AlreadyExists, http.StatusPreconditionFailed, CodePreconditionFailed, false},
+        {DuplicateReference, http.StatusConflict, CodeReferenceTaken, false},
+        {LimitExceeded, http.StatusUnprocessableEntity, CodeSizeLimitExceeded, false},
+        {CouldNotProceed, http.StatusServiceUnavailable, CodeDbUnavailable, false},
+        {CouldNotProceed, http.StatusBadGateway, CodeDbUnavailable, true},
+        {ImplementationError, http.StatusInternalServerError, CodeInternal, false},
+        //retried within the write, so it reaching a handler is a mistake of ours
+        {IdTaken, http.StatusInternalServerError, CodeInternal, false},
+    }
+
+    savedLegacy := legacyBadGateway
+    defer func() { legacyBadGateway = savedLegacy }()
+
+    for _, test := range tests {
+        legacyBadGateway = test.legacyGateway
+        code, problem := statusProblem(test.status, documentSubject(7))
+
+        if code != test.code || problem.Code != test.errorCode || problem.Message == "" {
+            t.Errorf("status %d is answered with %d %+v, expected %d %s", test.status, code, problem, test.code, test.errorCode)
+        }
+    }
+}
+
+//only an unavailable database tells clients when to retry
+func TestSendStatusRetryAfter(t *testing.T) {
+    savedLegacy := legacyBadGateway
+    legacyBadGateway = false
+    defer func() { legacyBadGateway = savedLegacy }()
+
+    for _, status := range []DocumentStatus{CouldNotProceed, NotFound, UnderLegalHold} {
+        ginCon, recorder := testContext(http.MethodGet, "/documents/7", nil)
+        sendStatus(ginCon, status, documentSubject(7))
+        retryAfter := recorder.Header().Get("Retry-After")
+
+        if (status == CouldNotProceed) != (retryAfter != "") {
+            t.Errorf("status %d is answered with Retry-After %q", status, retryAfter)
+        }
+    }
+}
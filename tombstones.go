@@ -0,0 +1,63 @@
+package main
+
+import (
+    "context"
+    "github.com/gin-gonic/gin"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+    "net/http"
+    "time"
+)
+
+/* for a while after a document is deleted, requests for it are answered with 410 Gone
+instead of 404 Not Found, so clients retrying a delete or reading a stale link can tell
+a deleted document from one that never existed. The delete events of the history serve
+as tombstones. A window of 0 hours turns this off */
+var tombstoneHours int = getEnvInt("PRECISELY_TOMBSTONE_HOURS", 24)
+
+type GoneError struct {
+    HttpError
+    DeletedAt time.Time `json:"deletedAt"`
+}
+
+//when a document was deleted, if it was deleted within the tombstone window and not created again since
+func getDeletion(id int) (DocumentStatus, *time.Time) {
+    if tombstoneHours <= 0 {
+        return NotFound, nil
+    }
+
+    var event HistoryEvent
+    findErr := historyCollection().FindOne(
+        context.TODO(),
+        bson.D{{Key: "documentid", Value: id}},
+        options.FindOne().SetSort(bson.D{{Key: "seq", Value: -1}}),
+    ).Decode(&event)
+
+    if findErr != nil {
+        if findErr == mongo.ErrNoDocuments {
+            return NotFound, nil
+        }
+
+        return CouldNotProceed, nil
+    }
+
+    if event.Operation != DeleteOperation || time.Since(event.At) > time.Duration(tombstoneHours) * time.Hour {
+        return NotFound, nil
+    }
+
+    return OK, &event.At
+}
+
+//answer a request for a missing document with 410 Gone if it was deleted recently, otherwise 404 Not Found
+func sendDocumentMissing(ginCon *gin.Context, id int) {
+    status, deletedAt := getDeletion(id)
+
+    switch status {
+    case OK:
+      message := "document " + toString(id) + " was deleted at " + deletedAt.UTC().Format(time.RFC3339)
+      sendJsonHttpResponse(ginCon, http.StatusGone, GoneError{HttpError{CodeDocDeleted, message}, *deletedAt})
+    default:
+      sendStatus(ginCon, status, documentSubject(id))
+    }
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+    "crypto/rand"
+    "encoding/hex"
+    "github.com/gin-gonic/gin"
+    "regexp"
+)
+
+/* W3C trace context (https://www.w3.org/TR/trace-context/). The traceparent of a
+request writing a document is stored with its events, and sent along when they are
+delivered, so the webhook calls join the trace of the request that caused them */
+var traceParentPattern = regexp.MustCompile(`^00-([0-9a-f]{32})-([0-9a-f]{16})-([0-9a-f]{2})$`)
+
+const invalidTraceID = "00000000000000000000000000000000"
+const invalidParentID = "0000000000000000"
+
+func randomHex(length int) string {
+    random := make([]byte, length/2)
+    rand.Read(random)
+
+    return hex.EncodeToString(random)
+}
+
+/* traceparent of the request. Requests without a valid one start a new, unsampled
+trace, so their webhook calls can still be told apart */
+func requestTraceParent(ginCon *gin.Context) string {
+    match := traceParentPattern.FindStringSubmatch(ginCon.GetHeader("traceparent"))
+
+    if match == nil || match[1] == invalidTraceID || match[2] == invalidParentID {
+        return "00-" + randomHex(32) + "-" + randomHex(16) + "-00"
+    }
+
+    return match[0]
+}
+
+/* traceparent for an outgoing call made on behalf of a trace: same trace and flags,
+with a new parent id standing for this service's part in it */
+func childTraceParent(traceParent string) string {
+    match := traceParentPattern.FindStringSubmatch(traceParent)
+
+    if match == nil {
+        return ""
+    }
+
+    return "00-" + match[1] + "-" + randomHex(16) + "-" + match[3]
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+    "github.com/gin-gonic/gin"
+    "net/http"
+)
+
+/* a batch of creates, updates and deletes applied atomically: they run in order in
+one MongoDB transaction, so either all of them are applied or none. Each operation is
+validated like its single document endpoint before any is run */
+var maxBatchOperations int = getEnvInt("PRECISELY_MAX_BATCH_OPERATIONS", 100)
+
+const (
+    BatchCreate = "create"
+    BatchUpdate = "update"
+    BatchDelete = "delete"
+)
+
+type BatchOperation struct {
+    Op       string    `json:"op"`                 //create, update or delete
+    ID       *int      `json:"id,omitempty"`       //document to update or delete
+    Document *Document `json:"document,omitempty"` //document to create, or patch to update with
+}
+
+type BatchRequest struct {
+    Operations []BatchOperation `json:"operations"`
+}
+
+type BatchResult struct {
+    Op       string    `json:"op"`
+    ID       int       `json:"id"`
+    Document *Document `json:"document,omitempty"` //state after the write, nil for deletes
+}
+
+type BatchResponse struct {
+    Results []BatchResult `json:"results"`
+}
+
+//validate an operation and turn it into a write, or return the response code and error of its problem
+func toPendingWrite(operation BatchOperation) (pendingWrite, int, *HttpError) {
+    switch operation.Op {
+    case BatchCreate:
+      if operation.Document == nil {
+          return pendingWrite{}, http.StatusBadRequest, &HttpError{CodeValidationFailed, "a create needs a document"}
+      }
+
+      if code, problem := newDocumentProblem(operation.Document); problem != nil {
+          return pendingWrite{}, code, problem
+      }
+
+      name := CreateOperation
+      return pendingWrite{&name, createWrite(*operation.Document)}, http.StatusOK, nil
+    case BatchUpdate:
+      if operation.ID == nil || operation.Document == nil {
+          return pendingWrite{}, http.StatusBadRequest, &HttpError{CodeValidationFailed, "an update needs an id and a document"}
+      }
+
+      if code, problem := patchDocumentProblem(operation.Document); problem != nil {
+          return pendingWrite{}, code, problem
+      }
+
+      if operation.Document.ID != nil && *operation.Document.ID != *operation.ID {
+          return pendingWrite{}, http.StatusBadRequest, &HttpError{CodeValidationFailed, "id of the document does not match the id of the update"}
+      }
+
+      operation.Document.ID = operation.ID
+      name := UpdateOperation
+      return pendingWrite{&name, updateWrite(*operation.Document)}, http.StatusOK, nil
+    case BatchDelete:
+      if operation.ID == nil {
+          return pendingWrite{}, http.StatusBadRequest, &HttpError{CodeValidationFailed, "a delete needs an id"}
+      }
+
+      name := DeleteOperation
+      return pendingWrite{&name, deleteWrite(*operation.ID)}, http.StatusOK, nil
+    default:
+      return pendingWrite{}, http.StatusBadRequest, &HttpError{CodeValidationFailed, "op must be create, update or delete"}
+    }
+}
+
+func handleDocumentTransaction(ginCon *gin.Context) {
+    var request BatchRequest
+
+    if bindErr := ginCon.BindJSON(&request); bindErr != nil {
+      sendJsonHttpResponse(ginCon, http.StatusBadRequest, HttpError{CodeValidationFailed, "illegal structure of json object"})
+      return
+    }
+
+    if len(request.Operations) == 0 || len(request.Operations) > maxBatchOperations {
+      sendJsonHttpResponse(ginCon, http.StatusBadRequest, HttpError{CodeValidationFailed, "operations must hold between 1 and " + toString(maxBatchOperations) + " operations"})
+      return
+    }
+
+    writes := make([]pendingWrite, len(request.Operations))
+
+    for i, operation := range request.Operations {
+        write, code, problem := toPendingWrite(operation)
+
+        if problem != nil {
+          problem.Message = "operation " + toString(i) + ": " + problem.Message
+          sendJsonHttpResponse(ginCon, code, *problem)
+          return
+        }
+
+        if operation.Op != BatchDelete {
+            if violation := policyViolation(*operation.Document); violation != nil {
+              violation.Message = "operation " + toString(i) + ": " + violation.Message
+              sendJsonHttpResponse(ginCon, http.StatusUnprocessableEntity, *violation)
+              return
+            }
+        }
+
+        writes[i] = write
+    }
+
+    write := newWriteRequest(ginCon)
+    write.Batch = true
+    status, failed, documents := writeDocuments(write, writes)
+
+    switch status {
+    case OK:
+      response := BatchResponse{make([]BatchResult, len(documents))}
+
+      for i, document := range documents {
+          response.Results[i] = BatchResult{Op: request.Operations[i].Op, Document: document}
+
+          if document != nil {
+              response.Results[i].ID = *document.ID
+          } else {
+              response.Results[i].ID = *request.Operations[i].ID
+          }
+      }
+
+      sendConsistencyToken(ginCon, write)
+      sendJsonHttpResponse(ginCon, http.StatusOK, response)
+    case CouldNotProceed:
+      sendDbUnavailable(ginCon)
+    default:
+      subject := "the document"
+
+      if request.Operations[failed].ID != nil {
+          subject = documentSubject(*request.Operations[failed].ID)
+      }
+
+      code, problem := statusProblem(status, subject)
+      problem.Message = "operation " + toString(failed) + ": " + problem.Message + "; no operation was applied"
+      sendJsonHttpResponse(ginCon, code, problem)
+    }
+}
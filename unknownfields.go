@@ -0,0 +1,77 @@
+package main
+
+import (
+    "encoding/json"
+    "go.mongodb.org/mongo-driver/bson"
+)
+
+/* stored documents may hold fields this version of the service does not know, written
+by a newer version running alongside it. They are kept in Document.Extra when reading,
+served along with the known fields, and written back when a document is replaced, so
+no version loses the data of another */
+
+//fields MongoDB manages itself, which are neither served nor written back
+var internalFields = map[string]bool{"_id": true}
+
+//unknown fields of a document, without those MongoDB manages
+func withoutInternalFields(extra bson.M) bson.M {
+    kept := bson.M{}
+
+    for key, value := range extra {
+        if !internalFields[key] {
+            kept[key] = value
+        }
+    }
+
+    return kept
+}
+
+//the serializable form of a document, without this method
+type plainDocument Document
+
+//serialize a document with its unknown fields next to the known ones, which win on a clash
+func (document Document) MarshalJSON() ([]byte, error) {
+    //most documents have none, which needs no copy of the map
+    if len(document.Extra) == 0 {
+        return json.Marshal(plainDocument(document))
+    }
+
+    extra := withoutInternalFields(document.Extra)
+
+    if len(extra) == 0 {
+        return json.Marshal(plainDocument(document))
+    }
+
+    serialDocument, serialErr := json.Marshal(plainDocument(document))
+
+    if serialErr != nil {
+        return nil, serialErr
+    }
+
+    fields := make(map[string]json.RawMessage)
+
+    if unmarshalErr := json.Unmarshal(serialDocument, &fields); unmarshalErr != nil {
+        return nil, unmarshalErr
+    }
+
+    //extended json keeps nested documents as objects and tells types json lacks, like dates
+    serialExtra, serialErr := bson.MarshalExtJSON(extra, false, false)
+
+    if serialErr != nil {
+        return nil, serialErr
+    }
+
+    extraFields := make(map[string]json.RawMessage)
+
+    if unmarshalErr := json.Unmarshal(serialExtra, &extraFields); unmarshalErr != nil {
+        return nil, unmarshalErr
+    }
+
+    for key, value := range extraFields {
+        if _, known := fields[key]; !known {
+            fields[key] = value
+        }
+    }
+
+    return json.Marshal(fields)
+}
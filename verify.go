@@ -0,0 +1,143 @@
+package main
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "github.com/gin-gonic/gin"
+    "github.com/skip2/go-qrcode"
+    "net/http"
+    "strings"
+)
+
+/* a paper copy is verified by scanning its QR code, which links to the verification
+endpoint with the content hash the document had when the code was made. The
+endpoint tells whether the document still has that content */
+var publicURL string = strings.TrimSuffix(getEnv("PRECISELY_PUBLIC_URL", "http://localhost:8080"), "/")
+
+const defaultQRSize = 256
+const maxQRSize = 1024
+
+//what a signee agrees to, as described by a document
+type signedContent struct {
+    Title   *string          `json:"title"`
+    Content *DocumentContent `json:"content"`
+    Signee  *string          `json:"signee"`
+}
+
+type Verification struct {
+    DocumentID  int     `json:"documentId"`
+    Title       *string `json:"title"`
+    Signee      *string `json:"signee"`
+    ContentHash string  `json:"contentHash"`           //of the document as it is now
+    CheckedHash string  `json:"checkedHash,omitempty"` //hash from the QR code, if given
+    Matches     *bool   `json:"matches,omitempty"`     //whether the checked hash is the current one
+}
+
+//sha256 over the title, content and signee of a document
+func contentHash(document Document) (string, error) {
+    serialContent, serialErr := json.Marshal(signedContent{document.Title, document.Content, document.Signee})
+
+    if serialErr != nil {
+        return "", serialErr
+    }
+
+    hash := sha256.Sum256(serialContent)
+    return hex.EncodeToString(hash[:]), nil
+}
+
+func verificationURL(id int, hash string) string {
+    return publicURL + "/documents/" + toString(id) + "/verify?hash=" + hash
+}
+
+//read a document from primary, for verification against what is stored right now
+func getDocumentToVerify(ginCon *gin.Context) *Document {
+    id, toIntErr := toInt(getIDParam(ginCon))
+
+    if toIntErr != nil {
+      sendJsonHttpResponse(ginCon, http.StatusBadRequest, HttpError{CodeValidationFailed, "requested id '" + getIDParam(ginCon) + "' is not a number"})
+      return nil
+    }
+
+    status, document := getDocumentIn(ginCon.Request.Context(), id, "primary")
+
+    switch status {
+    case OK:
+      return document
+    default:
+      sendDocumentStatus(ginCon, status, id)
+    }
+
+    return nil
+}
+
+//QR code image linking to the verification of the document's current content
+func handleGetDocumentQR(ginCon *gin.Context) {
+    params := struct {
+        Size int `query:"size" min:"64" max:"maxQRSize"` //in pixels
+    }{defaultQRSize}
+
+    if paramsProblem := bindQuery(ginCon, &params); paramsProblem != "" {
+      sendJsonHttpResponse(ginCon, http.StatusBadRequest, HttpError{CodeValidationFailed, paramsProblem})
+      return
+    }
+
+    size := params.Size
+
+    document := getDocumentToVerify(ginCon)
+
+    if document == nil {
+        return
+    }
+
+    hash, hashErr := contentHash(*document)
+
+    if hashErr != nil {
+      sendJsonHttpResponse(ginCon, http.StatusInternalServerError, HttpError{CodeInternal, "unexpected server state"})
+      return
+    }
+
+    image, qrErr := qrcode.Encode(verificationURL(*document.ID, hash), qrcode.Medium, size)
+
+    if qrErr != nil {
+      sendJsonHttpResponse(ginCon, http.StatusInternalServerError, HttpError{CodeInternal, "unexpected server state"})
+      return
+    }
+
+    ginCon.Data(http.StatusOK, "image/png", image)
+}
+
+//current content hash of the document, compared with the hash from a QR code if given
+func handleVerifyDocument(ginCon *gin.Context) {
+    params := struct {
+        Hash string `query:"hash" hex:"64"` //as handed out in QR codes
+    }{}
+
+    if paramsProblem := bindQuery(ginCon, &params); paramsProblem != "" {
+      sendJsonHttpResponse(ginCon, http.StatusBadRequest, HttpError{CodeValidationFailed, paramsProblem})
+      return
+    }
+
+    document := getDocumentToVerify(ginCon)
+
+    if document == nil {
+        return
+    }
+
+    hash, hashErr := contentHash(*document)
+
+    if hashErr != nil {
+      sendJsonHttpResponse(ginCon, http.StatusInternalServerError, HttpError{CodeInternal, "unexpected server state"})
+      return
+    }
+
+    verification := Verification{DocumentID: *document.ID, Title: document.Title, Signee: document.Signee, ContentHash: hash}
+
+    if params.Hash != "" {
+        matches := params.Hash == hash
+        verification.CheckedHash = params.Hash
+        verification.Matches = &matches
+    }
+
+    sendJsonHttpResponse(ginCon, http.StatusOK, verification)
+}